@@ -0,0 +1,541 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/printer"
+	"go/token"
+	"maps"
+	pathpkg "path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/tools/go/packages"
+)
+
+const (
+	sessionPath = "github.com/aeramu/sql-transaction/session"
+	directive   = "txgen:"
+)
+
+var propagations = map[string]string{
+	"required":     "PropagationRequired",
+	"nested":       "PropagationNested",
+	"requires_new": "PropagationRequiresNew",
+}
+
+var isolations = map[string]string{
+	"default":          "LevelDefault",
+	"read_uncommitted": "LevelReadUncommitted",
+	"read_committed":   "LevelReadCommitted",
+	"write_committed":  "LevelWriteCommitted",
+	"repeatable_read":  "LevelRepeatableRead",
+	"snapshot":         "LevelSnapshot",
+	"serializable":     "LevelSerializable",
+	"linearizable":     "LevelLinearizable",
+}
+
+// settings are the transaction settings of a method, read from its txgen directives
+type settings struct {
+	propagation string
+	isolation   string
+	readOnly    bool
+	retry       int
+	skip        bool
+}
+
+// parseDirectives applies the txgen directives of doc to s, like
+//
+//	//txgen:propagation=nested isolation=serializable readonly retry=3
+func parseDirectives(doc *ast.CommentGroup, s settings) (settings, error) {
+	if doc == nil {
+		return s, nil
+	}
+	for _, c := range doc.List {
+		text := strings.TrimPrefix(c.Text, "//")
+		if !strings.HasPrefix(text, directive) {
+			continue
+		}
+		for _, field := range strings.Fields(strings.TrimPrefix(text, directive)) {
+			key, value, _ := strings.Cut(field, "=")
+			switch key {
+			case "propagation":
+				if _, ok := propagations[value]; !ok {
+					return s, fmt.Errorf("unknown propagation %q", value)
+				}
+				s.propagation = value
+			case "isolation":
+				if _, ok := isolations[value]; !ok {
+					return s, fmt.Errorf("unknown isolation %q", value)
+				}
+				s.isolation = value
+			case "readonly":
+				s.readOnly = value == "" || value == "true"
+			case "retry":
+				n, err := strconv.Atoi(value)
+				if err != nil || n < 1 {
+					return s, fmt.Errorf("invalid retry %q", value)
+				}
+				s.retry = n
+			case "skip":
+				s.skip = true
+			default:
+				return s, fmt.Errorf("unknown directive %q", key)
+			}
+		}
+	}
+	return s, nil
+}
+
+// options returns the session options of s, qualified by the names of the session and database/sql packages
+func (s settings) options(session, sql string) []string {
+	var opts []string
+	if s.propagation != "" && s.propagation != "required" {
+		opts = append(opts, session+".WithPropagation("+session+"."+propagations[s.propagation]+")")
+	}
+	if s.isolation != "" && s.isolation != "default" {
+		opts = append(opts, session+".WithIsolation("+sql+"."+isolations[s.isolation]+")")
+	}
+	if s.readOnly {
+		opts = append(opts, session+".WithReadOnly()")
+	}
+	if s.retry > 1 {
+		opts = append(opts, session+".WithRetry("+strconv.Itoa(s.retry)+")")
+	}
+	return opts
+}
+
+// Config tells the generator which interface to decorate
+type Config struct {
+	// Dir is the package directory holding the interface
+	Dir string
+	// Type is the name of the interface
+	Type string
+	// Name is the name of the decorator, Transactional followed by Type when empty
+	Name string
+}
+
+type generator struct {
+	fset    *token.FileSet
+	file    *ast.File
+	imports map[string]string // name in the file to path
+	used    map[string]bool   // paths used by the generated code
+	// names holds the names the imported packages declare, which their paths do not tell for paths like math/rand/v2
+	names map[string]string
+	// taken holds the names the identifiers of the generated code must not shadow or redeclare:
+	// the package level declarations, the imports and the type parameters
+	taken map[string]bool
+	buf   bytes.Buffer
+}
+
+// Generate returns the source of the decorator of the interface described by cfg
+func Generate(cfg Config) ([]byte, error) {
+	fset := token.NewFileSet()
+	pkgs, err := packages.Load(&packages.Config{
+		Mode: packages.NeedName | packages.NeedFiles | packages.NeedSyntax | packages.NeedImports,
+		Dir:  cfg.Dir,
+		Fset: fset,
+	}, ".")
+	if err != nil {
+		return nil, err
+	}
+	pkg := pkgs[0]
+	if len(pkg.Syntax) == 0 {
+		if len(pkg.Errors) > 0 {
+			return nil, pkg.Errors[0]
+		}
+		return nil, fmt.Errorf("interface %s not found in %s", cfg.Type, filepath.Clean(cfg.Dir))
+	}
+
+	// an import that failed to load has no name, and falls back to the last element of its path
+	names := map[string]string{}
+	for path, imp := range pkg.Imports {
+		if imp.Name != "" {
+			names[path] = imp.Name
+		}
+	}
+	for _, file := range pkg.Syntax {
+		if spec, doc := findInterface(file, cfg.Type); spec != nil {
+			g := &generator{fset: fset, file: file, names: names, used: map[string]bool{}, taken: declared(pkg.Syntax)}
+			return g.generate(cfg, spec, doc)
+		}
+	}
+	return nil, fmt.Errorf("interface %s not found in %s", cfg.Type, filepath.Clean(cfg.Dir))
+}
+
+func findInterface(file *ast.File, name string) (*ast.TypeSpec, *ast.CommentGroup) {
+	for _, decl := range file.Decls {
+		gen, ok := decl.(*ast.GenDecl)
+		if !ok || gen.Tok != token.TYPE {
+			continue
+		}
+		for _, spec := range gen.Specs {
+			ts := spec.(*ast.TypeSpec)
+			if _, ok := ts.Type.(*ast.InterfaceType); ok && ts.Name.Name == name {
+				doc := ts.Doc
+				if doc == nil && len(gen.Specs) == 1 {
+					doc = gen.Doc
+				}
+				return ts, doc
+			}
+		}
+	}
+	return nil, nil
+}
+
+// declared returns the names declared at the package level of files, which the generated file shares
+func declared(files []*ast.File) map[string]bool {
+	names := map[string]bool{}
+	for _, file := range files {
+		for _, decl := range file.Decls {
+			switch decl := decl.(type) {
+			case *ast.FuncDecl:
+				if decl.Recv == nil {
+					names[decl.Name.Name] = true
+				}
+			case *ast.GenDecl:
+				for _, spec := range decl.Specs {
+					switch spec := spec.(type) {
+					case *ast.TypeSpec:
+						names[spec.Name.Name] = true
+					case *ast.ValueSpec:
+						for _, n := range spec.Names {
+							names[n.Name] = true
+						}
+					}
+				}
+			}
+		}
+	}
+	return names
+}
+
+// unique returns base, or base followed by a number, whichever is not in taken, and adds it to taken
+func unique(base string, taken map[string]bool) string {
+	name := base
+	for i := 1; taken[name]; i++ {
+		name = base + strconv.Itoa(i)
+	}
+	taken[name] = true
+	return name
+}
+
+type param struct {
+	name     string
+	typ      string
+	variadic bool
+}
+
+type method struct {
+	name    string
+	params  []param
+	results []string
+	// transactional is set for methods taking a context first and returning an error last
+	transactional bool
+	settings      settings
+}
+
+func (g *generator) generate(cfg Config, spec *ast.TypeSpec, doc *ast.CommentGroup) ([]byte, error) {
+	g.imports = map[string]string{}
+	for _, imp := range g.file.Imports {
+		path, _ := strconv.Unquote(imp.Path.Value)
+		name := g.packageName(path)
+		if imp.Name != nil {
+			name = imp.Name.Name
+		}
+		g.imports[name] = path
+		g.taken[name] = true
+	}
+
+	defaults, err := parseDirectives(doc, settings{})
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", cfg.Type, err)
+	}
+
+	var methods []method
+	methodNames := map[string]bool{}
+	for _, field := range spec.Type.(*ast.InterfaceType).Methods.List {
+		ft, ok := field.Type.(*ast.FuncType)
+		if !ok || len(field.Names) == 0 {
+			return nil, fmt.Errorf("%s: embedded interfaces are not supported, declare the methods instead", cfg.Type)
+		}
+		m, err := g.method(field.Names[0].Name, ft, field.Doc, defaults)
+		if err != nil {
+			return nil, fmt.Errorf("%s.%s: %w", cfg.Type, field.Names[0].Name, err)
+		}
+		methods = append(methods, m)
+		methodNames[m.name] = true
+	}
+
+	name := cfg.Name
+	if name == "" {
+		name = "Transactional" + cfg.Type
+	}
+	typeParams, typeArgs := g.typeParams(spec.TypeParams)
+	iface := cfg.Type + typeArgs
+	recv := name + typeArgs
+
+	// the qualifiers are settled before any local name, so that no local shadows them
+	sess := g.qualifier(sessionPath)
+	for _, m := range methods {
+		if m.transactional && m.settings.isolation != "" && m.settings.isolation != "default" {
+			g.qualifier("database/sql")
+		}
+	}
+	// the fields are named apart from the methods of the interface, which may be unexported
+	fields := maps.Clone(methodNames)
+	d := decorator{recv: recv, next: unique("next", fields), session: unique("session", fields)}
+	locals := maps.Clone(g.taken)
+	next, s := unique("next", locals), unique("s", locals)
+	g.printf("// %s runs the methods of %s in a transaction of a session\n", name, cfg.Type)
+	g.printf("type %s%s struct {\n", name, typeParams)
+	g.printf("%s %s\n", d.next, iface)
+	g.printf("%s %s.Session\n", d.session, sess)
+	g.printf("}\n\n")
+	g.printf("func New%s%s(%s %s, %s %s.Session) *%s {\n", name, typeParams, next, iface, s, sess, recv)
+	g.printf("return &%s{%s: %s, %s: %s}\n", recv, d.next, next, d.session, s)
+	g.printf("}\n")
+
+	for _, m := range methods {
+		g.printf("\n")
+		g.writeMethod(d, m)
+	}
+
+	g.used[sessionPath] = true
+
+	var out bytes.Buffer
+	out.WriteString("// Code generated by txgen. DO NOT EDIT.\n\n")
+	fmt.Fprintf(&out, "package %s\n\n", g.file.Name.Name)
+	out.WriteString(g.importBlock())
+	out.WriteString("\n")
+	out.Write(g.buf.Bytes())
+
+	src, err := format.Source(out.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("failed to format generated code: %w\n%s", err, out.Bytes())
+	}
+	return src, nil
+}
+
+func (g *generator) method(name string, ft *ast.FuncType, doc *ast.CommentGroup, defaults settings) (method, error) {
+	s, err := parseDirectives(doc, defaults)
+	if err != nil {
+		return method{}, err
+	}
+	m := method{name: name, settings: s}
+
+	if ft.Params != nil {
+		for _, field := range ft.Params.List {
+			typ := field.Type
+			variadic := false
+			if ell, ok := typ.(*ast.Ellipsis); ok {
+				typ, variadic = ell.Elt, true
+			}
+			n := max(len(field.Names), 1)
+			for i := 0; i < n; i++ {
+				m.params = append(m.params, param{typ: g.expr(typ), variadic: variadic})
+			}
+		}
+	}
+	if ft.Results != nil {
+		for _, field := range ft.Results.List {
+			n := max(len(field.Names), 1)
+			for i := 0; i < n; i++ {
+				m.results = append(m.results, g.expr(field.Type))
+			}
+		}
+	}
+
+	m.transactional = !s.skip &&
+		len(m.params) > 0 && g.isContext(m.params[0].typ) &&
+		len(m.results) > 0 && m.results[len(m.results)-1] == "error"
+	return m, nil
+}
+
+func (g *generator) isContext(typ string) bool {
+	pkg, name, ok := strings.Cut(typ, ".")
+	return ok && name == "Context" && g.imports[pkg] == "context"
+}
+
+// decorator holds the names of the decorator type and of its fields
+type decorator struct {
+	recv, next, session string
+}
+
+func (g *generator) writeMethod(d decorator, m method) {
+	locals := maps.Clone(g.taken)
+	self := unique("d", locals)
+	var ctx string
+	var params, args []string
+	for i, p := range m.params {
+		var name string
+		if i == 0 && m.transactional {
+			ctx = unique("ctx", locals)
+			name = ctx
+		} else {
+			name = unique("a"+strconv.Itoa(i), locals)
+		}
+		if p.variadic {
+			params = append(params, name+" ..."+p.typ)
+			args = append(args, name+"...")
+		} else {
+			params = append(params, name+" "+p.typ)
+			args = append(args, name)
+		}
+	}
+	call := self + "." + d.next + "." + m.name + "(" + strings.Join(args, ", ") + ")"
+	recv := self + " *" + d.recv
+
+	if !m.transactional {
+		results := strings.Join(m.results, ", ")
+		if len(m.results) > 1 {
+			results = "(" + results + ")"
+		}
+		g.printf("// %s is not run in a transaction\n", m.name)
+		g.printf("func (%s) %s(%s) %s {\n", recv, m.name, strings.Join(params, ", "), results)
+		if len(m.results) > 0 {
+			g.printf("return %s\n", call)
+		} else {
+			g.printf("%s\n", call)
+		}
+		g.printf("}\n")
+		return
+	}
+
+	opts := m.settings.options(g.qualifier(sessionPath), g.qualifier("database/sql"))
+	if m.settings.isolation != "" && m.settings.isolation != "default" {
+		g.used["database/sql"] = true
+	}
+	withTx := self + "." + d.session + ".WithTransaction"
+	optArgs := ""
+	if len(opts) > 0 {
+		optArgs = ", " + strings.Join(opts, ", ")
+	}
+
+	if len(m.results) == 1 {
+		g.printf("func (%s) %s(%s) error {\n", recv, m.name, strings.Join(params, ", "))
+		g.printf("return %s(%s, func(%s %s) error {\n", withTx, ctx, ctx, m.params[0].typ)
+		g.printf("return %s\n", call)
+		g.printf("}%s)\n", optArgs)
+		g.printf("}\n")
+		return
+	}
+
+	var results, names []string
+	for i, r := range m.results[:len(m.results)-1] {
+		name := unique("r"+strconv.Itoa(i), locals)
+		results = append(results, name+" "+r)
+		names = append(names, name)
+	}
+	err := unique("err", locals)
+	results = append(results, err+" error")
+	g.printf("func (%s) %s(%s) (%s) {\n", recv, m.name, strings.Join(params, ", "), strings.Join(results, ", "))
+	g.printf("%s = %s(%s, func(%s %s) error {\n", err, withTx, ctx, ctx, m.params[0].typ)
+	g.printf("var %s error\n", err)
+	g.printf("%s, %s = %s\n", strings.Join(names, ", "), err, call)
+	g.printf("return %s\n", err)
+	g.printf("}%s)\n", optArgs)
+	g.printf("return %s, %s\n", strings.Join(names, ", "), err)
+	g.printf("}\n")
+}
+
+// typeParams returns the type parameter list of the interface and the matching type arguments
+func (g *generator) typeParams(list *ast.FieldList) (string, string) {
+	if list == nil || len(list.List) == 0 {
+		return "", ""
+	}
+	var params, args []string
+	for _, field := range list.List {
+		var names []string
+		for _, n := range field.Names {
+			names = append(names, n.Name)
+		}
+		params = append(params, strings.Join(names, ", ")+" "+g.expr(field.Type))
+		args = append(args, names...)
+		for _, n := range names {
+			g.taken[n] = true
+		}
+	}
+	return "[" + strings.Join(params, ", ") + "]", "[" + strings.Join(args, ", ") + "]"
+}
+
+// expr prints a type expression of the source file and marks the imports it uses
+func (g *generator) expr(e ast.Expr) string {
+	ast.Inspect(e, func(n ast.Node) bool {
+		if sel, ok := n.(*ast.SelectorExpr); ok {
+			if id, ok := sel.X.(*ast.Ident); ok {
+				if path, ok := g.imports[id.Name]; ok {
+					g.used[path] = true
+				}
+			}
+		}
+		return true
+	})
+	var buf bytes.Buffer
+	_ = printer.Fprint(&buf, g.fset, e)
+	return buf.String()
+}
+
+// qualifier returns the name of the package at path in the generated file,
+// picking one apart from the taken names when the source file does not import it
+func (g *generator) qualifier(path string) string {
+	for name, p := range g.imports {
+		if p == path {
+			return name
+		}
+	}
+	name := unique(g.packageName(path), g.taken)
+	g.imports[name] = path
+	return name
+}
+
+// packageName returns the name the package at path declares
+func (g *generator) packageName(path string) string {
+	if name, ok := g.names[path]; ok {
+		return name
+	}
+	return pathpkg.Base(path)
+}
+
+func (g *generator) importBlock() string {
+	var paths []string
+	for path := range g.used {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	var std, others []string
+	for _, path := range paths {
+		spec := strconv.Quote(path)
+		if name := g.qualifier(path); name != g.packageName(path) {
+			spec = name + " " + spec
+		}
+		if first, _, _ := strings.Cut(path, "/"); strings.Contains(first, ".") {
+			others = append(others, spec)
+		} else {
+			std = append(std, spec)
+		}
+	}
+
+	var b strings.Builder
+	b.WriteString("import (\n")
+	for _, spec := range std {
+		b.WriteString("\t" + spec + "\n")
+	}
+	if len(std) > 0 && len(others) > 0 {
+		b.WriteString("\n")
+	}
+	for _, spec := range others {
+		b.WriteString("\t" + spec + "\n")
+	}
+	b.WriteString(")\n")
+	return b.String()
+}
+
+func (g *generator) printf(format string, args ...any) {
+	fmt.Fprintf(&g.buf, format, args...)
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"flag"
+	"go/ast"
+	"go/build"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "update the golden files")
+
+func TestGenerate(t *testing.T) {
+	tests := []struct {
+		dir  string
+		typ  string
+		name string
+	}{
+		{dir: "service", typ: "Service"},
+		{dir: "generic", typ: "Repository", name: "TxRepository"},
+		{dir: "aliased", typ: "Store"},
+		{dir: "clash", typ: "Jobs"},
+		{dir: "versioned", typ: "Dice"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.dir, func(t *testing.T) {
+			dir := filepath.Join("testdata", tt.dir)
+			got, err := Generate(Config{Dir: dir, Type: tt.typ, Name: tt.name})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			golden := filepath.Join(dir, tt.dir+".golden")
+			if *update {
+				if err := os.WriteFile(golden, got, 0o644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("failed to read golden file, run the tests with -update to create it: %v", err)
+			}
+			if string(got) != string(want) {
+				t.Errorf("generated code does not match %s, run the tests with -update to accept it\n--- got\n%s--- want\n%s", golden, got, want)
+			}
+			typeCheck(t, dir, golden, got)
+		})
+	}
+}
+
+// modulePath is the path of the repository, whose packages the type checker reads from the source tree
+const modulePath = "github.com/aeramu/sql-transaction/"
+
+// sourceImporter imports the packages of the repository from their sources, and the others from the standard library
+type sourceImporter struct {
+	fset *token.FileSet
+	std  types.Importer
+	pkgs map[string]*types.Package
+}
+
+func (im *sourceImporter) Import(path string) (*types.Package, error) {
+	rel, ok := strings.CutPrefix(path, modulePath)
+	if !ok {
+		return im.std.Import(path)
+	}
+	if pkg, ok := im.pkgs[path]; ok {
+		return pkg, nil
+	}
+	bp, err := build.ImportDir(filepath.Join("..", "..", rel), 0)
+	if err != nil {
+		return nil, err
+	}
+	var files []*ast.File
+	for _, name := range bp.GoFiles {
+		file, err := parser.ParseFile(im.fset, filepath.Join(bp.Dir, name), nil, 0)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, file)
+	}
+	pkg, err := (&types.Config{Importer: im}).Check(path, im.fset, files, nil)
+	if err != nil {
+		return nil, err
+	}
+	im.pkgs[path] = pkg
+	return pkg, nil
+}
+
+// typeCheck checks the generated source together with the package it decorates
+func typeCheck(t *testing.T, dir, name string, src []byte) {
+	t.Helper()
+	fset := token.NewFileSet()
+	paths, err := filepath.Glob(filepath.Join(dir, "*.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var files []*ast.File
+	for _, path := range paths {
+		file, err := parser.ParseFile(fset, path, nil, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, file)
+	}
+	generated, err := parser.ParseFile(fset, name, src, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files = append(files, generated)
+
+	im := &sourceImporter{fset: fset, std: importer.ForCompiler(fset, "source", nil), pkgs: map[string]*types.Package{}}
+	if _, err := (&types.Config{Importer: im}).Check(files[0].Name.Name, fset, files, nil); err != nil {
+		t.Errorf("generated code does not type check: %v", err)
+	}
+}
+
+func TestGenerate_errors(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{"not found", "package p\n", "interface I not found"},
+		{"embedded", "package p\ntype J interface{ M() }\ntype I interface{ J }\n", "embedded interfaces are not supported"},
+		{"unknown directive", "package p\nimport \"context\"\ntype I interface{\n//txgen:timeout=1s\nM(ctx context.Context) error\n}\n", `unknown directive "timeout"`},
+		{"unknown propagation", "package p\nimport \"context\"\ntype I interface{\n//txgen:propagation=mandatory\nM(ctx context.Context) error\n}\n", `unknown propagation "mandatory"`},
+		{"invalid retry", "package p\nimport \"context\"\ntype I interface{\n//txgen:retry=zero\nM(ctx context.Context) error\n}\n", `invalid retry "zero"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			if err := os.WriteFile(filepath.Join(dir, "go.mod"), []byte("module p\n"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(filepath.Join(dir, "p.go"), []byte(tt.src), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			_, err := Generate(Config{Dir: dir, Type: "I"})
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("got error %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,56 @@
+// Command txgen generates a decorator running the methods of an interface in a transaction of a session.
+//
+//	//go:generate go run github.com/aeramu/sql-transaction/cmd/txgen -type Service
+//
+// Methods taking a context.Context first and returning an error last are wrapped in Session.WithTransaction,
+// the others are delegated as they are.
+// The transaction of a method is configured by txgen directives in its doc comment,
+// and the directives of the interface apply to all of its methods:
+//
+//	//txgen:propagation=nested isolation=serializable readonly retry=3
+//
+// propagation is one of required, nested or requires_new, and isolation one of
+// default, read_uncommitted, read_committed, write_committed, repeatable_read, snapshot, serializable or linearizable.
+// retry runs a new transaction up to that many times on serialization failures and deadlocks,
+// and skip delegates the method without a transaction.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+func main() {
+	typ := flag.String("type", "", "name of the interface to decorate")
+	name := flag.String("name", "", "name of the decorator, Transactional followed by the interface name by default")
+	output := flag.String("output", "", "output file, <type>_txgen.go in the package directory by default")
+	flag.Parse()
+
+	if *typ == "" {
+		fmt.Fprintln(os.Stderr, "txgen: -type is required")
+		flag.Usage()
+		os.Exit(2)
+	}
+	dir := "."
+	if flag.NArg() > 0 {
+		dir = flag.Arg(0)
+	}
+
+	src, err := Generate(Config{Dir: dir, Type: *typ, Name: *name})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "txgen: %v\n", err)
+		os.Exit(1)
+	}
+
+	path := *output
+	if path == "" {
+		path = filepath.Join(dir, strings.ToLower(*typ)+"_txgen.go")
+	}
+	if err := os.WriteFile(path, src, 0o644); err != nil {
+		fmt.Fprintf(os.Stderr, "txgen: %v\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,14 @@
+package aliased
+
+import (
+	stdctx "context"
+	stdsql "database/sql"
+
+	tx "github.com/aeramu/sql-transaction/session"
+)
+
+type Store interface {
+	//txgen:isolation=serializable
+	Transfer(ctx stdctx.Context, from, to string, amount int64) (stdsql.Result, error)
+	Session() tx.Session
+}
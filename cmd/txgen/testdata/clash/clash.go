@@ -0,0 +1,18 @@
+package clash
+
+import (
+	ctx "context"
+	session "net/url"
+)
+
+// sql is declared by the package, so the generated file must import database/sql under another name
+var sql = "SELECT 1"
+
+type d int
+
+type Jobs interface {
+	//txgen:isolation=serializable
+	next(c ctx.Context, u *session.URL) (d, error)
+	session() string
+	Err(c ctx.Context, err error) error
+}
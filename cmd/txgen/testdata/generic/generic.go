@@ -0,0 +1,13 @@
+package generic
+
+import (
+	"context"
+	"fmt"
+)
+
+type Repository[T any, K comparable] interface {
+	//txgen:propagation=nested
+	Save(ctx context.Context, entity T) (K, error)
+	Find(ctx context.Context, keys []K, filter func(T) bool) (map[K]T, error)
+	Describe(v T) fmt.Stringer
+}
@@ -0,0 +1,28 @@
+package service
+
+import (
+	"context"
+	"time"
+)
+
+type User struct {
+	ID      string
+	Created time.Time
+}
+
+// Service manages users
+//
+//txgen:retry=3
+type Service interface {
+	Create(ctx context.Context, name string) (*User, error)
+	// Get only reads
+	//txgen:readonly isolation=repeatable_read
+	Get(ctx context.Context, id string) (*User, bool, error)
+	Delete(ctx context.Context, ids ...string) error
+	//txgen:propagation=requires_new retry=1
+	Audit(ctx context.Context, event string, at time.Time) error
+	//txgen:skip
+	Ping(ctx context.Context) error
+	Name() string
+	Close()
+}
@@ -0,0 +1,11 @@
+package versioned
+
+import (
+	"context"
+	"math/rand/v2"
+)
+
+type Dice interface {
+	Roll(ctx context.Context, src *rand.Rand) (int, error)
+	Seed() *rand.PCG
+}
@@ -0,0 +1,344 @@
+// Package analyzer reports code escaping the transaction of a session:
+// stored database handles used directly in functions receiving a context,
+// WithTransaction closures ignoring their ctx parameter, fresh or outer contexts used in those closures,
+// and goroutines launched with the transactional ctx.
+package analyzer
+
+import (
+	"go/ast"
+	"go/token"
+	"go/types"
+	"strings"
+
+	"golang.org/x/tools/go/analysis"
+	"golang.org/x/tools/go/analysis/passes/inspect"
+	"golang.org/x/tools/go/ast/astutil"
+	"golang.org/x/tools/go/ast/inspector"
+)
+
+var Analyzer = &analysis.Analyzer{
+	Name:     "txlint",
+	Doc:      "reports database access escaping the transaction of a session",
+	Run:      run,
+	Requires: []*analysis.Analyzer{inspect.Analyzer},
+}
+
+// ignoreDirective suppresses the diagnostics of the line it trails,
+// or of the line below it when the comment stands on its own line
+const ignoreDirective = "txlint:ignore"
+
+// handleTypes are the database handles whose stored instances bypass the session transaction
+var handleTypes = []struct{ pkg, name string }{
+	{"database/sql", "DB"},
+	{"gorm.io/gorm", "DB"},
+	{"github.com/jmoiron/sqlx", "DB"},
+}
+
+// sessionPkg provides the functions stripping the transaction of a context
+const sessionPkg = "github.com/aeramu/sql-transaction/session"
+
+// poolMethods are methods of the handles that do not run statements,
+// or start a transaction of their own, and are fine to call on the stored handle
+var poolMethods = map[string]bool{
+	"Begin":              true,
+	"BeginTx":            true,
+	"Beginx":             true,
+	"BeginTxx":           true,
+	"MustBegin":          true,
+	"MustBeginTx":        true,
+	"Transaction":        true,
+	"Close":              true,
+	"Conn":               true,
+	"Driver":             true,
+	"Ping":               true,
+	"PingContext":        true,
+	"Stats":              true,
+	"SetConnMaxIdleTime": true,
+	"SetConnMaxLifetime": true,
+	"SetMaxIdleConns":    true,
+	"SetMaxOpenConns":    true,
+	"DB":                 true,
+}
+
+// adapterMethods implement session.Database and resolve the stored handle on purpose
+var adapterMethods = map[string]bool{
+	"GetDB":     true,
+	"ConvertTx": true,
+}
+
+func run(pass *analysis.Pass) (any, error) {
+	ins := pass.ResultOf[inspect.Analyzer].(*inspector.Inspector)
+
+	ignored := ignoredLines(pass)
+	report := pass.Report
+	pass.Report = func(d analysis.Diagnostic) {
+		p := pass.Fset.Position(d.Pos)
+		if !ignored[p.Filename][p.Line] {
+			report(d)
+		}
+	}
+
+	ins.Preorder([]ast.Node{(*ast.FuncDecl)(nil), (*ast.CallExpr)(nil)}, func(n ast.Node) {
+		switch n := n.(type) {
+		case *ast.FuncDecl:
+			if n.Body != nil && !(n.Recv != nil && adapterMethods[n.Name.Name]) && hasContextParam(pass, n.Type) {
+				checkStoredHandles(pass, n.Body)
+			}
+		case *ast.CallExpr:
+			if lit := transactionClosure(pass, n); lit != nil {
+				checkClosure(pass, lit)
+			}
+		}
+	})
+	return nil, nil
+}
+
+// ignoredLines returns the lines suppressed by an ignore directive, by file name
+func ignoredLines(pass *analysis.Pass) map[string]map[int]bool {
+	lines := make(map[string]map[int]bool)
+	for _, file := range pass.Files {
+		// codeStart holds the position of the first node starting on every line
+		var codeStart map[int]token.Pos
+		for _, group := range file.Comments {
+			for _, c := range group.List {
+				if !strings.Contains(c.Text, ignoreDirective) {
+					continue
+				}
+				if codeStart == nil {
+					codeStart = lineStarts(pass.Fset, file)
+				}
+				p := pass.Fset.Position(c.Slash)
+				if lines[p.Filename] == nil {
+					lines[p.Filename] = make(map[int]bool)
+				}
+				if start, ok := codeStart[p.Line]; ok && start < c.Slash {
+					lines[p.Filename][p.Line] = true
+				} else {
+					lines[p.Filename][p.Line+1] = true
+				}
+			}
+		}
+	}
+	return lines
+}
+
+// lineStarts returns the position of the first node starting on every line of file
+func lineStarts(fset *token.FileSet, file *ast.File) map[int]token.Pos {
+	starts := make(map[int]token.Pos)
+	ast.Inspect(file, func(n ast.Node) bool {
+		switch n.(type) {
+		case nil, *ast.File, *ast.CommentGroup, *ast.Comment:
+			return n != nil
+		}
+		line := fset.Position(n.Pos()).Line
+		if start, ok := starts[line]; !ok || n.Pos() < start {
+			starts[line] = n.Pos()
+		}
+		return true
+	})
+	return starts
+}
+
+// checkStoredHandles reports method calls on database handles stored in struct fields or package variables
+func checkStoredHandles(pass *analysis.Pass, body *ast.BlockStmt) {
+	ast.Inspect(body, func(n ast.Node) bool {
+		call, ok := n.(*ast.CallExpr)
+		if !ok {
+			return true
+		}
+		sel, ok := call.Fun.(*ast.SelectorExpr)
+		if !ok || poolMethods[sel.Sel.Name] || !isHandle(pass.TypesInfo.TypeOf(sel.X)) {
+			return true
+		}
+		if isStored(pass, sel.X) {
+			pass.Reportf(sel.X.Pos(), "direct use of stored database handle %s in a function receiving a context; use DBWrapper.GetDB(ctx) to join the transaction", types.ExprString(sel.X))
+		}
+		return true
+	})
+}
+
+// isStored tells whether expr is a struct field or a package variable, as opposed to a local or a call result
+func isStored(pass *analysis.Pass, expr ast.Expr) bool {
+	switch e := astutil.Unparen(expr).(type) {
+	case *ast.SelectorExpr:
+		if s, ok := pass.TypesInfo.Selections[e]; ok {
+			return s.Kind() == types.FieldVal
+		}
+		// qualified identifier of another package
+		v, ok := pass.TypesInfo.Uses[e.Sel].(*types.Var)
+		return ok && !v.IsField()
+	case *ast.Ident:
+		v, ok := pass.TypesInfo.Uses[e].(*types.Var)
+		return ok && v.Parent() == v.Pkg().Scope()
+	}
+	return false
+}
+
+// transactionClosure returns the function literal passed to a WithTransaction call, or nil
+func transactionClosure(pass *analysis.Pass, call *ast.CallExpr) *ast.FuncLit {
+	sel, ok := call.Fun.(*ast.SelectorExpr)
+	if !ok || sel.Sel.Name != "WithTransaction" {
+		return nil
+	}
+	for _, arg := range call.Args {
+		if lit, ok := astutil.Unparen(arg).(*ast.FuncLit); ok && hasContextParam(pass, lit.Type) {
+			return lit
+		}
+	}
+	return nil
+}
+
+func checkClosure(pass *analysis.Pass, lit *ast.FuncLit) {
+	ctxVar := contextParam(pass, lit.Type)
+	want := "the ctx parameter of the closure"
+	if ctxVar != nil {
+		want = ctxVar.Name()
+	}
+
+	used, calls := false, false
+	// the nested WithTransaction closures, checked on their own
+	nested := make(map[*ast.FuncLit]bool)
+	ast.Inspect(lit.Body, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.FuncLit:
+			if nested[n] {
+				return false
+			}
+		case *ast.GoStmt:
+			if ctxVar != nil && refersTo(pass, n.Call, ctxVar) {
+				pass.Reportf(n.Pos(), "goroutine launched with the transactional %s may outlive the transaction; use session.Detach(%s)", ctxVar.Name(), ctxVar.Name())
+				used = true
+				return false
+			}
+		case *ast.CallExpr:
+			if inner := transactionClosure(pass, n); inner != nil {
+				nested[inner] = true
+			}
+			calls = calls || isFuncCall(pass, n)
+			if name, ok := freshContext(pass, n); ok {
+				pass.Reportf(n.Pos(), "context.%s inside a WithTransaction closure escapes the transaction; use %s", name, want)
+			}
+		case *ast.Ident:
+			v, ok := pass.TypesInfo.Uses[n].(*types.Var)
+			if !ok {
+				return true
+			}
+			if v == ctxVar {
+				used = true
+			} else if isContext(v.Type()) && v.Pos() < lit.Pos() && v.Parent() != v.Pkg().Scope() {
+				pass.Reportf(n.Pos(), "outer context %s used inside a WithTransaction closure escapes the transaction; use %s", n.Name, want)
+			}
+		}
+		return true
+	})
+	// a closure calling nothing cannot run statements outside of the transaction
+	if !used && calls {
+		pass.Reportf(lit.Pos(), "WithTransaction closure ignores its ctx parameter; statements run outside of the transaction")
+	}
+}
+
+// isFuncCall tells whether call calls a function, as opposed to a builtin or a conversion
+func isFuncCall(pass *analysis.Pass, call *ast.CallExpr) bool {
+	tv, ok := pass.TypesInfo.Types[call.Fun]
+	return ok && !tv.IsBuiltin() && !tv.IsType()
+}
+
+// refersTo tells whether node uses v, other than to strip its transaction
+func refersTo(pass *analysis.Pass, node ast.Node, v *types.Var) bool {
+	found := false
+	ast.Inspect(node, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.CallExpr:
+			if isDetach(pass, n) {
+				return false
+			}
+		case *ast.Ident:
+			found = found || pass.TypesInfo.Uses[n] == v
+		}
+		return !found
+	})
+	return found
+}
+
+// isDetach tells whether call is session.Detach or session.WithoutTx
+func isDetach(pass *analysis.Pass, call *ast.CallExpr) bool {
+	var id *ast.Ident
+	switch fun := astutil.Unparen(call.Fun).(type) {
+	case *ast.SelectorExpr:
+		id = fun.Sel
+	case *ast.Ident:
+		id = fun
+	default:
+		return false
+	}
+	fn, ok := pass.TypesInfo.Uses[id].(*types.Func)
+	if !ok || fn.Pkg() == nil || fn.Pkg().Path() != sessionPkg {
+		return false
+	}
+	return fn.Name() == "Detach" || fn.Name() == "WithoutTx"
+}
+
+// freshContext tells whether call is context.Background or context.TODO
+func freshContext(pass *analysis.Pass, call *ast.CallExpr) (string, bool) {
+	sel, ok := call.Fun.(*ast.SelectorExpr)
+	if !ok {
+		return "", false
+	}
+	fn, ok := pass.TypesInfo.Uses[sel.Sel].(*types.Func)
+	if !ok || fn.Pkg() == nil || fn.Pkg().Path() != "context" {
+		return "", false
+	}
+	if fn.Name() == "Background" || fn.Name() == "TODO" {
+		return fn.Name() + "()", true
+	}
+	return "", false
+}
+
+func hasContextParam(pass *analysis.Pass, ft *ast.FuncType) bool {
+	for _, field := range ft.Params.List {
+		if isContext(pass.TypesInfo.TypeOf(field.Type)) {
+			return true
+		}
+	}
+	return false
+}
+
+// contextParam returns the first context parameter of ft, nil when it has none or it is unnamed
+func contextParam(pass *analysis.Pass, ft *ast.FuncType) *types.Var {
+	for _, field := range ft.Params.List {
+		if !isContext(pass.TypesInfo.TypeOf(field.Type)) {
+			continue
+		}
+		if len(field.Names) == 0 {
+			return nil
+		}
+		v, _ := pass.TypesInfo.Defs[field.Names[0]].(*types.Var)
+		return v
+	}
+	return nil
+}
+
+func isContext(t types.Type) bool {
+	return isNamed(t, "context", "Context")
+}
+
+func isHandle(t types.Type) bool {
+	if ptr, ok := t.(*types.Pointer); ok {
+		t = ptr.Elem()
+	}
+	for _, h := range handleTypes {
+		if isNamed(t, h.pkg, h.name) {
+			return true
+		}
+	}
+	return false
+}
+
+func isNamed(t types.Type, pkg, name string) bool {
+	named, ok := t.(*types.Named)
+	if !ok {
+		return false
+	}
+	obj := named.Obj()
+	return obj.Pkg() != nil && obj.Pkg().Path() == pkg && obj.Name() == name
+}
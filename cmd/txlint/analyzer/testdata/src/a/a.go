@@ -0,0 +1,155 @@
+package a
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/aeramu/sql-transaction/session"
+	"github.com/jmoiron/sqlx"
+	"gorm.io/gorm"
+)
+
+type Session interface {
+	WithTransaction(ctx context.Context, f func(ctx context.Context) error) error
+}
+
+// AttemptSession passes the attempt before the ctx of the closure
+type AttemptSession interface {
+	WithTransaction(ctx context.Context, f func(attempt int, ctx context.Context) error) error
+}
+
+type DBWrapper interface {
+	GetDB(ctx context.Context) *sql.DB
+}
+
+var globalDB *sql.DB
+
+type repo struct {
+	db      *sql.DB
+	gdb     *gorm.DB
+	xdb     *sqlx.DB
+	wrapper DBWrapper
+	session Session
+	retrier AttemptSession
+}
+
+func (r *repo) storedHandles(ctx context.Context) {
+	r.db.ExecContext(ctx, "DELETE FROM t")    // want `direct use of stored database handle r.db`
+	r.gdb.WithContext(ctx).Create(nil)        // want `direct use of stored database handle r.gdb`
+	r.xdb.GetContext(ctx, nil, "SELECT 1")    // want `direct use of stored database handle r.xdb`
+	globalDB.QueryRowContext(ctx, "SELECT 1") // want `direct use of stored database handle globalDB`
+	r.wrapper.GetDB(ctx).ExecContext(ctx, "DELETE FROM t")
+	r.db.BeginTx(ctx, nil)
+	r.db.PingContext(ctx)
+}
+
+func (r *repo) local(ctx context.Context, db *sql.DB) {
+	db.ExecContext(ctx, "DELETE FROM t")
+}
+
+func (r *repo) noContext() {
+	r.db.Exec("DELETE FROM t")
+}
+
+func (r *repo) ignoredCtx(ctx context.Context) error {
+	err := r.session.WithTransaction(ctx, func(_ context.Context) error { // want `WithTransaction closure ignores its ctx parameter`
+		return r.wrapper.GetDB(context.TODO()).Ping() // want `context.TODO\(\) inside a WithTransaction closure`
+	})
+	if err != nil {
+		return err
+	}
+	return r.session.WithTransaction(ctx, func(txCtx context.Context) error { // want `WithTransaction closure ignores its ctx parameter`
+		_, err := r.wrapper.GetDB(ctx).ExecContext(ctx, "DELETE FROM t") // want `outer context ctx used inside` `outer context ctx used inside`
+		return err
+	})
+}
+
+func (r *repo) freshContext(ctx context.Context) error {
+	return r.session.WithTransaction(ctx, func(ctx context.Context) error {
+		_, err := r.wrapper.GetDB(ctx).ExecContext(context.Background(), "DELETE FROM t") // want `context.Background\(\) inside a WithTransaction closure`
+		return err
+	})
+}
+
+func (r *repo) goroutine(ctx context.Context) error {
+	return r.session.WithTransaction(ctx, func(ctx context.Context) error {
+		go r.wrapper.GetDB(ctx).ExecContext(ctx, "DELETE FROM t") // want `goroutine launched with the transactional ctx`
+		go func() {                                               // want `goroutine launched with the transactional ctx`
+			r.wrapper.GetDB(ctx).ExecContext(ctx, "DELETE FROM t")
+		}()
+		return nil
+	})
+}
+
+func (r *repo) detachedGoroutine(ctx context.Context) error {
+	return r.session.WithTransaction(ctx, func(ctx context.Context) error {
+		go r.wrapper.GetDB(session.Detach(ctx)).ExecContext(session.Detach(ctx), "DELETE FROM t")
+		go func(ctx context.Context) {
+			r.wrapper.GetDB(ctx).ExecContext(ctx, "DELETE FROM t")
+		}(session.WithoutTx(ctx))
+		return nil
+	})
+}
+
+func (r *repo) correct(ctx context.Context) error {
+	return r.session.WithTransaction(ctx, func(ctx context.Context) error {
+		if _, err := r.wrapper.GetDB(ctx).ExecContext(ctx, "DELETE FROM t"); err != nil {
+			return err
+		}
+		return r.session.WithTransaction(ctx, func(ctx context.Context) error {
+			_, err := r.wrapper.GetDB(ctx).ExecContext(ctx, "DELETE FROM u")
+			return err
+		})
+	})
+}
+
+func (r *repo) ctxNotFirst(ctx context.Context) error {
+	return r.retrier.WithTransaction(ctx, func(attempt int, txCtx context.Context) error {
+		_, err := r.wrapper.GetDB(txCtx).ExecContext(txCtx, "DELETE FROM t")
+		return err
+	})
+}
+
+func (r *repo) ctxNotFirstIgnored(ctx context.Context) error {
+	return r.retrier.WithTransaction(ctx, func(attempt int, txCtx context.Context) error { // want `WithTransaction closure ignores its ctx parameter`
+		_, err := r.wrapper.GetDB(ctx).ExecContext(ctx, "DELETE FROM t") // want `outer context ctx used inside` `outer context ctx used inside`
+		return err
+	})
+}
+
+func (r *repo) nestedHelper(ctx context.Context) error {
+	return r.session.WithTransaction(ctx, func(ctx context.Context) error {
+		run := func(ctx context.Context, query string) error {
+			_, err := r.wrapper.GetDB(ctx).ExecContext(context.Background(), query) // want `context.Background\(\) inside a WithTransaction closure`
+			return err
+		}
+		return run(ctx, "DELETE FROM t")
+	})
+}
+
+func (r *repo) noCalls(ctx context.Context) error {
+	return r.session.WithTransaction(ctx, func(ctx context.Context) error {
+		return nil
+	})
+}
+
+func (r *repo) ignored(ctx context.Context) {
+	//txlint:ignore the journal is written outside of the transaction on purpose
+	r.db.ExecContext(ctx, "INSERT INTO journal DEFAULT VALUES")
+	r.db.ExecContext(ctx, "INSERT INTO journal DEFAULT VALUES") //txlint:ignore
+	r.db.ExecContext(ctx, "INSERT INTO journal DEFAULT VALUES") // want `direct use of stored database handle r.db`
+}
+
+type adapter struct {
+	db *sql.DB
+}
+
+func (a *adapter) GetDB(ctx context.Context) *sql.DB {
+	a.db.Stats()
+	return a.db
+}
+
+func (a *adapter) ConvertTx(ctx context.Context, tx *sql.Tx) *sql.Tx {
+	a.db.QueryRowContext(ctx, "SELECT 1")
+	return tx
+}
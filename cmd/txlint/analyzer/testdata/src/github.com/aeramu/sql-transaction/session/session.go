@@ -0,0 +1,6 @@
+package session
+
+import "context"
+
+func Detach(ctx context.Context) context.Context    { return ctx }
+func WithoutTx(ctx context.Context) context.Context { return ctx }
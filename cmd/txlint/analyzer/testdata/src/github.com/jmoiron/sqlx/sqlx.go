@@ -0,0 +1,7 @@
+package sqlx
+
+import "context"
+
+type DB struct{}
+
+func (db *DB) GetContext(ctx context.Context, dest any, query string, args ...any) error { return nil }
@@ -0,0 +1,14 @@
+// Command txlint reports database access escaping the transaction of a session.
+//
+//	go run github.com/aeramu/sql-transaction/cmd/txlint ./...
+package main
+
+import (
+	"golang.org/x/tools/go/analysis/singlechecker"
+
+	"github.com/aeramu/sql-transaction/cmd/txlint/analyzer"
+)
+
+func main() {
+	singlechecker.Main(analyzer.Analyzer)
+}
@@ -29,3 +29,16 @@ func (db *DB) ConvertTx(ctx context.Context, tx *sql.Tx) *gorm.DB {
 	gormTx.Statement.ConnPool = tx
 	return gormTx
 }
+
+// BindContext returns the handle cached for the transaction bound to ctx.
+// It returns the cached handle as is for the context it was converted with,
+// and otherwise begins a new gorm session, which allocates as much as converting the transaction again.
+func (db *DB) BindContext(ctx context.Context, gormTx *gorm.DB) *gorm.DB {
+	if gormTx.Statement.Context == ctx {
+		return gormTx
+	}
+	return gormTx.Session(&gorm.Session{
+		Context: ctx,
+		NewDB:   true,
+	})
+}
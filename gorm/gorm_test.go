@@ -3,13 +3,18 @@ package transaction
 import (
 	"context"
 	"database/sql"
+	"database/sql/driver"
 	"errors"
+	"path/filepath"
 	"testing"
 
 	"gorm.io/gorm/logger"
 
 	"github.com/aeramu/sql-transaction/session"
-	_ "github.com/mattn/go-sqlite3"
+	"github.com/aeramu/sql-transaction/session/sessionfault"
+	"github.com/aeramu/sql-transaction/session/sessionrecord"
+	"github.com/aeramu/sql-transaction/session/sessiontest"
+	"github.com/mattn/go-sqlite3"
 	"github.com/stretchr/testify/suite"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
@@ -197,6 +202,171 @@ func (s *TransactionTestSuite) TestWithTransaction_doubleTransactionRolledBack()
 	s.ErrorIs(res.Error, gorm.ErrRecordNotFound)
 }
 
+func (s *TransactionTestSuite) TestSessiontest_rolledBackAfterTest() {
+	data := model{ID: "test-sessiontest"}
+	s.Run("writes", func() {
+		ctx := sessiontest.Begin(s.T(), s.db)
+		err := s.session.WithTransaction(ctx, func(ctx context.Context) error {
+			return s.wrapper.GetDB(ctx).Create(&data).Error
+		})
+		s.NoError(err)
+
+		var inserted model
+		res := s.wrapper.GetDB(ctx).First(&inserted, "id = ?", data.ID)
+		s.NoError(res.Error)
+		s.Equal(data, inserted)
+	})
+
+	var inserted model
+	res := s.gdb.First(&inserted, "id = ?", data.ID)
+	s.ErrorIs(res.Error, gorm.ErrRecordNotFound)
+}
+
+func (s *TransactionTestSuite) TestGetDB_cachedPerTransaction() {
+	var first *gorm.DB
+	err := s.session.WithTransaction(context.Background(), func(ctx context.Context) error {
+		first = s.wrapper.GetDB(ctx)
+		s.Same(first, s.wrapper.GetDB(ctx))
+		s.Zero(testing.AllocsPerRun(10, func() { s.wrapper.GetDB(ctx) }))
+
+		derived, cancel := context.WithCancel(ctx)
+		defer cancel()
+		db := s.wrapper.GetDB(derived)
+		s.NotSame(first, db)
+		s.Equal(session.GetTx(ctx), db.Statement.ConnPool)
+		s.Equal(derived, db.Statement.Context)
+		return db.Create(&model{ID: "cached"}).Error
+	})
+	s.Require().NoError(err)
+
+	err = s.session.WithTransaction(context.Background(), func(ctx context.Context) error {
+		s.NotSame(first, s.wrapper.GetDB(ctx))
+		return nil
+	})
+	s.Require().NoError(err)
+}
+
 func TestTransactionTestSuite(t *testing.T) {
 	suite.Run(t, new(TransactionTestSuite))
 }
+
+func TestAdapterConformance(t *testing.T) {
+	sessiontest.RunAdapterSuite(t, func(t *testing.T) sessiontest.Adapter[*gorm.DB] {
+		db, err := sql.Open("sqlite3", "file:"+filepath.Join(t.TempDir(), "test.db")+"?_busy_timeout=5000&_txlock=immediate")
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { db.Close() })
+
+		gdb, err := gorm.Open(sqlite.New(sqlite.Config{Conn: db}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		gdb.Logger = logger.Default.LogMode(logger.Silent)
+
+		return sessiontest.Adapter[*gorm.DB]{
+			DB:       db,
+			Database: &DB{gormDB: gdb},
+			Exec: func(ctx context.Context, db *gorm.DB, query string, args ...any) error {
+				return db.WithContext(ctx).Exec(query, args...).Error
+			},
+			QueryInt: func(ctx context.Context, db *gorm.DB, query string, args ...any) (int64, error) {
+				var n int64
+				err := db.WithContext(ctx).Raw(query, args...).Scan(&n).Error
+				return n, err
+			},
+		}
+	})
+}
+
+func TestWithTransaction_commitFault(t *testing.T) {
+	in := sessionfault.New(sessionfault.Fail(sessionfault.OpCommit, driver.ErrBadConn))
+	db := in.OpenDB(&sqlite3.SQLiteDriver{}, "file:"+filepath.Join(t.TempDir(), "test.db")+"?_busy_timeout=5000")
+	defer db.Close()
+
+	gdb, err := gorm.Open(sqlite.New(sqlite.Config{Conn: db}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	gdb.Logger = logger.Default.LogMode(logger.Silent)
+	if err := gdb.AutoMigrate(&model{}); err != nil {
+		t.Fatal(err)
+	}
+	wrapper := NewDB(gdb)
+
+	err = session.NewSession(db).WithTransaction(context.Background(), func(ctx context.Context) error {
+		return wrapper.GetDB(ctx).Create(&model{ID: "1"}).Error
+	})
+	if !errors.Is(err, driver.ErrBadConn) {
+		t.Fatalf("WithTransaction: got %v, want %v", err, driver.ErrBadConn)
+	}
+
+	var n int64
+	if err := gdb.Model(&model{}).Count(&n).Error; err != nil {
+		t.Fatal(err)
+	}
+	if n != 0 {
+		t.Errorf("got %d rows, want 0", n)
+	}
+}
+
+func TestSessiontest_withRecording(t *testing.T) {
+	db := sessionrecord.New().OpenDB(&sqlite3.SQLiteDriver{}, "file:"+filepath.Join(t.TempDir(), "test.db")+"?_busy_timeout=5000")
+	defer db.Close()
+
+	gdb, err := gorm.Open(sqlite.New(sqlite.Config{Conn: db}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	gdb.Logger = logger.Default.LogMode(logger.Silent)
+	if err := gdb.AutoMigrate(&model{}); err != nil {
+		t.Fatal(err)
+	}
+	wrapper := NewDB(gdb)
+
+	ctx := sessiontest.Begin(t, db, sessiontest.WithRecording())
+	err = session.NewSession(db).WithTransaction(ctx, func(ctx context.Context) error {
+		if err := wrapper.GetDB(ctx).Create(&model{ID: "1"}).Error; err != nil {
+			return err
+		}
+		var m model
+		return wrapper.GetDB(ctx).First(&m, "id = ?", "1").Error
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func BenchmarkGetDB(b *testing.B) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer db.Close()
+	gdb, err := gorm.Open(sqlite.New(sqlite.Config{Conn: db}))
+	if err != nil {
+		b.Fatal(err)
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer tx.Rollback()
+	wrapper := NewDB(gdb)
+
+	b.Run("cached", func(b *testing.B) {
+		ctx := session.WithExternalTx(context.Background(), tx, session.PropagationRequired)
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			_ = wrapper.GetDB(ctx)
+		}
+	})
+	b.Run("uncached", func(b *testing.B) {
+		adapter := &DB{gormDB: gdb}
+		ctx := session.WithExternalTx(context.Background(), tx, session.PropagationRequired)
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			_ = adapter.ConvertTx(ctx, tx)
+		}
+	})
+}
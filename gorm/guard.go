@@ -0,0 +1,48 @@
+package transaction
+
+import (
+	"gorm.io/gorm"
+
+	"github.com/aeramu/sql-transaction/session"
+)
+
+// TxGuard returns a gorm plugin failing the statements of a handle whose session transaction committed or rolled back
+// with session.ErrTxFinished, instead of running them on the ended transaction, to add with db.Use.
+// It is the gorm counterpart of session.WithTxGuard.
+func TxGuard() gorm.Plugin {
+	return txGuardPlugin{}
+}
+
+type txGuardPlugin struct{}
+
+func (txGuardPlugin) Name() string {
+	return "session:tx_guard"
+}
+
+func (txGuardPlugin) Initialize(db *gorm.DB) error {
+	callbacks := db.Callback()
+	if err := callbacks.Create().Before("*").Register("session:tx_guard", checkFinished); err != nil {
+		return err
+	}
+	if err := callbacks.Update().Before("*").Register("session:tx_guard", checkFinished); err != nil {
+		return err
+	}
+	if err := callbacks.Delete().Before("*").Register("session:tx_guard", checkFinished); err != nil {
+		return err
+	}
+	if err := callbacks.Raw().Before("*").Register("session:tx_guard", checkFinished); err != nil {
+		return err
+	}
+	if err := callbacks.Row().Before("*").Register("session:tx_guard", checkFinished); err != nil {
+		return err
+	}
+	return callbacks.Query().Before("*").Register("session:tx_guard", checkFinished)
+}
+
+func checkFinished(db *gorm.DB) {
+	if st := session.Current(db.Statement.Context); st != nil {
+		if err := st.Err(); err != nil {
+			db.AddError(err)
+		}
+	}
+}
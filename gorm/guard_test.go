@@ -0,0 +1,44 @@
+package transaction
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	"github.com/aeramu/sql-transaction/session"
+)
+
+func TestTxGuard(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+	gdb, err := gorm.Open(sqlite.New(sqlite.Config{Conn: db}))
+	require.NoError(t, err)
+	gdb.Logger = logger.Default.LogMode(logger.Silent)
+	require.NoError(t, gdb.AutoMigrate(&model{}))
+	require.NoError(t, gdb.Use(TxGuard()))
+
+	wrapper := NewDB(gdb)
+	sess := session.NewSession(db)
+	var tx *gorm.DB
+	err = sess.WithTransaction(context.Background(), func(ctx context.Context) error {
+		tx = wrapper.GetDB(ctx)
+		return tx.Create(&model{ID: "a"}).Error
+	})
+	require.NoError(t, err)
+
+	assert.ErrorIs(t, tx.Create(&model{ID: "b"}).Error, session.ErrTxFinished)
+	assert.ErrorIs(t, tx.Exec("DELETE FROM models").Error, session.ErrTxFinished)
+	var models []model
+	assert.ErrorIs(t, tx.Find(&models).Error, session.ErrTxFinished)
+
+	require.NoError(t, gdb.Find(&models).Error)
+	assert.Len(t, models, 1)
+}
@@ -0,0 +1,70 @@
+package transaction
+
+import (
+	"fmt"
+
+	"gorm.io/gorm"
+
+	"github.com/aeramu/sql-transaction/session"
+)
+
+// Interceptors returns a gorm plugin running the statements of the creates, queries, updates, deletes,
+// rows and raw statements of the database through interceptors, to add with db.Use.
+// It also records the statements of the transactions of a session with a watchdog or a registry,
+// which gorm databases without the plugin leave unrecorded, even with no interceptors.
+func Interceptors(interceptors ...session.Interceptor) gorm.Plugin {
+	return &interceptorPlugin{interceptors: interceptors}
+}
+
+type interceptorPlugin struct {
+	interceptors []session.Interceptor
+}
+
+func (p *interceptorPlugin) Name() string {
+	return "session:interceptors"
+}
+
+func (p *interceptorPlugin) Initialize(db *gorm.DB) error {
+	callbacks := db.Callback()
+	processors := []struct {
+		processor interface {
+			Get(name string) func(*gorm.DB)
+			Replace(name string, fn func(*gorm.DB)) error
+		}
+		name string
+	}{
+		{callbacks.Create(), "gorm:create"},
+		{callbacks.Query(), "gorm:query"},
+		{callbacks.Update(), "gorm:update"},
+		{callbacks.Delete(), "gorm:delete"},
+		{callbacks.Row(), "gorm:row"},
+		{callbacks.Raw(), "gorm:raw"},
+	}
+	for _, c := range processors {
+		run := c.processor.Get(c.name)
+		if run == nil {
+			return fmt.Errorf("callback %s not registered", c.name)
+		}
+		if err := c.processor.Replace(c.name, p.intercept(run)); err != nil {
+			return fmt.Errorf("failed to replace callback %s: %w", c.name, err)
+		}
+	}
+	return nil
+}
+
+// intercept runs a gorm callback with a connection pool running its statements through the interceptors,
+// since gorm only builds the statement while running it
+func (p *interceptorPlugin) intercept(run func(*gorm.DB)) func(*gorm.DB) {
+	return func(db *gorm.DB) {
+		interceptors := p.interceptors
+		if track := session.TrackStatements(session.Current(db.Statement.Context)); track != nil {
+			interceptors = append([]session.Interceptor{track}, interceptors...)
+		}
+		pool := db.Statement.ConnPool
+		db.Statement.ConnPool = &session.InterceptedRunner{Next: pool, Interceptors: interceptors}
+		defer func() {
+			db.Statement.ConnPool = pool
+		}()
+		run(db)
+	}
+}
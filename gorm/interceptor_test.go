@@ -0,0 +1,178 @@
+package transaction
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	"github.com/aeramu/sql-transaction/session"
+)
+
+func openInterceptorTestDB(t *testing.T, interceptors ...session.Interceptor) (*sql.DB, *gorm.DB) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	return setupInterceptorTestDB(t, db, interceptors...)
+}
+
+// setupInterceptorTestDB migrates the models of the tests in db, which is closed once the test completes
+func setupInterceptorTestDB(t *testing.T, db *sql.DB, interceptors ...session.Interceptor) (*sql.DB, *gorm.DB) {
+	t.Cleanup(func() { db.Close() })
+	db.SetMaxOpenConns(1)
+
+	gdb, err := gorm.Open(sqlite.New(sqlite.Config{Conn: db}))
+	require.NoError(t, err)
+	gdb.Logger = logger.Default.LogMode(logger.Silent)
+	require.NoError(t, gdb.AutoMigrate(&model{}))
+	require.NoError(t, gdb.Use(Interceptors(interceptors...)))
+	return db, gdb
+}
+
+func TestInterceptors_statement(t *testing.T) {
+	var seen []session.Statement
+	db, gdb := openInterceptorTestDB(t, func(ctx context.Context, stmt *session.Statement, next session.StatementHandler) error {
+		err := next(ctx, stmt)
+		seen = append(seen, *stmt)
+		return err
+	})
+	wrapper := NewDB(gdb)
+
+	var txID string
+	err := session.NewSession(db).WithTransaction(context.Background(), func(ctx context.Context) error {
+		txID = session.TxID(ctx)
+		return wrapper.GetDB(ctx).Create(&model{ID: "a"}).Error
+	})
+	require.NoError(t, err)
+
+	var m model
+	require.NoError(t, gdb.First(&m).Error)
+	var count int
+	require.NoError(t, gdb.Model(&model{}).Select("COUNT(*)").Row().Scan(&count))
+	assert.Equal(t, 1, count)
+
+	require.Len(t, seen, 3)
+	assert.Equal(t, session.OpExec, seen[0].Op)
+	assert.Contains(t, seen[0].Query, "INSERT INTO `models`")
+	assert.Equal(t, []any{"a"}, seen[0].Args)
+	assert.Equal(t, txID, seen[0].TxID)
+	assert.Positive(t, seen[0].Duration)
+	require.NotNil(t, seen[0].Result)
+	affected, err := seen[0].Result.RowsAffected()
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), affected)
+
+	assert.Equal(t, session.OpQuery, seen[1].Op)
+	assert.Empty(t, seen[1].TxID)
+	assert.Equal(t, session.OpQueryRow, seen[2].Op)
+}
+
+func TestInterceptors_rewrite(t *testing.T) {
+	var before []string
+	_, gdb := openInterceptorTestDB(t, func(ctx context.Context, stmt *session.Statement, next session.StatementHandler) error {
+		// the statement is built before it runs, so interceptors can read and rewrite it
+		before = append(before, stmt.Query)
+		if stmt.Op == session.OpExec {
+			stmt.Args = []any{"rewritten"}
+		}
+		return next(ctx, stmt)
+	})
+
+	require.NoError(t, gdb.Create(&model{ID: "a"}).Error)
+	var m model
+	require.NoError(t, gdb.First(&m).Error)
+	assert.Equal(t, "rewritten", m.ID)
+
+	require.Len(t, before, 2)
+	assert.Contains(t, before[0], "INSERT INTO `models`")
+	assert.Contains(t, before[1], "SELECT * FROM `models`")
+}
+
+func TestInterceptors_reject(t *testing.T) {
+	errRejected := errors.New("rejected")
+	_, gdb := openInterceptorTestDB(t, func(ctx context.Context, stmt *session.Statement, next session.StatementHandler) error {
+		return errRejected
+	})
+
+	assert.ErrorIs(t, gdb.Create(&model{ID: "a"}).Error, errRejected)
+	assert.ErrorIs(t, gdb.Exec("DELETE FROM models").Error, errRejected)
+	var m model
+	assert.ErrorIs(t, gdb.First(&m).Error, errRejected)
+	var count int
+	assert.ErrorIs(t, gdb.Model(&model{}).Select("COUNT(*)").Row().Scan(&count), errRejected)
+	_, err := gdb.Model(&model{}).Rows()
+	assert.ErrorIs(t, err, errRejected)
+}
+
+func TestInterceptors_queryComment(t *testing.T) {
+	var queries []string
+	db, gdb := openInterceptorTestDB(t, session.QueryComment(), func(ctx context.Context, stmt *session.Statement, next session.StatementHandler) error {
+		queries = append(queries, stmt.Query)
+		return next(ctx, stmt)
+	})
+	wrapper := NewDB(gdb)
+
+	ctx := session.WithQueryTag(context.Background(), "route", "/models")
+	var txID string
+	err := session.NewSession(db).WithTransaction(ctx, func(ctx context.Context) error {
+		txID = session.TxID(ctx)
+		return wrapper.GetDB(ctx).Create(&model{ID: "a"}).Error
+	})
+	require.NoError(t, err)
+
+	require.Len(t, queries, 1)
+	assert.Contains(t, queries[0], "INSERT INTO `models`")
+	assert.True(t, strings.HasSuffix(queries[0], " /*route='%2Fmodels',tx_id='"+txID+"'*/"), queries[0])
+	var count int64
+	require.NoError(t, gdb.Model(&model{}).Count(&count).Error)
+	assert.Equal(t, int64(1), count)
+}
+
+func TestInterceptors_trackStatements(t *testing.T) {
+	tests := []struct {
+		name string
+		open func() *sql.DB
+	}{
+		{"driver", func() *sql.DB {
+			db, err := sql.Open("sqlite3", ":memory:")
+			require.NoError(t, err)
+			return db
+		}},
+		// the driver routes no statement of the transaction reaching its own connection, so none is counted twice
+		{"wrapped driver", func() *sql.DB {
+			return sql.OpenDB(session.Connector(&sqlite3.SQLiteDriver{}, ":memory:"))
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, gdb := setupInterceptorTestDB(t, tt.open())
+			wrapper := NewDB(gdb)
+			registry := session.NewRegistry()
+
+			err := session.NewSession(db, session.WithRegistry(registry)).WithTransaction(context.Background(), func(ctx context.Context) error {
+				tx := wrapper.GetDB(ctx)
+				if err := tx.Create(&model{ID: "a"}).Error; err != nil {
+					return err
+				}
+				var m model
+				if err := tx.First(&m, "id = ?", "a").Error; err != nil {
+					return err
+				}
+
+				snapshot := registry.Snapshot()
+				require.Len(t, snapshot, 1)
+				assert.Equal(t, int64(2), snapshot[0].Statements)
+				assert.Contains(t, snapshot[0].LastStatement, "SELECT * FROM `models`")
+				return nil
+			})
+			require.NoError(t, err)
+		})
+	}
+}
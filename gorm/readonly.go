@@ -0,0 +1,62 @@
+package transaction
+
+import (
+	"fmt"
+
+	"gorm.io/gorm"
+
+	"github.com/aeramu/sql-transaction/session"
+)
+
+// ReadOnly returns a gorm plugin refusing creates, updates and deletes inside a transaction begun
+// session.WithReadOnly with session.ErrReadOnlyViolation, to add with db.Use.
+// Raw statements and queries are classified with session.WriteKeyword.
+func ReadOnly() gorm.Plugin {
+	return readOnlyPlugin{}
+}
+
+type readOnlyPlugin struct{}
+
+func (readOnlyPlugin) Name() string {
+	return "session:read_only"
+}
+
+func (readOnlyPlugin) Initialize(db *gorm.DB) error {
+	callbacks := db.Callback()
+	if err := callbacks.Create().Before("*").Register("session:read_only", refuseWrite("INSERT")); err != nil {
+		return err
+	}
+	if err := callbacks.Update().Before("*").Register("session:read_only", refuseWrite("UPDATE")); err != nil {
+		return err
+	}
+	if err := callbacks.Delete().Before("*").Register("session:read_only", refuseWrite("DELETE")); err != nil {
+		return err
+	}
+	// raw statements are built before their callbacks run, unlike the queries gorm builds itself
+	if err := callbacks.Raw().Before("*").Register("session:read_only", checkRaw); err != nil {
+		return err
+	}
+	if err := callbacks.Row().Before("*").Register("session:read_only", checkRaw); err != nil {
+		return err
+	}
+	return callbacks.Query().Before("*").Register("session:read_only", checkRaw)
+}
+
+// refuseWrite fails the statements of a callback writing with keyword in a read-only transaction
+func refuseWrite(keyword string) func(*gorm.DB) {
+	return func(db *gorm.DB) {
+		ctx := db.Statement.Context
+		if st := session.Current(ctx); st != nil && st.Options().ReadOnly {
+			db.AddError(fmt.Errorf("%w: %s in transaction %s", session.ErrReadOnlyViolation, keyword, st.ID()))
+		}
+	}
+}
+
+func checkRaw(db *gorm.DB) {
+	if db.Statement.SQL.Len() == 0 {
+		return
+	}
+	if err := session.CheckReadOnly(db.Statement.Context, db.Statement.SQL.String()); err != nil {
+		db.AddError(err)
+	}
+}
@@ -0,0 +1,55 @@
+package transaction
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	"github.com/aeramu/sql-transaction/session"
+)
+
+func TestReadOnly(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+	gdb, err := gorm.Open(sqlite.New(sqlite.Config{Conn: db}))
+	require.NoError(t, err)
+	gdb.Logger = logger.Default.LogMode(logger.Silent)
+	require.NoError(t, gdb.AutoMigrate(&model{}))
+	require.NoError(t, gdb.Use(ReadOnly()))
+	require.NoError(t, gdb.Create(&model{ID: "a"}).Error)
+
+	wrapper := NewDB(gdb)
+	sess := session.NewSession(db)
+	err = sess.WithTransaction(context.Background(), func(ctx context.Context) error {
+		tx := wrapper.GetDB(ctx)
+		var models []model
+		require.NoError(t, tx.Find(&models).Error)
+		assert.Len(t, models, 1)
+		require.NoError(t, tx.Raw("SELECT id FROM models").Scan(&models).Error)
+
+		assert.ErrorIs(t, tx.Create(&model{ID: "b"}).Error, session.ErrReadOnlyViolation)
+		assert.ErrorIs(t, tx.Model(&model{ID: "a"}).Update("id", "c").Error, session.ErrReadOnlyViolation)
+		assert.ErrorIs(t, tx.Delete(&model{ID: "a"}).Error, session.ErrReadOnlyViolation)
+		assert.ErrorIs(t, tx.Exec("DELETE FROM models").Error, session.ErrReadOnlyViolation)
+		assert.ErrorIs(t, tx.Raw("DELETE FROM models RETURNING id").Scan(&models).Error, session.ErrReadOnlyViolation)
+		return nil
+	}, session.WithReadOnly())
+	require.NoError(t, err)
+
+	var count int64
+	require.NoError(t, gdb.Model(&model{}).Count(&count).Error)
+	assert.Equal(t, int64(1), count)
+
+	err = sess.WithTransaction(context.Background(), func(ctx context.Context) error {
+		return wrapper.GetDB(ctx).Create(&model{ID: "b"}).Error
+	})
+	assert.NoError(t, err)
+}
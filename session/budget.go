@@ -0,0 +1,152 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// ErrBudgetExceeded is returned for a statement exceeding the budget of its transaction, which is then marked
+// rollback-only, or of a savepoint, which is then rolled back even when the function of its call returns nil
+var ErrBudgetExceeded = errors.New("transaction budget exceeded")
+
+// Budget bounds the statements of a transaction or a savepoint, a zero limit leaving them unbounded.
+// It is enforced on the statements run in the transaction by the executors of this package and of the sqlx adapter,
+// and by gorm databases with the Interceptors plugin.
+type Budget struct {
+	MaxStatements int64
+	// MaxRowsAffected bounds the sum of the rows affected reported by the statements
+	MaxRowsAffected int64
+	// MaxStatementDuration bounds the wall time of each statement, which runs with a deadline that far
+	MaxStatementDuration time.Duration
+}
+
+// orDefault fills the zero limits of b with the ones of def
+func (b Budget) orDefault(def Budget) Budget {
+	if b.MaxStatements == 0 {
+		b.MaxStatements = def.MaxStatements
+	}
+	if b.MaxRowsAffected == 0 {
+		b.MaxRowsAffected = def.MaxRowsAffected
+	}
+	if b.MaxStatementDuration == 0 {
+		b.MaxStatementDuration = def.MaxStatementDuration
+	}
+	return b
+}
+
+// BudgetUsage counts the statements run in a transaction or a savepoint by the executors enforcing its budget
+type BudgetUsage struct {
+	Statements       int64
+	RowsAffected     int64
+	LongestStatement time.Duration
+}
+
+type budgetCounters struct {
+	statements   atomic.Int64
+	rowsAffected atomic.Int64
+	longest      atomic.Int64
+}
+
+// Budget returns the budget of the transaction, or of the savepoint for a nested call
+func (st *TxState) Budget() Budget {
+	return st.budget
+}
+
+// Usage returns what the statements of the transaction, or of the savepoint for a nested call, used of its budget
+func (st *TxState) Usage() BudgetUsage {
+	return BudgetUsage{
+		Statements:       st.usage.statements.Load(),
+		RowsAffected:     st.usage.rowsAffected.Load(),
+		LongestStatement: time.Duration(st.usage.longest.Load()),
+	}
+}
+
+// EnforceBudget returns an interceptor counting the statements of the transaction carried by their context
+// and of its savepoints, and refusing them with ErrBudgetExceeded once they exceed a budget set
+// WithBudget or WithDefaultBudget, which marks the transaction rollback-only.
+// A statement running longer than the MaxStatementDuration has its context cancelled.
+// The executors of a budgeted transaction already enforce its budget, see TrackStatements,
+// so the interceptor is only needed on executors running statements in it otherwise, and counts every statement once.
+func EnforceBudget() Interceptor {
+	return enforceBudget
+}
+
+func enforceBudget(ctx context.Context, stmt *Statement, next StatementHandler) error {
+	st := getState(ctx)
+	if st == nil || stmt.budgeted {
+		return next(ctx, stmt)
+	}
+	stmt.budgeted = true
+
+	var maxDuration time.Duration
+	var durationOwner *TxState
+	var exceeded error
+	for s := st; s != nil; s = s.parent {
+		n := s.usage.statements.Add(1)
+		if s.budget.MaxStatements > 0 && n > s.budget.MaxStatements {
+			err := budgetExceeded(ctx, s, "%d statements, limit %d", n, s.budget.MaxStatements)
+			if exceeded == nil {
+				exceeded = err
+			}
+		}
+		if d := s.budget.MaxStatementDuration; d > 0 && (maxDuration == 0 || d < maxDuration) {
+			maxDuration, durationOwner = d, s
+		}
+	}
+	if exceeded != nil {
+		return exceeded
+	}
+
+	var err error
+	timedOut := false
+	if maxDuration > 0 {
+		timedOut, err = runWithTimeout(ctx, stmt, maxDuration, ErrBudgetExceeded, next)
+	} else {
+		err = next(ctx, stmt)
+	}
+
+	var affected int64
+	if stmt.Result != nil && err == nil {
+		affected, _ = stmt.Result.RowsAffected()
+	}
+	for s := st; s != nil; s = s.parent {
+		longest := s.usage.longest.Load()
+		for int64(stmt.Duration) > longest && !s.usage.longest.CompareAndSwap(longest, int64(stmt.Duration)) {
+			longest = s.usage.longest.Load()
+		}
+		n := s.usage.rowsAffected.Add(max(affected, 0))
+		if s.budget.MaxRowsAffected > 0 && n > s.budget.MaxRowsAffected {
+			exceeded := budgetExceeded(ctx, s, "%d rows affected, limit %d", n, s.budget.MaxRowsAffected)
+			if err == nil {
+				err = exceeded
+			}
+		}
+	}
+	if timedOut || maxDuration > 0 && stmt.Duration > maxDuration && err == nil {
+		return budgetExceeded(ctx, durationOwner, "statement ran for %s, limit %s", stmt.Duration, maxDuration)
+	}
+	return err
+}
+
+// budgetExceeded describes the exceeded limit of the budget of owner, and marks the transaction rollback-only
+// or the savepoint of owner to be rolled back
+func budgetExceeded(ctx context.Context, owner *TxState, format string, args ...any) error {
+	if owner.parent == nil {
+		_ = SetRollbackOnly(ctx)
+		return fmt.Errorf("transaction %s: %w: %s", owner.ID(), ErrBudgetExceeded, fmt.Sprintf(format, args...))
+	}
+	err := fmt.Errorf("transaction %s, savepoint %d: %w: %s", owner.ID(), owner.depth, ErrBudgetExceeded, fmt.Sprintf(format, args...))
+	owner.exceeded.CompareAndSwap(nil, &err)
+	return err
+}
+
+// budgetErr returns the error of the first statement exceeding the budget of the savepoint of st, if any
+func (st *TxState) budgetErr() error {
+	if err := st.exceeded.Load(); err != nil {
+		return *err
+	}
+	return nil
+}
@@ -0,0 +1,153 @@
+package session
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEnforceBudget_maxStatements(t *testing.T) {
+	db := openTimeoutTestDB(t)
+	wrapper := NewDB(db, WithInterceptors(EnforceBudget()))
+	sess := NewSession(db, WithDefaultBudget(Budget{MaxStatements: 2}))
+
+	var usage BudgetUsage
+	err := sess.WithTransaction(context.Background(), func(ctx context.Context) error {
+		for _, id := range []string{"a", "b"} {
+			_, err := wrapper.GetDB(ctx).ExecContext(ctx, "INSERT INTO models (id) VALUES (?)", id)
+			require.NoError(t, err)
+		}
+		_, err := wrapper.GetDB(ctx).ExecContext(ctx, "INSERT INTO models (id) VALUES (?)", "c")
+		assert.ErrorIs(t, err, ErrBudgetExceeded)
+		assert.ErrorContains(t, err, "3 statements, limit 2")
+
+		st := Current(ctx)
+		assert.True(t, st.RollbackOnly())
+		assert.Equal(t, Budget{MaxStatements: 2}, st.Budget())
+		usage = st.Usage()
+		// the error is swallowed, but the transaction still rolls back
+		return nil
+	})
+	assert.ErrorIs(t, err, ErrRollbackOnly)
+	assert.Equal(t, int64(3), usage.Statements)
+	assert.Equal(t, int64(2), usage.RowsAffected)
+	assert.Positive(t, usage.LongestStatement)
+	assert.Equal(t, 0, countModels(t, db))
+}
+
+func TestEnforceBudget_maxRowsAffected(t *testing.T) {
+	db := openTimeoutTestDB(t)
+	wrapper := NewDB(db, WithInterceptors(EnforceBudget()))
+	for _, id := range []string{"a", "b", "c"} {
+		_, err := db.Exec("INSERT INTO models (id) VALUES (?)", id)
+		require.NoError(t, err)
+	}
+
+	// the call budget takes precedence over the one of the session
+	sess := NewSession(db, WithDefaultBudget(Budget{MaxRowsAffected: 10, MaxStatements: 5}))
+	err := sess.WithTransaction(context.Background(), func(ctx context.Context) error {
+		assert.Equal(t, Budget{MaxRowsAffected: 2, MaxStatements: 5}, Current(ctx).Budget())
+		_, err := wrapper.GetDB(ctx).ExecContext(ctx, "DELETE FROM models")
+		return err
+	}, WithBudget(Budget{MaxRowsAffected: 2}))
+	assert.ErrorIs(t, err, ErrBudgetExceeded)
+	assert.ErrorContains(t, err, "3 rows affected, limit 2")
+	assert.Equal(t, 3, countModels(t, db))
+}
+
+func TestEnforceBudget_maxStatementDuration(t *testing.T) {
+	db := openTimeoutTestDB(t)
+	wrapper := NewDB(db, WithInterceptors(EnforceBudget()))
+
+	err := NewSession(db).WithTransaction(context.Background(), func(ctx context.Context) error {
+		_, err := wrapper.GetDB(ctx).ExecContext(ctx, "INSERT INTO models (id) VALUES (?)", "a")
+		require.NoError(t, err)
+		_, err = wrapper.GetDB(ctx).ExecContext(ctx,
+			"WITH RECURSIVE c(x) AS (SELECT 1 UNION ALL SELECT x + 1 FROM c WHERE x < 1000000000) SELECT count(*) FROM c")
+		return err
+	}, WithBudget(Budget{MaxStatementDuration: 20 * time.Millisecond}))
+	assert.ErrorIs(t, err, ErrBudgetExceeded)
+	assert.ErrorContains(t, err, "limit 20ms")
+	assert.Equal(t, 0, countModels(t, db))
+}
+
+func TestEnforceBudget_savepoint(t *testing.T) {
+	db := openTimeoutTestDB(t)
+	wrapper := NewDB(db, WithInterceptors(EnforceBudget()))
+	sess := NewSession(db)
+
+	insert := func(ctx context.Context, id string) error {
+		_, err := wrapper.GetDB(ctx).ExecContext(ctx, "INSERT INTO models (id) VALUES (?)", id)
+		return err
+	}
+	err := sess.WithTransaction(context.Background(), func(ctx context.Context) error {
+		require.NoError(t, insert(ctx, "a"))
+		err := sess.WithTransaction(ctx, func(ctx context.Context) error {
+			require.NoError(t, insert(ctx, "b"))
+			assert.Equal(t, int64(1), Current(ctx).Usage().Statements)
+			assert.ErrorIs(t, insert(ctx, "c"), ErrBudgetExceeded)
+			// the error is swallowed, but the savepoint still rolls back
+			return nil
+		}, WithPropagation(PropagationNested), WithBudget(Budget{MaxStatements: 1}))
+		assert.ErrorIs(t, err, ErrBudgetExceeded)
+		assert.ErrorContains(t, err, "savepoint 1")
+
+		// the statements of the savepoint count for the transaction too, which is left to commit
+		assert.Equal(t, int64(3), Current(ctx).Usage().Statements)
+		assert.False(t, Current(ctx).RollbackOnly())
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, countModels(t, db))
+}
+
+func TestBudget_enforcedWithoutInterceptor(t *testing.T) {
+	db := openTimeoutTestDB(t)
+	wrapper := NewDB(db)
+	sess := NewSession(db, WithDefaultBudget(Budget{MaxStatements: 1}))
+
+	err := sess.WithTransaction(context.Background(), func(ctx context.Context) error {
+		_, err := wrapper.GetDB(ctx).ExecContext(ctx, "INSERT INTO models (id) VALUES (?)", "a")
+		require.NoError(t, err)
+		_, err = wrapper.GetDB(ctx).ExecContext(ctx, "INSERT INTO models (id) VALUES (?)", "b")
+		return err
+	})
+	assert.ErrorIs(t, err, ErrBudgetExceeded)
+	assert.Equal(t, 0, countModels(t, db))
+}
+
+func TestBudget_enforcedOnceWithInterceptor(t *testing.T) {
+	db := openTimeoutTestDB(t)
+	wrapper := NewDB(db, WithInterceptors(EnforceBudget()))
+
+	err := NewSession(db).WithTransaction(context.Background(), func(ctx context.Context) error {
+		_, err := wrapper.GetDB(ctx).ExecContext(ctx, "INSERT INTO models (id) VALUES (?)", "a")
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), Current(ctx).Usage().Statements)
+		return nil
+	}, WithBudget(Budget{MaxStatements: 1}))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, countModels(t, db))
+}
+
+func TestBudget_savepointOfUnbudgetedTransaction(t *testing.T) {
+	db := openTimeoutTestDB(t)
+	// the guard makes the wrapper cache the executor converted before the savepoint
+	wrapper := NewDB(db, WithTxGuard())
+	sess := NewSession(db)
+
+	err := sess.WithTransaction(context.Background(), func(ctx context.Context) error {
+		_, err := wrapper.GetDB(ctx).ExecContext(ctx, "INSERT INTO models (id) VALUES (?)", "a")
+		require.NoError(t, err)
+		return sess.WithTransaction(ctx, func(ctx context.Context) error {
+			_, err := wrapper.GetDB(ctx).ExecContext(ctx, "INSERT INTO models (id) VALUES (?)", "b")
+			require.NoError(t, err)
+			_, err = wrapper.GetDB(ctx).ExecContext(ctx, "INSERT INTO models (id) VALUES (?)", "c")
+			return err
+		}, WithPropagation(PropagationNested), WithBudget(Budget{MaxStatements: 1}))
+	})
+	assert.ErrorIs(t, err, ErrBudgetExceeded)
+}
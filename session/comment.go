@@ -0,0 +1,141 @@
+package session
+
+import (
+	"context"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// CommentField computes the value of a key of the query comment, left out when empty
+type CommentField func(ctx context.Context, stmt *Statement) string
+
+// QueryCommentOption configures the interceptor returned by QueryComment
+type QueryCommentOption func(*queryComment)
+
+// WithCommentKeys sets the keys of the query comment, tx_id, route and traceparent by default.
+// The keys of WithCommentField are kept whichever option comes first.
+func WithCommentKeys(keys ...string) QueryCommentOption {
+	return func(c *queryComment) {
+		c.keys = keys
+	}
+}
+
+// WithCommentField computes the value of key with field, and adds key to the keys of the query comment
+func WithCommentField(key string, field CommentField) QueryCommentOption {
+	return func(c *queryComment) {
+		c.fields[key] = field
+		c.fieldKeys = append(c.fieldKeys, key)
+	}
+}
+
+type queryTagsKey struct{}
+
+// WithQueryTag returns a context whose statements carry value for key in their query comment,
+// such as the route of a request or its traceparent header
+func WithQueryTag(ctx context.Context, key, value string) context.Context {
+	parent, _ := ctx.Value(queryTagsKey{}).(map[string]string)
+	tags := make(map[string]string, len(parent)+1)
+	for k, v := range parent {
+		tags[k] = v
+	}
+	tags[key] = value
+	return context.WithValue(ctx, queryTagsKey{}, tags)
+}
+
+// QueryTag returns the value set for key with WithQueryTag
+func QueryTag(ctx context.Context, key string) string {
+	tags, _ := ctx.Value(queryTagsKey{}).(map[string]string)
+	return tags[key]
+}
+
+type queryComment struct {
+	keys   []string
+	fields map[string]CommentField
+	// fieldKeys are the keys of WithCommentField, added to keys once all options applied
+	fieldKeys []string
+}
+
+// QueryComment returns an interceptor appending a sqlcommenter comment to every statement, such as
+//
+//	SELECT * FROM users /*route='%2Fusers',traceparent='00-4bf9...-01',tx_id='5f3a9c-12'*/
+//
+// tx_id is the ID of the session transaction, and the other keys are read from the tags of the context
+// set with WithQueryTag, unless WithCommentField computes them. Keys are sorted, values are URL encoded,
+// and statements which already contain a comment are left unchanged.
+func QueryComment(opts ...QueryCommentOption) Interceptor {
+	c := &queryComment{
+		keys: []string{"tx_id", "route", "traceparent"},
+		fields: map[string]CommentField{
+			"tx_id": func(ctx context.Context, stmt *Statement) string {
+				return stmt.TxID
+			},
+		},
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	c.keys = slices.Clone(c.keys)
+	for _, key := range c.fieldKeys {
+		if !slices.Contains(c.keys, key) {
+			c.keys = append(c.keys, key)
+		}
+	}
+	slices.Sort(c.keys)
+
+	return func(ctx context.Context, stmt *Statement, next StatementHandler) error {
+		if comment := c.comment(ctx, stmt); comment != "" && !hasComment(stmt.Query) {
+			stmt.Query = appendComment(stmt.Query, comment)
+		}
+		return next(ctx, stmt)
+	}
+}
+
+func (c *queryComment) comment(ctx context.Context, stmt *Statement) string {
+	var b strings.Builder
+	for _, key := range c.keys {
+		var value string
+		if field, ok := c.fields[key]; ok {
+			value = field(ctx, stmt)
+		} else {
+			value = QueryTag(ctx, key)
+		}
+		if value == "" {
+			continue
+		}
+		if b.Len() > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(escapeComment(key))
+		b.WriteString("='")
+		b.WriteString(escapeComment(value))
+		b.WriteByte('\'')
+	}
+	return b.String()
+}
+
+// escapeComment URL encodes s, which also escapes the quotes and the end of the comment
+func escapeComment(s string) string {
+	return strings.ReplaceAll(url.QueryEscape(s), "+", "%20")
+}
+
+// hasComment tells whether query contains a comment outside of its literals.
+// A line comment counts when -- is followed by a space or ends the line, the form every database reads as one,
+// so that an expression such as x--1 is not taken for a comment.
+func hasComment(query string) bool {
+	t := &sqlTokenizer{s: query}
+	for t.next().kind != tokenEOF {
+	}
+	for _, comment := range t.comments {
+		if strings.HasPrefix(comment, "/*") || len(comment) == 2 || strings.ContainsRune(" \t\r\f", rune(comment[2])) {
+			return true
+		}
+	}
+	return false
+}
+
+// appendComment appends comment to query, before its final semicolon
+func appendComment(query, comment string) string {
+	body := strings.TrimRight(query, " \t\r\n;")
+	return body + " /*" + comment + "*/" + query[len(body):]
+}
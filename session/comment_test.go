@@ -0,0 +1,128 @@
+package session
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// commented runs query through interceptor and returns the query it passed on
+func commented(ctx context.Context, interceptor Interceptor, query string) string {
+	stmt := &Statement{Query: query, TxID: TxID(ctx)}
+	_ = InterceptStatement(ctx, stmt, []Interceptor{interceptor}, func(ctx context.Context, stmt *Statement) error {
+		return nil
+	})
+	return stmt.Query
+}
+
+func TestQueryComment(t *testing.T) {
+	ctx := WithQueryTag(context.Background(), "route", "/users/{id}")
+	ctx = WithQueryTag(ctx, "traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+
+	tests := []struct {
+		name  string
+		ctx   context.Context
+		opts  []QueryCommentOption
+		query string
+		want  string
+	}{
+		{
+			name:  "default keys",
+			ctx:   ctx,
+			query: "SELECT 1",
+			want:  "SELECT 1 /*route='%2Fusers%2F%7Bid%7D',traceparent='00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01'*/",
+		},
+		{
+			name:  "escaped",
+			ctx:   WithQueryTag(context.Background(), "route", "it's */ done"),
+			query: "SELECT 1",
+			want:  "SELECT 1 /*route='it%27s%20%2A%2F%20done'*/",
+		},
+		{
+			name:  "before semicolon",
+			ctx:   ctx,
+			opts:  []QueryCommentOption{WithCommentKeys("route")},
+			query: "SELECT 1;\n",
+			want:  "SELECT 1 /*route='%2Fusers%2F%7Bid%7D'*/;\n",
+		},
+		{
+			name: "field",
+			ctx:  ctx,
+			opts: []QueryCommentOption{WithCommentKeys(), WithCommentField("app", func(ctx context.Context, stmt *Statement) string {
+				return "billing"
+			})},
+			query: "SELECT 1",
+			want:  "SELECT 1 /*app='billing'*/",
+		},
+		{
+			name:  "no values",
+			ctx:   context.Background(),
+			query: "SELECT 1",
+			want:  "SELECT 1",
+		},
+		{
+			name: "field before keys",
+			ctx:  ctx,
+			opts: []QueryCommentOption{WithCommentField("app", func(ctx context.Context, stmt *Statement) string {
+				return "billing"
+			}), WithCommentKeys("route")},
+			query: "SELECT 1",
+			want:  "SELECT 1 /*app='billing',route='%2Fusers%2F%7Bid%7D'*/",
+		},
+		{
+			name:  "existing comment",
+			ctx:   ctx,
+			query: "SELECT 1 /* mine */",
+			want:  "SELECT 1 /* mine */",
+		},
+		{
+			name:  "existing line comment",
+			ctx:   ctx,
+			opts:  []QueryCommentOption{WithCommentKeys("route")},
+			query: "SELECT 1 -- mine\nFROM t",
+			want:  "SELECT 1 -- mine\nFROM t",
+		},
+		{
+			name:  "comment markers in literals",
+			ctx:   ctx,
+			opts:  []QueryCommentOption{WithCommentKeys("route")},
+			query: "SELECT '/* not a comment */', x--1 FROM t WHERE note = '-- neither'",
+			want:  "SELECT '/* not a comment */', x--1 FROM t WHERE note = '-- neither' /*route='%2Fusers%2F%7Bid%7D'*/",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, commented(tt.ctx, QueryComment(tt.opts...), tt.query))
+		})
+	}
+}
+
+func TestQueryComment_txID(t *testing.T) {
+	db := openSerialTestDB(t)
+	var queries []string
+	wrapper := NewDB(db, WithInterceptors(QueryComment(), func(ctx context.Context, stmt *Statement, next StatementHandler) error {
+		queries = append(queries, stmt.Query)
+		return next(ctx, stmt)
+	}))
+
+	var txID string
+	err := NewSession(db).WithTransaction(context.Background(), func(ctx context.Context) error {
+		txID = TxID(ctx)
+		_, err := wrapper.GetDB(ctx).ExecContext(ctx, "INSERT INTO models (id) VALUES (?)", "a")
+		return err
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"INSERT INTO models (id) VALUES (?) /*tx_id='" + escapeComment(txID) + "'*/"}, queries)
+	assert.Equal(t, 1, countModels(t, db))
+}
+
+func TestWithQueryTag(t *testing.T) {
+	parent := WithQueryTag(context.Background(), "route", "/a")
+	child := WithQueryTag(parent, "route", "/b")
+
+	assert.Equal(t, "/a", QueryTag(parent, "route"))
+	assert.Equal(t, "/b", QueryTag(child, "route"))
+	assert.Empty(t, QueryTag(context.Background(), "route"))
+}
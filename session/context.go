@@ -1,15 +1,349 @@
 package session
 
-import "context"
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// ErrRollbackOnly is returned by WithTransaction when f succeeded
+	// but the transaction was marked with SetRollbackOnly
+	ErrRollbackOnly = errors.New("transaction marked rollback-only")
+
+	// ErrTxFinished is returned by guarded executors used after their transaction committed or rolled back
+	ErrTxFinished = errors.New("transaction already finished")
+)
 
 type txKey struct{}
 
-// WithTx returns a new context with the given transaction value
+// TxState describes the session transaction carried by a context.
+// A savepoint gets its own state pointing to the state it was created in,
+// and shares the identity, options and flags of the transaction.
+type TxState struct {
+	tx        *sql.Tx
+	db        *sql.DB
+	parent    *TxState
+	depth     int
+	id        string
+	startedAt time.Time
+	config    TxConfig
+
+	// external is set when tx is owned by the caller instead of a session,
+	// in which case WithTransaction never starts a new transaction
+	external bool
+	// nested makes every call joining an external tx run in a savepoint
+	nested bool
+	// budget and usage are the ones of the transaction, or of the savepoint for a nested state
+	budget Budget
+	usage  budgetCounters
+	// exceeded is the error of the first statement exceeding the budget of a savepoint, which rolls it back
+	exceeded atomic.Pointer[error]
+
+	// the fields below are kept on the root state only
+	mu            sync.Mutex
+	resources     []Resource
+	afterCommit   []func(ctx context.Context)
+	afterRollback []func(ctx context.Context)
+	rollbackOnly  bool
+	finished      atomic.Bool
+	// conn serializes the statements of executors asking for it
+	conn connLock
+	// routes are the databases opened with WrapDriver whose statements are routed to tx
+	routes []routeSource
+	// tracked is set for the transactions of a session with a watchdog or a registry,
+	// which records their stack, savepoint depth and statements
+	tracked bool
+	stack   []byte
+	// budgeted is set once the transaction or one of its savepoints has a budget,
+	// which the executors converted from tx enforce
+	budgeted      atomic.Bool
+	nesting       atomic.Int32
+	statements    atomic.Int64
+	lastStatement atomic.Pointer[string]
+	// handles caches the handles converted from tx by the wrappers, replaced on every addition
+	// so that lookups need no lock
+	handles atomic.Pointer[[]handle]
+}
+
+type handle struct {
+	adapter any
+	value   any
+}
+
+// Tx returns the underlying transaction
+func (st *TxState) Tx() *sql.Tx {
+	return st.tx
+}
+
+// DB returns the database the transaction was begun on, nil for a transaction owned by the caller
+func (st *TxState) DB() *sql.DB {
+	return st.root().db
+}
+
+// ID returns the identifier of the transaction, unique within the process
+func (st *TxState) ID() string {
+	return st.root().id
+}
+
+// Depth returns 0 for the transaction and the number of enclosing savepoints otherwise
+func (st *TxState) Depth() int {
+	return st.depth
+}
+
+// StartedAt returns when the transaction began
+func (st *TxState) StartedAt() time.Time {
+	return st.root().startedAt
+}
+
+// Options returns the configuration the transaction was begun with
+func (st *TxState) Options() TxConfig {
+	return st.root().config
+}
+
+// External tells whether the transaction is owned by the caller rather than a session
+func (st *TxState) External() bool {
+	return st.external
+}
+
+// RollbackOnly tells whether the transaction was marked with SetRollbackOnly
+func (st *TxState) RollbackOnly() bool {
+	root := st.root()
+	root.mu.Lock()
+	defer root.mu.Unlock()
+	return root.rollbackOnly
+}
+
+// LastStatement returns the last statement of a transaction begun by a session with a watchdog or a registry,
+// when it ran through an executor recording it, see TrackStatements
+func (st *TxState) LastStatement() string {
+	if query := st.root().lastStatement.Load(); query != nil {
+		return *query
+	}
+	return ""
+}
+
+func (st *TxState) noteStatement(query string) {
+	if st.tracked {
+		st.statements.Add(1)
+		st.lastStatement.Store(&query)
+	}
+}
+
+// Err returns an error wrapping ErrTxFinished once the transaction committed or rolled back, nil before.
+// A transaction owned by the caller is never seen as finished.
+func (st *TxState) Err() error {
+	if root := st.root(); root.finished.Load() {
+		return fmt.Errorf("transaction %s: %w", root.id, ErrTxFinished)
+	}
+	return nil
+}
+
+// end marks the transaction as finished and runs hooks
+func (st *TxState) end(ctx context.Context, hooks []func(ctx context.Context)) {
+	st.finished.Store(true)
+	runHooks(ctx, hooks)
+}
+
+func (st *TxState) root() *TxState {
+	for st.parent != nil {
+		st = st.parent
+	}
+	return st
+}
+
+// propagation resolves the propagation requested by a call joining this transaction
+func (st *TxState) propagation(p Propagation) Propagation {
+	if st.external && (st.nested || p == PropagationRequiresNew) {
+		return PropagationNested
+	}
+	return p
+}
+
+// handle returns the handle converted by adapter, looking it up without locking or allocating
+func (st *TxState) handle(adapter any) (any, bool) {
+	if handles := st.handles.Load(); handles != nil {
+		for _, h := range *handles {
+			if h.adapter == adapter {
+				return h.value, true
+			}
+		}
+	}
+	return nil, false
+}
+
+func (st *TxState) setHandle(adapter, value any) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	var handles []handle
+	if old := st.handles.Load(); old != nil {
+		handles = slices.Clone(*old)
+	}
+	handles = append(handles, handle{adapter: adapter, value: value})
+	st.handles.Store(&handles)
+}
+
+// Current returns the state of the session transaction carried by ctx, or nil
+func Current(ctx context.Context) *TxState {
+	return getState(ctx)
+}
+
+// InTransaction tells whether ctx carries a session transaction
+func InTransaction(ctx context.Context) bool {
+	return getState(ctx) != nil
+}
+
+// TxID returns the identifier of the session transaction carried by ctx, or an empty string
+func TxID(ctx context.Context) string {
+	if st := getState(ctx); st != nil {
+		return st.ID()
+	}
+	return ""
+}
+
+// Depth returns the savepoint depth of the session transaction carried by ctx, 0 when there is none
+func Depth(ctx context.Context) int {
+	if st := getState(ctx); st != nil {
+		return st.depth
+	}
+	return 0
+}
+
+// SetRollbackOnly marks the session transaction carried by ctx so it rolls back instead of committing,
+// even when every function run in it succeeds
+func SetRollbackOnly(ctx context.Context) error {
+	st := getState(ctx)
+	if st == nil {
+		return ErrNoTransaction
+	}
+	root := st.root()
+	root.mu.Lock()
+	defer root.mu.Unlock()
+	root.rollbackOnly = true
+	return nil
+}
+
+// AfterCommit registers f to run once the session transaction carried by ctx committed.
+// Functions registered in a savepoint that rolls back are dropped,
+// and the ones of a transaction owned by the caller never run.
+func AfterCommit(ctx context.Context, f func(ctx context.Context)) error {
+	st := getState(ctx)
+	if st == nil {
+		return ErrNoTransaction
+	}
+	root := st.root()
+	root.mu.Lock()
+	defer root.mu.Unlock()
+	root.afterCommit = append(root.afterCommit, f)
+	return nil
+}
+
+// AfterRollback registers f to run once the session transaction carried by ctx rolled back,
+// or once the savepoint it was registered in rolled back.
+// The ones of a transaction owned by the caller never run.
+func AfterRollback(ctx context.Context, f func(ctx context.Context)) error {
+	st := getState(ctx)
+	if st == nil {
+		return ErrNoTransaction
+	}
+	root := st.root()
+	root.mu.Lock()
+	defer root.mu.Unlock()
+	root.afterRollback = append(root.afterRollback, f)
+	return nil
+}
+
+// WithTx returns a new context with the given transaction value.
+// A *sql.Tx is carried as a transaction owned by the caller, which WithTransaction joins.
 func WithTx(ctx context.Context, tx any) context.Context {
+	if sqlTx, ok := tx.(*sql.Tx); ok && sqlTx != nil {
+		return WithExternalTx(ctx, sqlTx, PropagationRequired)
+	}
 	return context.WithValue(ctx, txKey{}, tx)
 }
 
+// WithoutTx returns a context carrying the values, deadline and cancellation of ctx but not its transaction,
+// so DBWrapper.GetDB returns the database and WithTransaction starts a transaction of its own
+func WithoutTx(ctx context.Context) context.Context {
+	return context.WithValue(ctx, txKey{}, nil)
+}
+
+// Detach returns a context carrying the values of ctx but neither its transaction, its cancellation nor its deadline,
+// for work outliving the transaction such as goroutines and queued jobs
+func Detach(ctx context.Context) context.Context {
+	return WithoutTx(context.WithoutCancel(ctx))
+}
+
+// DetachWithDeadline is Detach keeping the deadline of ctx, which includes the maximum duration
+// of a transaction begun in ctx. Call cancel once the detached work is done to release the deadline.
+func DetachWithDeadline(ctx context.Context) (detached context.Context, cancel context.CancelFunc) {
+	detached = Detach(ctx)
+	if deadline, ok := ctx.Deadline(); ok {
+		return context.WithDeadline(detached, deadline)
+	}
+	return context.WithCancel(detached)
+}
+
 // GetTx retrieves a transaction from the context if it exists
 func GetTx(ctx context.Context) any {
-	return ctx.Value(txKey{})
+	val := ctx.Value(txKey{})
+	if st, ok := val.(*TxState); ok {
+		if st.tx == nil {
+			// a fake transaction has no SQL transaction
+			return nil
+		}
+		return st.tx
+	}
+	return val
+}
+
+// WithExternalTx returns a context carrying tx, a transaction owned by the caller rather than a session.
+// WithTransaction calls made with the returned context never commit or roll back tx.
+// With PropagationRequired the calls join tx as usual, and calls asking for PropagationRequiresNew
+// run in a savepoint instead, so nothing escapes tx.
+// With any other value every call runs in a savepoint, so a failing call only undoes its own changes.
+func WithExternalTx(ctx context.Context, tx *sql.Tx, p Propagation) context.Context {
+	return withState(ctx, &TxState{
+		tx:        tx,
+		id:        newTxID(),
+		startedAt: time.Now(),
+		config:    TxConfig{Propagation: p},
+		external:  true,
+		nested:    p != PropagationRequired,
+	})
+}
+
+func withState(ctx context.Context, st *TxState) context.Context {
+	return context.WithValue(ctx, txKey{}, st)
+}
+
+func getState(ctx context.Context) *TxState {
+	st, _ := ctx.Value(txKey{}).(*TxState)
+	return st
+}
+
+var (
+	txIDPrefix = newTxIDPrefix()
+	txIDSeq    atomic.Uint64
+)
+
+func newTxIDPrefix() string {
+	b := make([]byte, 6)
+	if _, err := rand.Read(b); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+	return hex.EncodeToString(b)
+}
+
+// newTxID returns an identifier unique within the process and unlikely to repeat across processes
+func newTxID() string {
+	return txIDPrefix + "-" + strconv.FormatUint(txIDSeq.Add(1), 10)
 }
@@ -2,7 +2,9 @@ package session
 
 import (
 	"context"
+	"database/sql"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -31,3 +33,83 @@ func TestGetTx(t *testing.T) {
 	tx = GetTx(txCtx)
 	assert.Equal(t, testTx, tx)
 }
+
+func TestCurrent(t *testing.T) {
+	ctx := context.Background()
+	assert.Nil(t, Current(ctx))
+	assert.False(t, InTransaction(ctx))
+	assert.Empty(t, TxID(ctx))
+	assert.Equal(t, 0, Depth(ctx))
+	assert.ErrorIs(t, SetRollbackOnly(ctx), ErrNoTransaction)
+	assert.ErrorIs(t, AfterCommit(ctx, func(context.Context) {}), ErrNoTransaction)
+
+	// a raw value is not a session transaction
+	assert.False(t, InTransaction(WithTx(ctx, "test_transaction")))
+
+	tx := &sql.Tx{}
+	txCtx := WithTx(ctx, tx)
+	st := Current(txCtx)
+	assert.NotNil(t, st)
+	assert.True(t, InTransaction(txCtx))
+	assert.Equal(t, tx, st.Tx())
+	assert.Equal(t, tx, GetTx(txCtx))
+	assert.True(t, st.External())
+	assert.Nil(t, st.DB())
+	assert.NotEmpty(t, TxID(txCtx))
+	assert.NotEqual(t, TxID(txCtx), TxID(WithTx(ctx, tx)))
+	assert.False(t, st.RollbackOnly())
+	assert.NoError(t, SetRollbackOnly(txCtx))
+	assert.True(t, st.RollbackOnly())
+}
+
+func TestWithoutTx(t *testing.T) {
+	type key struct{}
+	ctx, cancel := context.WithCancel(context.WithValue(context.Background(), key{}, "value"))
+	txCtx := WithTx(ctx, &sql.Tx{})
+
+	noTxCtx := WithoutTx(txCtx)
+	assert.False(t, InTransaction(noTxCtx))
+	assert.Nil(t, GetTx(noTxCtx))
+	assert.Equal(t, "value", noTxCtx.Value(key{}))
+
+	detached := Detach(txCtx)
+	assert.False(t, InTransaction(detached))
+	assert.Equal(t, "value", detached.Value(key{}))
+
+	cancel()
+	assert.Error(t, noTxCtx.Err())
+	assert.NoError(t, detached.Err())
+}
+
+func TestDetach_deadline(t *testing.T) {
+	deadline := time.Now().Add(time.Hour)
+	ctx, cancel := context.WithDeadline(context.Background(), deadline)
+	txCtx := WithTx(ctx, &sql.Tx{})
+
+	_, ok := Detach(txCtx).Deadline()
+	assert.False(t, ok)
+
+	kept, cancelKept := DetachWithDeadline(txCtx)
+	for _, ctx := range []context.Context{WithoutTx(txCtx), kept} {
+		got, ok := ctx.Deadline()
+		assert.True(t, ok)
+		assert.Equal(t, deadline, got)
+	}
+	assert.False(t, InTransaction(kept))
+	cancel()
+	assert.NoError(t, kept.Err())
+	cancelKept()
+	assert.ErrorIs(t, kept.Err(), context.Canceled)
+
+	expired, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+	assert.NoError(t, Detach(expired).Err())
+	expiredKept, cancelExpired := DetachWithDeadline(expired)
+	defer cancelExpired()
+	assert.ErrorIs(t, expiredKept.Err(), context.DeadlineExceeded)
+
+	noDeadline, cancelNoDeadline := DetachWithDeadline(context.Background())
+	defer cancelNoDeadline()
+	_, ok = noDeadline.Deadline()
+	assert.False(t, ok)
+}
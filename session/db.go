@@ -3,6 +3,8 @@ package session
 import (
 	"context"
 	"database/sql"
+	"errors"
+	"fmt"
 )
 
 // Executor defines the common database operations that can be performed by both *sql.DB and *sql.Tx
@@ -17,18 +19,186 @@ type Executor interface {
 	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
 }
 
-func NewDB(db *sql.DB) DBWrapper[Executor] {
-	return NewDBWrapper(&DB{sqlDB: db})
+func NewDB(db *sql.DB, opts ...DBOption) DBWrapper[Executor] {
+	return NewDBWrapper[Executor](NewDatabase(db, opts...))
+}
+
+// DBOption configures the database/sql adapter
+type DBOption func(*DB)
+
+// WithTxGuard makes the executor of a transaction return ErrTxFinished once the transaction ended,
+// instead of running statements on a committed or rolled back transaction.
+// QueryRow cannot carry the error and still reports sql.ErrTxDone from Scan.
+// The sqlx adapter has an option of the same name, and the gorm adapter the TxGuard plugin.
+func WithTxGuard() DBOption {
+	return func(db *DB) {
+		db.guard = true
+	}
+}
+
+// WithSerializedTx makes the executor of a transaction run one statement at a time,
+// so goroutines sharing the transaction do not interleave on its connection.
+// Result sets are read into memory before the next statement runs, and returned as *sql.Rows
+// of a fake in-memory connection shared by the process, without column types.
+// Preparing a statement fails with ErrSerializedPrepare, and the SAVEPOINT statements
+// of nested calls are not serialized, so goroutines should not start savepoints while others run statements.
+func WithSerializedTx() DBOption {
+	return func(db *DB) {
+		db.serialize = true
+	}
+}
+
+// WithConcurrentUseReport serializes statements like WithSerializedTx and calls report
+// for every statement that had to wait for another one, with the stacks of both.
+// Capturing the stacks is costly, so it is meant for debugging.
+func WithConcurrentUseReport(report func(ConcurrentUse)) DBOption {
+	return func(db *DB) {
+		db.serialize = true
+		db.report = report
+	}
+}
+
+// WithInterceptors runs every statement of the executors, in a transaction or not, through interceptors,
+// the first one outermost
+func WithInterceptors(interceptors ...Interceptor) DBOption {
+	return func(db *DB) {
+		db.interceptors = append(db.interceptors, interceptors...)
+	}
+}
+
+// NewDatabase returns the database/sql adapter, to be used with NewDBWrapper or NewMultiDBWrapper
+func NewDatabase(db *sql.DB, opts ...DBOption) *DB {
+	d := &DB{sqlDB: db}
+	for _, opt := range opts {
+		opt(d)
+	}
+	return d
 }
 
 type DB struct {
-	sqlDB *sql.DB
+	sqlDB     *sql.DB
+	guard     bool
+	serialize bool
+	report    func(ConcurrentUse)
+
+	interceptors []Interceptor
 }
 
 func (db *DB) GetDB(ctx context.Context) Executor {
+	interceptors := db.interceptors
+	// the statements of a fake transaction run on the database
+	if track := TrackStatements(getState(ctx)); track != nil {
+		interceptors = append([]Interceptor{track}, interceptors...)
+	}
+	if len(interceptors) > 0 {
+		return newInterceptedExecutor(db.sqlDB, interceptors)
+	}
 	return db.sqlDB
 }
 
+// SkipCache tells the wrapper not to cache the executor of a transaction when no option wraps it,
+// since the transaction itself is then returned
+func (db *DB) SkipCache() bool {
+	return !db.guard && !db.serialize && len(db.interceptors) == 0
+}
+
 func (db *DB) ConvertTx(ctx context.Context, tx *sql.Tx) Executor {
-	return tx
+	st := Current(ctx)
+	var executor Executor = tx
+	if db.serialize {
+		lock := &connLock{}
+		if st != nil {
+			lock = &st.root().conn
+		}
+		executor = &serialTx{tx: tx, lock: lock, report: db.report}
+	}
+	interceptors := db.interceptors
+	if track := TrackStatements(st); track != nil {
+		interceptors = append([]Interceptor{track}, interceptors...)
+	}
+	if len(interceptors) > 0 {
+		executor = newInterceptedExecutor(executor, interceptors)
+	}
+	if db.guard {
+		return &guardedTx{tx: executor, guard: NewTxGuard(st)}
+	}
+	return executor
+}
+
+// TxGuard fails the statements of the executor of a transaction once the transaction ended,
+// for adapters supporting WithTxGuard on their own executors
+type TxGuard struct {
+	st *TxState
+}
+
+// NewTxGuard returns the guard of the transaction of st, which lets every statement run when st is nil
+func NewTxGuard(st *TxState) TxGuard {
+	return TxGuard{st: st}
+}
+
+// Check returns an error wrapping ErrTxFinished once the transaction ended, to call before running a statement
+func (g TxGuard) Check() error {
+	if g.st == nil {
+		return nil
+	}
+	return g.st.Err()
+}
+
+// Wrap returns the error of a statement, wrapping ErrTxFinished when it ran on a transaction
+// that ended without the session knowing
+func (g TxGuard) Wrap(err error) error {
+	if errors.Is(err, sql.ErrTxDone) {
+		return fmt.Errorf("%w: %w", ErrTxFinished, err)
+	}
+	return err
+}
+
+// guardedTx runs statements on tx until its transaction ended
+type guardedTx struct {
+	tx    Executor
+	guard TxGuard
+}
+
+func (g *guardedTx) Exec(query string, args ...any) (sql.Result, error) {
+	return g.ExecContext(context.Background(), query, args...)
+}
+
+func (g *guardedTx) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	if err := g.guard.Check(); err != nil {
+		return nil, err
+	}
+	res, err := g.tx.ExecContext(ctx, query, args...)
+	return res, g.guard.Wrap(err)
+}
+
+func (g *guardedTx) Prepare(query string) (*sql.Stmt, error) {
+	return g.PrepareContext(context.Background(), query)
+}
+
+func (g *guardedTx) PrepareContext(ctx context.Context, query string) (*sql.Stmt, error) {
+	if err := g.guard.Check(); err != nil {
+		return nil, err
+	}
+	stmt, err := g.tx.PrepareContext(ctx, query)
+	return stmt, g.guard.Wrap(err)
+}
+
+func (g *guardedTx) Query(query string, args ...any) (*sql.Rows, error) {
+	return g.QueryContext(context.Background(), query, args...)
+}
+
+func (g *guardedTx) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	if err := g.guard.Check(); err != nil {
+		return nil, err
+	}
+	rows, err := g.tx.QueryContext(ctx, query, args...)
+	return rows, g.guard.Wrap(err)
+}
+
+func (g *guardedTx) QueryRow(query string, args ...any) *sql.Row {
+	return g.tx.QueryRow(query, args...)
+}
+
+func (g *guardedTx) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	return g.tx.QueryRowContext(ctx, query, args...)
 }
@@ -0,0 +1,50 @@
+// Package dialect identifies the SQL flavour of the statements built by the session helpers,
+// such as the outbox, the idempotency keys and the journal of MultiSession
+package dialect
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Dialect identifies the SQL flavour used by helpers that build their own statements
+type Dialect int
+
+const (
+	SQLite Dialect = iota
+	Postgres
+	MySQL
+)
+
+func (d Dialect) String() string {
+	switch d {
+	case SQLite:
+		return "sqlite"
+	case Postgres:
+		return "postgres"
+	case MySQL:
+		return "mysql"
+	}
+	return "unknown"
+}
+
+// Rebind replaces the ? placeholders in query with the bind variables of the dialect
+func (d Dialect) Rebind(query string) string {
+	if d != Postgres {
+		return query
+	}
+
+	var b strings.Builder
+	b.Grow(len(query) + 8)
+	n := 0
+	for i := 0; i < len(query); i++ {
+		if query[i] != '?' {
+			b.WriteByte(query[i])
+			continue
+		}
+		n++
+		b.WriteByte('$')
+		b.WriteString(strconv.Itoa(n))
+	}
+	return b.String()
+}
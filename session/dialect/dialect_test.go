@@ -0,0 +1,22 @@
+package dialect
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDialect_Rebind(t *testing.T) {
+	query := "UPDATE t SET a = ? WHERE b = ? AND c = ?"
+
+	assert.Equal(t, query, SQLite.Rebind(query))
+	assert.Equal(t, query, MySQL.Rebind(query))
+	assert.Equal(t, "UPDATE t SET a = $1 WHERE b = $2 AND c = $3", Postgres.Rebind(query))
+}
+
+func TestDialect_String(t *testing.T) {
+	assert.Equal(t, "sqlite", SQLite.String())
+	assert.Equal(t, "postgres", Postgres.String())
+	assert.Equal(t, "mysql", MySQL.String())
+	assert.Equal(t, "unknown", Dialect(42).String())
+}
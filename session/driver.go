@@ -0,0 +1,349 @@
+package session
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"io"
+	"reflect"
+	"slices"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/aeramu/sql-transaction/session/internal/driverwrap"
+)
+
+// WrapDriver returns a driver routing the statements of a context carrying a session transaction
+// to that transaction, so code written against a plain *sql.DB joins WithTransaction unchanged.
+// Register it under a name of its own and open the database with that name:
+//
+//	sql.Register("sqlite3-session", session.WrapDriver(&sqlite3.SQLiteDriver{}))
+//
+// A transaction begun through the driver while the context carries a session transaction
+// runs in a savepoint of it.
+//
+// Only the transactions of a session on the same database are routed to: a session running on a database
+// opened with WrapDriver or Connector routes the statements of the databases opened with the same driver
+// and DSN, and a session on any other database the ones of the databases given to WithRouting.
+// The transactions owned by the caller are never routed to.
+//
+// Statements are routed by the connection database/sql hands out for them, so a routed statement
+// holds a connection of the wrapped database on top of the one of the session transaction.
+// When the session runs on the wrapped database itself, keep SetMaxOpenConns above the number of
+// concurrent session transactions: with every connection held by a transaction, routed statements
+// wait forever. Opening the session on a separate, unwrapped *sql.DB avoids sharing the pool.
+func WrapDriver(d driver.Driver) driver.Driver {
+	return &routeDriver{Driver: d}
+}
+
+// Connector returns a connector opening connections of d that route statements like WrapDriver,
+// to be used with sql.OpenDB
+func Connector(d driver.Driver, dsn string) driver.Connector {
+	source := routeSource{driver: reflect.TypeOf(d), dsn: dsn}
+	return &driverwrap.Connector{
+		Inner: d,
+		DSN:   dsn,
+		Wrap: func(conn driver.Conn) driver.Conn {
+			return &routeConn{Conn: driverwrap.Conn{Conn: conn}, source: source}
+		},
+		Outer: &routeDriver{Driver: d, source: source},
+	}
+}
+
+type routeDriver struct {
+	driver.Driver
+	// source is set for the driver of a database, to the database its connections open
+	source routeSource
+}
+
+// routeSource identifies the database the connections of a routing connector open,
+// the same for every *sql.DB opened with the same driver and DSN
+type routeSource struct {
+	driver reflect.Type
+	dsn    string
+}
+
+// routeSourceOf returns the database opened by db, false when db was not opened with WrapDriver or Connector
+func routeSourceOf(db *sql.DB) (routeSource, bool) {
+	d, ok := db.Driver().(*routeDriver)
+	if !ok || d.source.driver == nil {
+		return routeSource{}, false
+	}
+	return d.source, true
+}
+
+func (d *routeDriver) Open(dsn string) (driver.Conn, error) {
+	return Connector(d.Driver, dsn).Connect(context.Background())
+}
+
+func (d *routeDriver) OpenConnector(dsn string) (driver.Connector, error) {
+	return Connector(d.Driver, dsn), nil
+}
+
+// newTxKey marks the context of a BeginTx that must start a transaction of its own
+// even though the context carries one
+type newTxKey struct{}
+
+var routeSavepoints atomic.Uint64
+
+// routeConn runs statements on the session transaction carried by their context,
+// and on the wrapped connection otherwise.
+// database/sql uses a connection from one goroutine at a time, so tx and joined need no locking.
+type routeConn struct {
+	driverwrap.Conn
+	source routeSource
+	// tx is a transaction begun on the wrapped connection, whose statements are never routed
+	tx driver.Tx
+	// joined is a transaction begun in a savepoint of a session transaction,
+	// whose statements are routed to it whatever their context
+	joined *joinedTx
+}
+
+// ambient returns the session transaction the statements of ctx are routed to, or nil
+// when ctx carries none or the transaction belongs to another database
+func (c *routeConn) ambient(ctx context.Context) *sql.Tx {
+	if c.joined != nil {
+		return c.joined.tx
+	}
+	if c.tx != nil {
+		return nil
+	}
+	st := getState(ctx)
+	if st == nil || st.tx == nil || !slices.Contains(st.root().routes, c.source) {
+		return nil
+	}
+	return st.tx
+}
+
+func (c *routeConn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c *routeConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if ctx.Value(newTxKey{}) == nil {
+		if tx := c.ambient(ctx); tx != nil {
+			name := "session_route_sp_" + strconv.FormatUint(routeSavepoints.Add(1), 10)
+			if _, err := tx.ExecContext(ctx, "SAVEPOINT "+name); err != nil {
+				return nil, err
+			}
+			c.joined = &joinedTx{conn: c, tx: tx, name: name}
+			return c.joined, nil
+		}
+	}
+
+	tx, err := c.Conn.BeginTx(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	c.tx = &ownTx{conn: c, Tx: tx}
+	return c.tx, nil
+}
+
+func (c *routeConn) Prepare(query string) (driver.Stmt, error) {
+	return c.PrepareContext(context.Background(), query)
+}
+
+// PrepareContext prepares query on the wrapped connection unless ctx carries a session transaction.
+// The statement is routed again every time it runs, since database/sql reuses it with other contexts.
+func (c *routeConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	s := &routeStmt{conn: c, query: query}
+	if c.ambient(ctx) == nil {
+		if _, err := s.prepare(ctx); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// ExecContext runs query, noting it for the session transaction when it is routed to it.
+// The statements of the session transaction reaching its own connection are noted by the executor running them.
+func (c *routeConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	if tx := c.ambient(ctx); tx != nil {
+		noteStatement(ctx, query)
+		return tx.ExecContext(ctx, query, namedArgs(args)...)
+	}
+	ec, ok := c.Conn.Conn.(driver.ExecerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	return ec.ExecContext(ctx, query, args)
+}
+
+func (c *routeConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	if tx := c.ambient(ctx); tx != nil {
+		noteStatement(ctx, query)
+		rows, err := tx.QueryContext(ctx, query, namedArgs(args)...)
+		if err != nil {
+			return nil, err
+		}
+		return newTxRows(rows)
+	}
+	qc, ok := c.Conn.Conn.(driver.QueryerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	return qc.QueryContext(ctx, query, args)
+}
+
+// ownTx is a transaction of the wrapped connection
+type ownTx struct {
+	conn *routeConn
+	driver.Tx
+}
+
+func (t *ownTx) Commit() error {
+	t.conn.tx = nil
+	return t.Tx.Commit()
+}
+
+func (t *ownTx) Rollback() error {
+	t.conn.tx = nil
+	return t.Tx.Rollback()
+}
+
+// joinedTx is a transaction run in a savepoint of a session transaction.
+// Committing releases the savepoint and rolling back only undoes the changes made since it.
+type joinedTx struct {
+	conn *routeConn
+	tx   *sql.Tx
+	name string
+}
+
+func (t *joinedTx) Commit() error {
+	t.conn.joined = nil
+	_, err := t.tx.Exec("RELEASE SAVEPOINT " + t.name)
+	return err
+}
+
+func (t *joinedTx) Rollback() error {
+	t.conn.joined = nil
+	_, err := t.tx.Exec("ROLLBACK TO SAVEPOINT " + t.name)
+	if err == nil {
+		_, err = t.tx.Exec("RELEASE SAVEPOINT " + t.name)
+	}
+	return err
+}
+
+// routeStmt is a statement routed every time it runs
+type routeStmt struct {
+	conn  *routeConn
+	query string
+	// stmt is the statement prepared on the wrapped connection, on first use outside of a session transaction
+	stmt *driverwrap.Stmt
+}
+
+func (s *routeStmt) prepare(ctx context.Context) (*driverwrap.Stmt, error) {
+	if s.stmt != nil {
+		return s.stmt, nil
+	}
+	stmt, err := s.conn.Conn.PrepareContext(ctx, s.query)
+	if err != nil {
+		return nil, err
+	}
+	s.stmt = &driverwrap.Stmt{Stmt: stmt}
+	return s.stmt, nil
+}
+
+func (s *routeStmt) Close() error {
+	if s.stmt == nil {
+		return nil
+	}
+	return s.stmt.Close()
+}
+
+func (s *routeStmt) NumInput() int {
+	return -1
+}
+
+func (s *routeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return s.ExecContext(context.Background(), driverwrap.ValuesToNamed(args))
+}
+
+func (s *routeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return s.QueryContext(context.Background(), driverwrap.ValuesToNamed(args))
+}
+
+func (s *routeStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	if tx := s.conn.ambient(ctx); tx != nil {
+		noteStatement(ctx, s.query)
+		return tx.ExecContext(ctx, s.query, namedArgs(args)...)
+	}
+	stmt, err := s.prepare(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return stmt.ExecContext(ctx, args)
+}
+
+func (s *routeStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	if tx := s.conn.ambient(ctx); tx != nil {
+		noteStatement(ctx, s.query)
+		rows, err := tx.QueryContext(ctx, s.query, namedArgs(args)...)
+		if err != nil {
+			return nil, err
+		}
+		return newTxRows(rows)
+	}
+	stmt, err := s.prepare(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return stmt.QueryContext(ctx, args)
+}
+
+// txRows reads the rows of a routed query.
+// The values are the ones the driver of the session transaction returned.
+type txRows struct {
+	rows    *sql.Rows
+	columns []string
+}
+
+func newTxRows(rows *sql.Rows) (*txRows, error) {
+	columns, err := rows.Columns()
+	if err != nil {
+		rows.Close()
+		return nil, err
+	}
+	return &txRows{rows: rows, columns: columns}, nil
+}
+
+func (r *txRows) Columns() []string {
+	return r.columns
+}
+
+func (r *txRows) Close() error {
+	return r.rows.Close()
+}
+
+func (r *txRows) Next(dest []driver.Value) error {
+	if !r.rows.Next() {
+		if err := r.rows.Err(); err != nil {
+			return err
+		}
+		return io.EOF
+	}
+	values := make([]any, len(dest))
+	ptrs := make([]any, len(dest))
+	for i := range values {
+		ptrs[i] = &values[i]
+	}
+	if err := r.rows.Scan(ptrs...); err != nil {
+		return err
+	}
+	for i, v := range values {
+		dest[i] = v
+	}
+	return nil
+}
+
+func namedArgs(args []driver.NamedValue) []any {
+	values := make([]any, len(args))
+	for i, arg := range args {
+		if arg.Name != "" {
+			values[i] = sql.Named(arg.Name, arg.Value)
+		} else {
+			values[i] = arg.Value
+		}
+	}
+	return values
+}
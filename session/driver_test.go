@@ -0,0 +1,260 @@
+package session
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/suite"
+)
+
+func init() {
+	sql.Register("sqlite3-session-test", WrapDriver(&sqlite3.SQLiteDriver{}))
+}
+
+type DriverTestSuite struct {
+	suite.Suite
+	session Session
+	sqlDB   *sql.DB
+	// legacy is the database handed to code unaware of the session
+	legacy *sql.DB
+}
+
+func (s *DriverTestSuite) SetupTest() {
+	dsn := "file:" + filepath.Join(s.T().TempDir(), "test.db") + "?_busy_timeout=100"
+	db, err := sql.Open("sqlite3", dsn)
+	s.Require().NoError(err)
+	legacy, err := sql.Open("sqlite3-session-test", dsn)
+	s.Require().NoError(err)
+
+	_, err = db.Exec(`CREATE TABLE models (id TEXT PRIMARY KEY, n INTEGER)`)
+	s.Require().NoError(err)
+
+	s.sqlDB = db
+	s.legacy = legacy
+	s.session = NewSession(db, WithRouting(legacy))
+}
+
+func (s *DriverTestSuite) TearDownTest() {
+	s.legacy.Close()
+	s.sqlDB.Close()
+}
+
+func (s *DriverTestSuite) count() int {
+	var n int
+	s.Require().NoError(s.sqlDB.QueryRow(`SELECT COUNT(*) FROM models`).Scan(&n))
+	return n
+}
+
+func (s *DriverTestSuite) TestExecRoutedToTransaction() {
+	err := s.session.WithTransaction(context.Background(), func(ctx context.Context) error {
+		if _, err := s.legacy.ExecContext(ctx, `INSERT INTO models (id, n) VALUES (?, ?)`, "a", 1); err != nil {
+			return err
+		}
+		return errors.New("rollback")
+	})
+	s.Error(err)
+	s.Equal(0, s.count())
+}
+
+func (s *DriverTestSuite) TestQueryRoutedToTransaction() {
+	err := s.session.WithTransaction(context.Background(), func(ctx context.Context) error {
+		tx := GetTx(ctx).(*sql.Tx)
+		if _, err := tx.ExecContext(ctx, `INSERT INTO models (id, n) VALUES (?, ?)`, "a", 7); err != nil {
+			return err
+		}
+
+		var id string
+		var n int
+		s.Require().NoError(s.legacy.QueryRowContext(ctx, `SELECT id, n FROM models WHERE id = ?`, "a").Scan(&id, &n))
+		s.Equal("a", id)
+		s.Equal(7, n)
+		return nil
+	})
+	s.NoError(err)
+	s.Equal(1, s.count())
+}
+
+func (s *DriverTestSuite) TestWithoutTransaction() {
+	_, err := s.legacy.ExecContext(context.Background(), `INSERT INTO models (id, n) VALUES (?, ?)`, "a", 1)
+	s.NoError(err)
+	s.Equal(1, s.count())
+}
+
+func (s *DriverTestSuite) TestPreparedStatementRoutedWhenRun() {
+	stmt, err := s.legacy.Prepare(`INSERT INTO models (id, n) VALUES (?, ?)`)
+	s.Require().NoError(err)
+	defer stmt.Close()
+
+	err = s.session.WithTransaction(context.Background(), func(ctx context.Context) error {
+		if _, err := stmt.ExecContext(ctx, "a", 1); err != nil {
+			return err
+		}
+		return errors.New("rollback")
+	})
+	s.Error(err)
+	s.Equal(0, s.count())
+
+	_, err = stmt.Exec("b", 2)
+	s.NoError(err)
+	s.Equal(1, s.count())
+}
+
+func (s *DriverTestSuite) TestBeginJoinsInSavepoint() {
+	err := s.session.WithTransaction(context.Background(), func(ctx context.Context) error {
+		tx, err := s.legacy.BeginTx(ctx, nil)
+		s.Require().NoError(err)
+		_, err = tx.Exec(`INSERT INTO models (id, n) VALUES (?, ?)`, "a", 1)
+		s.Require().NoError(err)
+		s.Require().NoError(tx.Rollback())
+
+		tx, err = s.legacy.BeginTx(ctx, nil)
+		s.Require().NoError(err)
+		_, err = tx.Exec(`INSERT INTO models (id, n) VALUES (?, ?)`, "b", 2)
+		s.Require().NoError(err)
+		s.Require().NoError(tx.Commit())
+		return nil
+	})
+	s.NoError(err)
+
+	var id string
+	s.Require().NoError(s.sqlDB.QueryRow(`SELECT id FROM models`).Scan(&id))
+	s.Equal("b", id)
+	s.Equal(1, s.count())
+}
+
+func (s *DriverTestSuite) TestSessionOnWrappedDatabase() {
+	sess := NewSession(s.legacy)
+	db := NewDB(s.legacy)
+
+	err := sess.WithTransaction(context.Background(), func(ctx context.Context) error {
+		if _, err := db.GetDB(ctx).ExecContext(ctx, `INSERT INTO models (id, n) VALUES (?, ?)`, "a", 1); err != nil {
+			return err
+		}
+		if _, err := s.legacy.ExecContext(ctx, `INSERT INTO models (id, n) VALUES (?, ?)`, "b", 2); err != nil {
+			return err
+		}
+		return sess.WithTransaction(ctx, func(ctx context.Context) error {
+			_, err := db.GetDB(ctx).ExecContext(ctx, `INSERT INTO models (id, n) VALUES (?, ?)`, "c", 3)
+			return err
+		}, WithPropagation(PropagationNested))
+	})
+	s.NoError(err)
+	s.Equal(3, s.count())
+}
+
+func (s *DriverTestSuite) TestSharedBoundedPool() {
+	s.legacy.SetMaxOpenConns(2)
+	sess := NewSession(s.legacy)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err := sess.WithTransaction(ctx, func(ctx context.Context) error {
+		for _, id := range []string{"a", "b", "c"} {
+			if _, err := s.legacy.ExecContext(ctx, `INSERT INTO models (id, n) VALUES (?, ?)`, id, 1); err != nil {
+				return err
+			}
+		}
+		var n int
+		return s.legacy.QueryRowContext(ctx, `SELECT COUNT(*) FROM models`).Scan(&n)
+	})
+	s.NoError(err)
+	s.Equal(3, s.count())
+}
+
+func (s *DriverTestSuite) TestPreparedStatementNotedOnce() {
+	sess := NewSession(s.sqlDB, WithRouting(s.legacy), WithRegistry(NewRegistry()))
+
+	err := sess.WithTransaction(context.Background(), func(ctx context.Context) error {
+		stmt, err := s.legacy.PrepareContext(ctx, `INSERT INTO models (id, n) VALUES (?, ?)`)
+		s.Require().NoError(err)
+		defer stmt.Close()
+		if _, err := stmt.ExecContext(ctx, "a", 1); err != nil {
+			return err
+		}
+		if _, err := s.legacy.ExecContext(ctx, `INSERT INTO models (id, n) VALUES (?, ?)`, "b", 2); err != nil {
+			return err
+		}
+		s.Equal(int64(2), Current(ctx).root().statements.Load())
+		return nil
+	})
+	s.NoError(err)
+}
+
+func (s *DriverTestSuite) TestSessionOnWrappedDatabaseNotedOnce() {
+	sess := NewSession(s.legacy, WithRegistry(NewRegistry()))
+	db := NewDB(s.legacy)
+
+	err := sess.WithTransaction(context.Background(), func(ctx context.Context) error {
+		if _, err := db.GetDB(ctx).ExecContext(ctx, `INSERT INTO models (id, n) VALUES (?, ?)`, "a", 1); err != nil {
+			return err
+		}
+		s.Equal(int64(1), Current(ctx).root().statements.Load())
+		return nil
+	})
+	s.NoError(err)
+}
+
+func (s *DriverTestSuite) TestOtherDatabaseNotRouted() {
+	other, err := sql.Open("sqlite3-session-test", "file:"+filepath.Join(s.T().TempDir(), "other.db"))
+	s.Require().NoError(err)
+	defer other.Close()
+	_, err = other.Exec(`CREATE TABLE models (id TEXT PRIMARY KEY, n INTEGER)`)
+	s.Require().NoError(err)
+
+	err = NewSession(s.legacy).WithTransaction(context.Background(), func(ctx context.Context) error {
+		if _, err := other.ExecContext(ctx, `INSERT INTO models (id, n) VALUES (?, ?)`, "a", 1); err != nil {
+			return err
+		}
+		return errors.New("rollback")
+	})
+	s.Error(err)
+
+	var n int
+	s.Require().NoError(other.QueryRow(`SELECT COUNT(*) FROM models`).Scan(&n))
+	s.Equal(1, n)
+}
+
+func (s *DriverTestSuite) TestNotRoutedWithoutRouting() {
+	err := NewSession(s.sqlDB).WithTransaction(context.Background(), func(ctx context.Context) error {
+		if _, err := s.legacy.ExecContext(ctx, `INSERT INTO models (id, n) VALUES (?, ?)`, "a", 1); err != nil {
+			return err
+		}
+		return errors.New("rollback")
+	})
+	s.Error(err)
+	s.Equal(1, s.count())
+}
+
+func (s *DriverTestSuite) TestWithRoutingUnwrappedDatabase() {
+	s.Panics(func() {
+		NewSession(s.sqlDB, WithRouting(s.sqlDB))
+	})
+}
+
+func (s *DriverTestSuite) TestConnector() {
+	db := sql.OpenDB(Connector(&sqlite3.SQLiteDriver{}, "file:"+filepath.Join(s.T().TempDir(), "connector.db")))
+	defer db.Close()
+	_, err := db.Exec(`CREATE TABLE models (id TEXT PRIMARY KEY)`)
+	s.Require().NoError(err)
+
+	err = NewSession(db).WithTransaction(context.Background(), func(ctx context.Context) error {
+		if _, err := db.ExecContext(ctx, `INSERT INTO models (id) VALUES (?)`, "a"); err != nil {
+			return err
+		}
+		return errors.New("rollback")
+	})
+	s.Error(err)
+
+	var n int
+	s.Require().NoError(db.QueryRow(`SELECT COUNT(*) FROM models`).Scan(&n))
+	s.Equal(0, n)
+}
+
+func TestDriverTestSuite(t *testing.T) {
+	suite.Run(t, new(DriverTestSuite))
+}
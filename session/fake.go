@@ -0,0 +1,77 @@
+package session
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aeramu/sql-transaction/session/internal/faketx"
+)
+
+func init() {
+	faketx.Begin = func(ctx context.Context, cfg any) (context.Context, faketx.Tx) {
+		return beginFakeTx(ctx, cfg.(TxConfig))
+	}
+}
+
+// fakeTx is a transaction, or a savepoint of one, without a SQL transaction behind it, for the fakes of Session.
+// Of its config, only ReadOnly and Budget have an effect, and GetTx returns nil for it.
+type fakeTx struct {
+	st *TxState
+	// set for a savepoint, the lists of the root its rollback truncates to
+	mark savepointMark
+}
+
+func beginFakeTx(ctx context.Context, cfg TxConfig) (context.Context, *fakeTx) {
+	if parent := getState(ctx); parent != nil && parent.propagation(cfg.Propagation) == PropagationNested {
+		st, mark := parent.savepoint(cfg.Budget)
+		st.root().nesting.Add(1)
+		return withState(ctx, st), &fakeTx{st: st, mark: mark}
+	}
+
+	t := &fakeTx{st: &TxState{
+		id:        newTxID(),
+		startedAt: time.Now(),
+		config:    cfg,
+		budget:    cfg.Budget,
+	}}
+	t.st.budgeted.Store(cfg.Budget != Budget{})
+	return withState(ctx, t.st), t
+}
+
+func (t *fakeTx) Savepoint() bool {
+	return t.st.parent != nil
+}
+
+func (t *fakeTx) Prepare(ctx context.Context) error {
+	if t.Savepoint() {
+		return t.st.budgetErr()
+	}
+	if t.st.RollbackOnly() {
+		return ErrRollbackOnly
+	}
+	if err := prepareResources(ctx, t.st.resources); err != nil {
+		return fmt.Errorf("resource prepare error: %w", err)
+	}
+	return nil
+}
+
+func (t *fakeTx) Commit(ctx context.Context) error {
+	if t.Savepoint() {
+		t.st.root().nesting.Add(-1)
+		return nil
+	}
+	defer t.st.end(ctx, t.st.afterCommit)
+	return commitResources(ctx, t.st.resources, nil)
+}
+
+func (t *fakeTx) Rollback(ctx context.Context) error {
+	ctx = context.WithoutCancel(ctx)
+	if !t.Savepoint() {
+		defer t.st.end(ctx, t.st.afterRollback)
+		return rollbackResources(ctx, t.st.resources)
+	}
+	root := t.st.root()
+	root.nesting.Add(-1)
+	return root.rollbackTo(ctx, t.mark)
+}
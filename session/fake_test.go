@@ -0,0 +1,58 @@
+package session
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBeginFakeTx(t *testing.T) {
+	ctx, tx := beginFakeTx(context.Background(), TxConfig{ReadOnly: true})
+	require.Same(t, tx.st, Current(ctx))
+	assert.False(t, tx.Savepoint())
+	assert.True(t, Current(ctx).Options().ReadOnly)
+	assert.Nil(t, GetTx(ctx))
+
+	spCtx, sp := beginFakeTx(ctx, TxConfig{Propagation: PropagationNested})
+	assert.True(t, sp.Savepoint())
+	assert.Equal(t, 1, Depth(spCtx))
+	assert.Equal(t, TxID(ctx), TxID(spCtx))
+
+	var ran []string
+	require.NoError(t, AfterRollback(spCtx, func(context.Context) { ran = append(ran, "savepoint") }))
+	require.NoError(t, sp.Rollback(spCtx))
+	require.NoError(t, AfterCommit(ctx, func(context.Context) { ran = append(ran, "commit") }))
+	require.NoError(t, tx.Prepare(ctx))
+	require.NoError(t, tx.Commit(ctx))
+	assert.Equal(t, []string{"savepoint", "commit"}, ran)
+	assert.ErrorIs(t, tx.st.Err(), ErrTxFinished)
+}
+
+func TestBeginFakeTx_wrapperResolvesDatabase(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	defer db.Close()
+
+	ctx, _ := beginFakeTx(context.Background(), TxConfig{})
+	assert.Equal(t, Executor(db), NewDB(db).GetDB(ctx))
+}
+
+func TestBeginFakeTx_savepointBudgetExceeded(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	defer db.Close()
+
+	ctx, tx := beginFakeTx(context.Background(), TxConfig{})
+	spCtx, sp := beginFakeTx(ctx, TxConfig{Propagation: PropagationNested, Budget: Budget{MaxStatements: 1}})
+	for i := 0; i < 2; i++ {
+		_, err = NewDB(db).GetDB(spCtx).ExecContext(spCtx, "SELECT 1")
+	}
+	assert.ErrorIs(t, err, ErrBudgetExceeded)
+
+	assert.ErrorIs(t, sp.Prepare(spCtx), ErrBudgetExceeded)
+	require.NoError(t, sp.Rollback(spCtx))
+	assert.NoError(t, tx.Prepare(ctx))
+}
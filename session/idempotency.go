@@ -0,0 +1,334 @@
+package session
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/aeramu/sql-transaction/session/dialect"
+)
+
+// DefaultIdempotencyTable is the table used by Idempotent when no table is configured
+const DefaultIdempotencyTable = "idempotency_keys"
+
+const (
+	idempotencyInProgress = "in_progress"
+	idempotencyCompleted  = "completed"
+)
+
+// ErrInProgress is returned by Idempotent when another caller is currently executing the same key
+var ErrInProgress = errors.New("idempotency key is in progress")
+
+// IdempotencyOption configures Idempotent, CreateIdempotencySchema and the sweeper
+type IdempotencyOption func(*idempotencyConfig)
+
+type idempotencyConfig struct {
+	table       string
+	dialect     dialect.Dialect
+	ttl         time.Duration
+	lockTimeout time.Duration
+	now         func() time.Time
+	onSweepErr  func(err error)
+}
+
+// WithIdempotencyTable sets the name of the idempotency table.
+// The name is inserted into the statements as is and must be trusted.
+func WithIdempotencyTable(table string) IdempotencyOption {
+	return func(c *idempotencyConfig) {
+		c.table = table
+	}
+}
+
+// WithIdempotencyDialect sets the dialect used to build the idempotency statements
+func WithIdempotencyDialect(d dialect.Dialect) IdempotencyOption {
+	return func(c *idempotencyConfig) {
+		c.dialect = d
+	}
+}
+
+// WithIdempotencyTTL sets how long a completed key is replayed before it expires. Defaults to 24 hours.
+func WithIdempotencyTTL(ttl time.Duration) IdempotencyOption {
+	return func(c *idempotencyConfig) {
+		c.ttl = ttl
+	}
+}
+
+// WithIdempotencyLockTimeout sets how long an in-progress key blocks duplicates
+// before it is considered abandoned and can be claimed again. Defaults to 1 minute.
+func WithIdempotencyLockTimeout(d time.Duration) IdempotencyOption {
+	return func(c *idempotencyConfig) {
+		c.lockTimeout = d
+	}
+}
+
+// WithSweepErrorHandler sets the function receiving the errors of the sweeps of RunIdempotencySweeper,
+// which keeps sweeping after them. By default they are logged.
+func WithSweepErrorHandler(h func(err error)) IdempotencyOption {
+	return func(c *idempotencyConfig) {
+		c.onSweepErr = h
+	}
+}
+
+func newIdempotencyConfig(opts []IdempotencyOption) *idempotencyConfig {
+	c := &idempotencyConfig{
+		table:       DefaultIdempotencyTable,
+		dialect:     dialect.SQLite,
+		ttl:         24 * time.Hour,
+		lockTimeout: time.Minute,
+		now:         time.Now,
+		onSweepErr: func(err error) {
+			log.Printf("idempotency sweeper: %v", err)
+		},
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Idempotent runs f at most once per key.
+// The key is claimed before f runs, so a concurrent duplicate gets ErrInProgress.
+// f runs in a transaction started through s, and its result is stored with the key in that same
+// transaction, so the key is only completed when the business writes commit.
+// Calling Idempotent again with a completed key returns the stored result without running f.
+// If f fails, the claim is released and the key can be retried.
+//
+// The claim is committed in a transaction of its own, begun on a context WithoutTx,
+// so it is not rolled back with a transaction carried by ctx, such as the one of sessiontest,
+// and it needs a second connection while that transaction is open: with SetMaxOpenConns(1) it deadlocks.
+// When f runs in an outer transaction carried by ctx which later rolls back, the key is not released
+// and stays in progress until the lock timeout passes.
+// s must be a database/sql session, whose transactions are *sql.Tx.
+func Idempotent(ctx context.Context, s Session, key string, f func(ctx context.Context) ([]byte, error), opts ...IdempotencyOption) ([]byte, error) {
+	c := newIdempotencyConfig(opts)
+
+	token, err := newRandomID()
+	if err != nil {
+		return nil, err
+	}
+
+	// the claim must be visible to other callers, so it is committed on its own
+	// instead of joining a transaction carried by ctx
+	claimCtx := WithoutTx(ctx)
+	var claimed bool
+	var stored []byte
+	err = s.WithTransaction(claimCtx, func(ctx context.Context) error {
+		tx, err := idempotencyTx(ctx)
+		if err != nil {
+			return err
+		}
+		claimed, stored, err = c.claim(ctx, tx, key, token)
+		return err
+	})
+	if errors.Is(err, ErrInProgress) {
+		return nil, fmt.Errorf("idempotency key %q: %w", key, ErrInProgress)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim idempotency key %q: %w", key, err)
+	}
+	if !claimed {
+		return stored, nil
+	}
+
+	var result []byte
+	err = s.WithTransaction(ctx, func(ctx context.Context) error {
+		out, err := f(ctx)
+		if err != nil {
+			return err
+		}
+		tx, err := idempotencyTx(ctx)
+		if err != nil {
+			return err
+		}
+		result = out
+		return c.complete(ctx, tx, key, token, result)
+	})
+	if err != nil {
+		if relErr := s.WithTransaction(claimCtx, func(ctx context.Context) error {
+			tx, err := idempotencyTx(ctx)
+			if err != nil {
+				return err
+			}
+			return c.release(ctx, tx, key, token)
+		}); relErr != nil {
+			return nil, fmt.Errorf("%w (release error: %v)", err, relErr)
+		}
+		return nil, err
+	}
+	return result, nil
+}
+
+// idempotencyTx returns the *sql.Tx carried by ctx, which sessions of other adapters do not carry
+func idempotencyTx(ctx context.Context) (*sql.Tx, error) {
+	tx, ok := GetTx(ctx).(*sql.Tx)
+	if !ok || tx == nil {
+		return nil, fmt.Errorf("idempotency requires a database/sql transaction, got %T", GetTx(ctx))
+	}
+	return tx, nil
+}
+
+// claim inserts the key as in progress, or takes it over when the existing row expired.
+// When the key is held by someone else, it returns the stored result or ErrInProgress.
+func (c *idempotencyConfig) claim(ctx context.Context, tx *sql.Tx, key, token string) (bool, []byte, error) {
+	now := c.now()
+	res, err := tx.ExecContext(ctx, c.dialect.Rebind(c.claimQuery()), c.claimArgs(key, token, now)...)
+	if err != nil {
+		return false, nil, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, nil, err
+	}
+	if n > 0 {
+		return true, nil, nil
+	}
+
+	var status string
+	var result []byte
+	query := fmt.Sprintf(`SELECT status, result FROM %s WHERE idempotency_key = ?`, c.table)
+	if err := tx.QueryRowContext(ctx, c.dialect.Rebind(query), key).Scan(&status, &result); err != nil {
+		return false, nil, err
+	}
+	if status != idempotencyCompleted {
+		return false, nil, ErrInProgress
+	}
+	return false, result, nil
+}
+
+func (c *idempotencyConfig) claimQuery() string {
+	if c.dialect == dialect.MySQL {
+		return fmt.Sprintf(`INSERT INTO %s (idempotency_key, status, token, result, expires_at, created_at)
+			VALUES (?, ?, ?, NULL, ?, ?)
+			ON DUPLICATE KEY UPDATE
+				status = IF(expires_at <= ?, VALUES(status), status),
+				token = IF(expires_at <= ?, VALUES(token), token),
+				result = IF(expires_at <= ?, NULL, result),
+				created_at = IF(expires_at <= ?, VALUES(created_at), created_at),
+				expires_at = IF(expires_at <= ?, VALUES(expires_at), expires_at)`, c.table)
+	}
+	return fmt.Sprintf(`INSERT INTO %[1]s (idempotency_key, status, token, result, expires_at, created_at)
+		VALUES (?, ?, ?, NULL, ?, ?)
+		ON CONFLICT (idempotency_key) DO UPDATE SET
+			status = excluded.status,
+			token = excluded.token,
+			result = NULL,
+			expires_at = excluded.expires_at,
+			created_at = excluded.created_at
+		WHERE %[1]s.expires_at <= ?`, c.table)
+}
+
+func (c *idempotencyConfig) claimArgs(key, token string, now time.Time) []any {
+	ms := now.UnixMilli()
+	args := []any{key, idempotencyInProgress, token, now.Add(c.lockTimeout).UnixMilli(), ms}
+	if c.dialect == dialect.MySQL {
+		return append(args, ms, ms, ms, ms, ms)
+	}
+	return append(args, ms)
+}
+
+func (c *idempotencyConfig) complete(ctx context.Context, tx *sql.Tx, key, token string, result []byte) error {
+	query := fmt.Sprintf(`UPDATE %s SET status = ?, result = ?, expires_at = ? WHERE idempotency_key = ? AND token = ?`, c.table)
+	res, err := tx.ExecContext(ctx, c.dialect.Rebind(query),
+		idempotencyCompleted, result, c.now().Add(c.ttl).UnixMilli(), key, token)
+	if err != nil {
+		return fmt.Errorf("failed to complete idempotency key %q: %w", key, err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to complete idempotency key %q: %w", key, err)
+	}
+	if n == 0 {
+		return fmt.Errorf("idempotency key %q was claimed by another caller: %w", key, ErrInProgress)
+	}
+	return nil
+}
+
+func (c *idempotencyConfig) release(ctx context.Context, tx *sql.Tx, key, token string) error {
+	query := fmt.Sprintf(`DELETE FROM %s WHERE idempotency_key = ? AND token = ? AND status = ?`, c.table)
+	_, err := tx.ExecContext(ctx, c.dialect.Rebind(query), key, token, idempotencyInProgress)
+	return err
+}
+
+func newRandomID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate random id: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// CreateIdempotencySchema creates the idempotency table if it does not exist
+func CreateIdempotencySchema(ctx context.Context, db Executor, opts ...IdempotencyOption) error {
+	c := newIdempotencyConfig(opts)
+
+	var query string
+	switch c.dialect {
+	case dialect.Postgres:
+		query = `CREATE TABLE IF NOT EXISTS %s (
+			idempotency_key VARCHAR(255) PRIMARY KEY,
+			status VARCHAR(16) NOT NULL,
+			token VARCHAR(32) NOT NULL,
+			result BYTEA,
+			expires_at BIGINT NOT NULL,
+			created_at BIGINT NOT NULL
+		)`
+	case dialect.MySQL:
+		query = `CREATE TABLE IF NOT EXISTS %s (
+			idempotency_key VARCHAR(255) PRIMARY KEY,
+			status VARCHAR(16) NOT NULL,
+			token VARCHAR(32) NOT NULL,
+			result LONGBLOB,
+			expires_at BIGINT NOT NULL,
+			created_at BIGINT NOT NULL
+		)`
+	default:
+		query = `CREATE TABLE IF NOT EXISTS %s (
+			idempotency_key TEXT PRIMARY KEY,
+			status TEXT NOT NULL,
+			token TEXT NOT NULL,
+			result BLOB,
+			expires_at INTEGER NOT NULL,
+			created_at INTEGER NOT NULL
+		)`
+	}
+	if _, err := db.ExecContext(ctx, fmt.Sprintf(query, c.table)); err != nil {
+		return fmt.Errorf("failed to create idempotency schema: %w", err)
+	}
+	return nil
+}
+
+// SweepIdempotencyKeys deletes the expired keys and returns how many were removed
+func SweepIdempotencyKeys(ctx context.Context, db Executor, opts ...IdempotencyOption) (int64, error) {
+	c := newIdempotencyConfig(opts)
+
+	query := fmt.Sprintf(`DELETE FROM %s WHERE expires_at <= ?`, c.table)
+	res, err := db.ExecContext(ctx, c.dialect.Rebind(query), c.now().UnixMilli())
+	if err != nil {
+		return 0, fmt.Errorf("failed to sweep idempotency keys: %w", err)
+	}
+	return res.RowsAffected()
+}
+
+// RunIdempotencySweeper sweeps the expired keys every interval until ctx is done.
+// A failed sweep goes to the handler of WithSweepErrorHandler and the next one runs at the next interval.
+func RunIdempotencySweeper(ctx context.Context, db Executor, interval time.Duration, opts ...IdempotencyOption) error {
+	onErr := newIdempotencyConfig(opts).onSweepErr
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			if _, err := SweepIdempotencyKeys(ctx, db, opts...); err != nil {
+				onErr(err)
+			}
+		}
+	}
+}
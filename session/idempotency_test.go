@@ -0,0 +1,226 @@
+package session
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/suite"
+
+	"github.com/aeramu/sql-transaction/session/dialect"
+)
+
+type IdempotencyTestSuite struct {
+	suite.Suite
+	sqlDB   *sql.DB
+	db      DBWrapper[Executor]
+	session Session
+	now     time.Time
+	opts    []IdempotencyOption
+}
+
+func (s *IdempotencyTestSuite) SetupTest() {
+	path := filepath.Join(s.T().TempDir(), "idempotency.db")
+	db, err := sql.Open("sqlite3", "file:"+path+"?_busy_timeout=1000")
+	s.Require().NoError(err)
+
+	_, err = db.Exec(`CREATE TABLE payments (id TEXT PRIMARY KEY)`)
+	s.Require().NoError(err)
+
+	s.now = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	s.opts = []IdempotencyOption{
+		WithIdempotencyTTL(time.Hour),
+		func(c *idempotencyConfig) { c.now = func() time.Time { return s.now } },
+	}
+	s.Require().NoError(CreateIdempotencySchema(context.Background(), db, s.opts...))
+
+	s.sqlDB = db
+	s.db = NewDB(db)
+	s.session = NewSession(db)
+}
+
+func (s *IdempotencyTestSuite) TearDownTest() {
+	s.sqlDB.Close()
+}
+
+func (s *IdempotencyTestSuite) pay(id string, calls *int) func(ctx context.Context) ([]byte, error) {
+	return func(ctx context.Context) ([]byte, error) {
+		*calls++
+		_, err := s.db.GetDB(ctx).ExecContext(ctx, "INSERT INTO payments (id) VALUES (?)", id)
+		if err != nil {
+			return nil, err
+		}
+		return []byte("paid " + id), nil
+	}
+}
+
+func (s *IdempotencyTestSuite) countPayments() int {
+	var count int
+	s.Require().NoError(s.sqlDB.QueryRow("SELECT COUNT(*) FROM payments").Scan(&count))
+	return count
+}
+
+func (s *IdempotencyTestSuite) TestIdempotent_replaysStoredResult() {
+	var calls int
+	ctx := context.Background()
+
+	res, err := Idempotent(ctx, s.session, "key-1", s.pay("p1", &calls), s.opts...)
+	s.NoError(err)
+	s.Equal("paid p1", string(res))
+
+	res, err = Idempotent(ctx, s.session, "key-1", s.pay("p2", &calls), s.opts...)
+	s.NoError(err)
+	s.Equal("paid p1", string(res))
+
+	s.Equal(1, calls)
+	s.Equal(1, s.countPayments())
+}
+
+func (s *IdempotencyTestSuite) TestIdempotent_failureReleasesKey() {
+	var calls int
+	ctx := context.Background()
+	expectedErr := errors.New("card declined")
+
+	_, err := Idempotent(ctx, s.session, "key-1", func(ctx context.Context) ([]byte, error) {
+		_, err := s.pay("p1", &calls)(ctx)
+		s.NoError(err)
+		return nil, expectedErr
+	}, s.opts...)
+	s.ErrorIs(err, expectedErr)
+	s.Equal(0, s.countPayments())
+
+	res, err := Idempotent(ctx, s.session, "key-1", s.pay("p1", &calls), s.opts...)
+	s.NoError(err)
+	s.Equal("paid p1", string(res))
+	s.Equal(2, calls)
+	s.Equal(1, s.countPayments())
+}
+
+func (s *IdempotencyTestSuite) TestIdempotent_concurrentDuplicate() {
+	var calls int
+	ctx := context.Background()
+
+	_, err := Idempotent(ctx, s.session, "key-1", func(ctx context.Context) ([]byte, error) {
+		_, dupErr := Idempotent(context.Background(), s.session, "key-1", s.pay("p2", &calls), s.opts...)
+		s.ErrorIs(dupErr, ErrInProgress)
+		return s.pay("p1", &calls)(ctx)
+	}, s.opts...)
+
+	s.NoError(err)
+	s.Equal(1, calls)
+	s.Equal(1, s.countPayments())
+}
+
+func (s *IdempotencyTestSuite) TestIdempotent_abandonedClaimTakenOver() {
+	var calls int
+	ctx := context.Background()
+
+	_, err := s.sqlDB.Exec(`INSERT INTO idempotency_keys (idempotency_key, status, token, expires_at, created_at)
+		VALUES (?, ?, ?, ?, ?)`, "key-1", idempotencyInProgress, "crashed", s.now.Add(time.Second).UnixMilli(), s.now.UnixMilli())
+	s.Require().NoError(err)
+
+	_, err = Idempotent(ctx, s.session, "key-1", s.pay("p1", &calls), s.opts...)
+	s.ErrorIs(err, ErrInProgress)
+
+	s.now = s.now.Add(time.Second)
+	res, err := Idempotent(ctx, s.session, "key-1", s.pay("p1", &calls), s.opts...)
+	s.NoError(err)
+	s.Equal("paid p1", string(res))
+	s.Equal(1, calls)
+}
+
+func (s *IdempotencyTestSuite) TestIdempotent_expiredKeyRunsAgain() {
+	var calls int
+	ctx := context.Background()
+
+	_, err := Idempotent(ctx, s.session, "key-1", s.pay("p1", &calls), s.opts...)
+	s.NoError(err)
+
+	s.now = s.now.Add(time.Hour)
+	res, err := Idempotent(ctx, s.session, "key-1", s.pay("p2", &calls), s.opts...)
+	s.NoError(err)
+	s.Equal("paid p2", string(res))
+	s.Equal(2, calls)
+}
+
+func (s *IdempotencyTestSuite) TestSweepIdempotencyKeys() {
+	var calls int
+	ctx := context.Background()
+
+	_, err := Idempotent(ctx, s.session, "key-1", s.pay("p1", &calls), s.opts...)
+	s.NoError(err)
+	s.now = s.now.Add(30 * time.Minute)
+	_, err = Idempotent(ctx, s.session, "key-2", s.pay("p2", &calls), s.opts...)
+	s.NoError(err)
+
+	s.now = s.now.Add(30 * time.Minute)
+	n, err := SweepIdempotencyKeys(ctx, s.sqlDB, s.opts...)
+	s.NoError(err)
+	s.Equal(int64(1), n)
+
+	var key string
+	s.NoError(s.sqlDB.QueryRow("SELECT idempotency_key FROM idempotency_keys").Scan(&key))
+	s.Equal("key-2", key)
+}
+
+func (s *IdempotencyTestSuite) TestRunIdempotencySweeper_keepsGoing() {
+	var mu sync.Mutex
+	var failures int
+	opts := append(append([]IdempotencyOption{}, s.opts...), WithIdempotencyTable("missing_keys"), WithSweepErrorHandler(func(err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		failures++
+	}))
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- RunIdempotencySweeper(ctx, s.sqlDB, time.Millisecond, opts...)
+	}()
+
+	s.Eventually(func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return failures >= 2
+	}, time.Second, time.Millisecond)
+	cancel()
+	s.ErrorIs(<-done, context.Canceled)
+}
+
+// foreignSession runs f with a transaction which is not a *sql.Tx, like the sessions of other adapters
+type foreignSession struct{}
+
+func (foreignSession) WithTransaction(ctx context.Context, f func(ctx context.Context) error, opts ...TxOption) error {
+	return f(WithTx(ctx, struct{}{}))
+}
+
+func (foreignSession) Shutdown(ctx context.Context) error {
+	return nil
+}
+
+func (s *IdempotencyTestSuite) TestIdempotent_foreignSession() {
+	calls := 0
+	s.NotPanics(func() {
+		_, err := Idempotent(context.Background(), foreignSession{}, "k1", s.pay("p1", &calls))
+		s.ErrorContains(err, "database/sql transaction")
+	})
+	s.Zero(calls)
+}
+
+func (s *IdempotencyTestSuite) TestIdempotent_claimQueryPerDialect() {
+	mysql := newIdempotencyConfig([]IdempotencyOption{WithIdempotencyDialect(dialect.MySQL)})
+	s.Contains(mysql.claimQuery(), "ON DUPLICATE KEY UPDATE")
+	s.Len(mysql.claimArgs("k", "t", s.now), 10)
+
+	postgres := newIdempotencyConfig([]IdempotencyOption{WithIdempotencyDialect(dialect.Postgres)})
+	s.Contains(postgres.dialect.Rebind(postgres.claimQuery()), "WHERE idempotency_keys.expires_at <= $6")
+	s.Len(postgres.claimArgs("k", "t", s.now), 6)
+}
+
+func TestIdempotencyTestSuite(t *testing.T) {
+	suite.Run(t, new(IdempotencyTestSuite))
+}
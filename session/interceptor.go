@@ -0,0 +1,248 @@
+package session
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+// StatementOp is the kind of operation a Statement runs
+type StatementOp int
+
+const (
+	// OpExec runs a statement returning no rows
+	OpExec StatementOp = iota
+	// OpQuery runs a statement returning rows, read after next returned
+	OpQuery
+	// OpQueryRow runs a statement returning at most one row
+	OpQueryRow
+	// OpPrepare prepares a statement, whose later runs are not intercepted
+	OpPrepare
+)
+
+func (op StatementOp) String() string {
+	switch op {
+	case OpExec:
+		return "exec"
+	case OpQuery:
+		return "query"
+	case OpQueryRow:
+		return "query_row"
+	case OpPrepare:
+		return "prepare"
+	}
+	return "unknown"
+}
+
+// Statement is a statement run through an executor of a DBWrapper, as seen by interceptors
+type Statement struct {
+	Op    StatementOp
+	Query string
+	Args  []any
+	// TxID is the ID of the session transaction the statement runs in, empty outside of one
+	TxID string
+	// Start is when the statement was issued, and Duration how long it ran, known once next returned
+	Start    time.Time
+	Duration time.Duration
+	// Result is the result of an OpExec statement, known once next returned without error
+	Result sql.Result
+
+	redactors []func(args []any) []any
+	// budgeted is set once the statement was counted for the budgets of its transaction
+	budgeted bool
+}
+
+// LogArgs returns the arguments of the statement with the redactions of RedactArgs applied, for logging.
+// The statement still runs with Args.
+func (s *Statement) LogArgs() []any {
+	args := append([]any(nil), s.Args...)
+	for _, redact := range s.redactors {
+		args = redact(args)
+	}
+	return args
+}
+
+// StatementHandler runs a statement
+type StatementHandler func(ctx context.Context, stmt *Statement) error
+
+// Interceptor runs around every statement of the executors it is added to.
+// It calls next to run the statement, possibly with another context or statement, or returns an error instead.
+type Interceptor func(ctx context.Context, stmt *Statement, next StatementHandler) error
+
+// InterceptStatement runs stmt through interceptors, the first one outermost, and then through run.
+// RunStatement builds the statement of an operation and runs it through InterceptStatement.
+func InterceptStatement(ctx context.Context, stmt *Statement, interceptors []Interceptor, run StatementHandler) error {
+	if len(interceptors) == 0 {
+		return run(ctx, stmt)
+	}
+	return interceptors[0](ctx, stmt, func(ctx context.Context, stmt *Statement) error {
+		return InterceptStatement(ctx, stmt, interceptors[1:], run)
+	})
+}
+
+// SlowQueryLog calls log for every statement running longer than threshold
+func SlowQueryLog(threshold time.Duration, log func(ctx context.Context, stmt *Statement)) Interceptor {
+	return func(ctx context.Context, stmt *Statement, next StatementHandler) error {
+		err := next(ctx, stmt)
+		if stmt.Duration >= threshold {
+			log(ctx, stmt)
+		}
+		return err
+	}
+}
+
+// StatementTimeout runs every statement with a deadline d away, unless its context has an earlier one.
+// The rows of a query are read under the same deadline.
+func StatementTimeout(d time.Duration) Interceptor {
+	return func(ctx context.Context, stmt *Statement, next StatementHandler) error {
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) <= d {
+			return next(ctx, stmt)
+		}
+		_, err := runWithTimeout(ctx, stmt, d, context.DeadlineExceeded, next)
+		return err
+	}
+}
+
+// runWithTimeout runs next with a deadline d away, and reports whether it passed with cause
+func runWithTimeout(ctx context.Context, stmt *Statement, d time.Duration, cause error, next StatementHandler) (bool, error) {
+	ctx, cancel := context.WithTimeoutCause(ctx, d, cause)
+	err := next(ctx, stmt)
+	timedOut := errors.Is(context.Cause(ctx), cause) && ctx.Err() == context.DeadlineExceeded
+	// cancelling would close the rows of a query, which are released by the deadline instead
+	if err != nil || stmt.Op == OpExec || stmt.Op == OpPrepare {
+		cancel()
+	} else {
+		context.AfterFunc(ctx, cancel)
+	}
+	return timedOut, err
+}
+
+// RedactArgs makes Statement.LogArgs return the arguments passed through redact,
+// for the interceptors running after this one
+func RedactArgs(redact func(args []any) []any) Interceptor {
+	return func(ctx context.Context, stmt *Statement, next StatementHandler) error {
+		stmt.redactors = append(stmt.redactors, redact)
+		return next(ctx, stmt)
+	}
+}
+
+// CountStatements adds every statement to count
+func CountStatements(count *atomic.Int64) Interceptor {
+	return func(ctx context.Context, stmt *Statement, next StatementHandler) error {
+		count.Add(1)
+		return next(ctx, stmt)
+	}
+}
+
+// RunStatement runs a statement of op through interceptors, the first one outermost, and then through exec,
+// and returns it with the results exec stored in it and its Duration set.
+// Adapters use it to support interceptors on the operations of their own executors.
+func RunStatement(ctx context.Context, interceptors []Interceptor, op StatementOp, query string, args []any, exec StatementHandler) (*Statement, error) {
+	stmt := &Statement{Op: op, Query: query, Args: args, TxID: TxID(ctx), Start: time.Now()}
+	err := InterceptStatement(ctx, stmt, interceptors, func(ctx context.Context, stmt *Statement) error {
+		err := exec(ctx, stmt)
+		stmt.Duration = time.Since(stmt.Start)
+		return err
+	})
+	return stmt, err
+}
+
+// StatementRunner runs statements with a context, like *sql.DB, *sql.Tx and the connection pools of gorm
+type StatementRunner interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	PrepareContext(ctx context.Context, query string) (*sql.Stmt, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// InterceptedRunner runs the statements of Next through Interceptors, the first one outermost.
+// Adapters embed it in their executors to support interceptors.
+type InterceptedRunner struct {
+	Next         StatementRunner
+	Interceptors []Interceptor
+}
+
+func (r *InterceptedRunner) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	stmt, err := RunStatement(ctx, r.Interceptors, OpExec, query, args, func(ctx context.Context, stmt *Statement) error {
+		var err error
+		stmt.Result, err = r.Next.ExecContext(ctx, stmt.Query, stmt.Args...)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return stmt.Result, nil
+}
+
+func (r *InterceptedRunner) PrepareContext(ctx context.Context, query string) (*sql.Stmt, error) {
+	var prepared *sql.Stmt
+	_, err := RunStatement(ctx, r.Interceptors, OpPrepare, query, nil, func(ctx context.Context, stmt *Statement) error {
+		var err error
+		prepared, err = r.Next.PrepareContext(ctx, stmt.Query)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return prepared, nil
+}
+
+func (r *InterceptedRunner) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	var rows *sql.Rows
+	_, err := RunStatement(ctx, r.Interceptors, OpQuery, query, args, func(ctx context.Context, stmt *Statement) error {
+		var err error
+		rows, err = r.Next.QueryContext(ctx, stmt.Query, stmt.Args...)
+		return err
+	})
+	if err != nil {
+		if rows != nil {
+			rows.Close()
+		}
+		return nil, err
+	}
+	return rows, nil
+}
+
+func (r *InterceptedRunner) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	var row *sql.Row
+	_, err := RunStatement(ctx, r.Interceptors, OpQueryRow, query, args, func(ctx context.Context, stmt *Statement) error {
+		row = r.Next.QueryRowContext(ctx, stmt.Query, stmt.Args...)
+		return row.Err()
+	})
+	if row == nil || (err != nil && row.Err() == nil) {
+		return ErrorRow(ctx, err)
+	}
+	return row
+}
+
+// interceptedExecutor runs the statements of an executor through interceptors
+type interceptedExecutor struct {
+	InterceptedRunner
+}
+
+func newInterceptedExecutor(next Executor, interceptors []Interceptor) *interceptedExecutor {
+	return &interceptedExecutor{InterceptedRunner{Next: next, Interceptors: interceptors}}
+}
+
+func (e *interceptedExecutor) Exec(query string, args ...any) (sql.Result, error) {
+	return e.ExecContext(context.Background(), query, args...)
+}
+
+func (e *interceptedExecutor) Prepare(query string) (*sql.Stmt, error) {
+	return e.PrepareContext(context.Background(), query)
+}
+
+func (e *interceptedExecutor) Query(query string, args ...any) (*sql.Rows, error) {
+	return e.QueryContext(context.Background(), query, args...)
+}
+
+func (e *interceptedExecutor) QueryRow(query string, args ...any) *sql.Row {
+	return e.QueryRowContext(context.Background(), query, args...)
+}
+
+// ErrorRow returns a row whose Scan returns err, for adapters whose interceptors refuse a QueryRow
+func ErrorRow(ctx context.Context, err error) *sql.Row {
+	return bufferDB.QueryRowContext(ctx, "", &bufferedRows{err: err})
+}
@@ -0,0 +1,144 @@
+package session
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInterceptors_statement(t *testing.T) {
+	db := openSerialTestDB(t)
+	var order []string
+	var seen []Statement
+	record := func(name string) Interceptor {
+		return func(ctx context.Context, stmt *Statement, next StatementHandler) error {
+			order = append(order, name)
+			err := next(ctx, stmt)
+			if name == "outer" {
+				seen = append(seen, *stmt)
+			}
+			return err
+		}
+	}
+	wrapper := NewDB(db, WithInterceptors(record("outer"), record("inner")))
+
+	var txID string
+	err := NewSession(db).WithTransaction(context.Background(), func(ctx context.Context) error {
+		txID = TxID(ctx)
+		_, err := wrapper.GetDB(ctx).ExecContext(ctx, "INSERT INTO models (id, n) VALUES (?, ?)", "a", 1)
+		return err
+	})
+	require.NoError(t, err)
+
+	var n int
+	require.NoError(t, wrapper.GetDB(context.Background()).QueryRow("SELECT n FROM models WHERE id = ?", "a").Scan(&n))
+	assert.Equal(t, 1, n)
+
+	assert.Equal(t, []string{"outer", "inner", "outer", "inner"}, order)
+	require.Len(t, seen, 2)
+	assert.Equal(t, OpExec, seen[0].Op)
+	assert.Equal(t, "INSERT INTO models (id, n) VALUES (?, ?)", seen[0].Query)
+	assert.Equal(t, []any{"a", 1}, seen[0].Args)
+	assert.Equal(t, txID, seen[0].TxID)
+	assert.Positive(t, seen[0].Duration)
+	require.NotNil(t, seen[0].Result)
+	affected, err := seen[0].Result.RowsAffected()
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), affected)
+
+	assert.Equal(t, OpQueryRow, seen[1].Op)
+	assert.Empty(t, seen[1].TxID)
+}
+
+func TestInterceptors_reject(t *testing.T) {
+	db := openSerialTestDB(t)
+	errRejected := errors.New("rejected")
+	wrapper := NewDB(db, WithInterceptors(func(ctx context.Context, stmt *Statement, next StatementHandler) error {
+		return errRejected
+	}))
+	executor := wrapper.GetDB(context.Background())
+
+	_, err := executor.Exec("INSERT INTO models (id) VALUES (?)", "a")
+	assert.ErrorIs(t, err, errRejected)
+	_, err = executor.Query("SELECT id FROM models")
+	assert.ErrorIs(t, err, errRejected)
+	_, err = executor.Prepare("SELECT id FROM models")
+	assert.ErrorIs(t, err, errRejected)
+	var id string
+	assert.ErrorIs(t, executor.QueryRow("SELECT id FROM models").Scan(&id), errRejected)
+
+	assert.Equal(t, 0, countModels(t, db))
+}
+
+func TestInterceptedRunner(t *testing.T) {
+	db := openSerialTestDB(t)
+	var ops []StatementOp
+	runner := &InterceptedRunner{Next: db, Interceptors: []Interceptor{func(ctx context.Context, stmt *Statement, next StatementHandler) error {
+		ops = append(ops, stmt.Op)
+		if stmt.Op == OpQueryRow {
+			// skips the statement, which finds no row
+			return nil
+		}
+		return next(ctx, stmt)
+	}}}
+	ctx := context.Background()
+
+	_, err := runner.ExecContext(ctx, "INSERT INTO models (id) VALUES (?)", "a")
+	require.NoError(t, err)
+	rows, err := runner.QueryContext(ctx, "SELECT id FROM models")
+	require.NoError(t, err)
+	rows.Close()
+	stmt, err := runner.PrepareContext(ctx, "SELECT id FROM models")
+	require.NoError(t, err)
+	stmt.Close()
+	var id string
+	assert.ErrorIs(t, runner.QueryRowContext(ctx, "SELECT id FROM models").Scan(&id), sql.ErrNoRows)
+
+	assert.Equal(t, []StatementOp{OpExec, OpQuery, OpPrepare, OpQueryRow}, ops)
+	assert.Equal(t, 1, countModels(t, db))
+}
+
+func TestInterceptors_builtin(t *testing.T) {
+	db := openSerialTestDB(t)
+	var count atomic.Int64
+	var slow [][]any
+	var deadline bool
+	wrapper := NewDB(db, WithInterceptors(
+		CountStatements(&count),
+		RedactArgs(func(args []any) []any {
+			args[0] = "***"
+			return args
+		}),
+		SlowQueryLog(0, func(ctx context.Context, stmt *Statement) {
+			slow = append(slow, stmt.LogArgs())
+		}),
+		StatementTimeout(time.Second),
+		func(ctx context.Context, stmt *Statement, next StatementHandler) error {
+			_, deadline = ctx.Deadline()
+			return next(ctx, stmt)
+		},
+	))
+	executor := wrapper.GetDB(context.Background())
+
+	_, err := executor.Exec("INSERT INTO models (id, n) VALUES (?, ?)", "secret", 1)
+	require.NoError(t, err)
+	assert.True(t, deadline)
+
+	// the rows stay readable after the statement returned
+	rows, err := executor.Query("SELECT id FROM models WHERE n = ?", 1)
+	require.NoError(t, err)
+	defer rows.Close()
+	require.True(t, rows.Next())
+	var id string
+	require.NoError(t, rows.Scan(&id))
+	assert.Equal(t, "secret", id)
+
+	assert.Equal(t, int64(2), count.Load())
+	assert.Equal(t, [][]any{{"***", 1}, {"***"}}, slow)
+}
@@ -0,0 +1,154 @@
+// Package driverwrap holds what the database/sql drivers wrapping another driver share:
+// opening the wrapped connections, the pass-through methods and the conversion of arguments
+package driverwrap
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+)
+
+// ErrNamedArgs is returned when named arguments reach a driver without the context interfaces
+var ErrNamedArgs = errors.New("driver does not support named arguments")
+
+// Connector opens connections of Inner to DSN and wraps them with Wrap
+type Connector struct {
+	Inner driver.Driver
+	DSN   string
+	Wrap  func(conn driver.Conn) driver.Conn
+	// Outer is returned by Driver, Inner when nil
+	Outer driver.Driver
+}
+
+func (c *Connector) Connect(ctx context.Context) (driver.Conn, error) {
+	var conn driver.Conn
+	var err error
+	if dc, ok := c.Inner.(driver.DriverContext); ok {
+		var inner driver.Connector
+		if inner, err = dc.OpenConnector(c.DSN); err == nil {
+			conn, err = inner.Connect(ctx)
+		}
+	} else {
+		conn, err = c.Inner.Open(c.DSN)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return c.Wrap(conn), nil
+}
+
+func (c *Connector) Driver() driver.Driver {
+	if c.Outer != nil {
+		return c.Outer
+	}
+	return c.Inner
+}
+
+// Conn is embedded by the wrapping connections.
+// It passes Ping, ResetSession, IsValid and CheckNamedValue through to the wrapped connection.
+type Conn struct {
+	driver.Conn
+}
+
+// BeginTx begins a transaction on the wrapped connection
+func (c Conn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if cb, ok := c.Conn.(driver.ConnBeginTx); ok {
+		return cb.BeginTx(ctx, opts)
+	}
+	return c.Conn.Begin()
+}
+
+// PrepareContext prepares query on the wrapped connection
+func (c Conn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	if cp, ok := c.Conn.(driver.ConnPrepareContext); ok {
+		return cp.PrepareContext(ctx, query)
+	}
+	return c.Conn.Prepare(query)
+}
+
+func (c Conn) Ping(ctx context.Context) error {
+	if p, ok := c.Conn.(driver.Pinger); ok {
+		return p.Ping(ctx)
+	}
+	return nil
+}
+
+func (c Conn) ResetSession(ctx context.Context) error {
+	if r, ok := c.Conn.(driver.SessionResetter); ok {
+		return r.ResetSession(ctx)
+	}
+	return nil
+}
+
+func (c Conn) IsValid() bool {
+	if v, ok := c.Conn.(driver.Validator); ok {
+		return v.IsValid()
+	}
+	return true
+}
+
+func (c Conn) CheckNamedValue(nv *driver.NamedValue) error {
+	if nc, ok := c.Conn.(driver.NamedValueChecker); ok {
+		return nc.CheckNamedValue(nv)
+	}
+	return driver.ErrSkip
+}
+
+// Stmt is embedded by the wrapping statements.
+// It passes CheckNamedValue through to the wrapped statement.
+type Stmt struct {
+	driver.Stmt
+}
+
+// ExecContext runs the wrapped statement
+func (s Stmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	if sc, ok := s.Stmt.(driver.StmtExecContext); ok {
+		return sc.ExecContext(ctx, args)
+	}
+	values, err := NamedToValues(args)
+	if err != nil {
+		return nil, err
+	}
+	return s.Stmt.Exec(values)
+}
+
+// QueryContext runs the wrapped statement
+func (s Stmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	if sc, ok := s.Stmt.(driver.StmtQueryContext); ok {
+		return sc.QueryContext(ctx, args)
+	}
+	values, err := NamedToValues(args)
+	if err != nil {
+		return nil, err
+	}
+	return s.Stmt.Query(values)
+}
+
+func (s Stmt) CheckNamedValue(nv *driver.NamedValue) error {
+	if nc, ok := s.Stmt.(driver.NamedValueChecker); ok {
+		return nc.CheckNamedValue(nv)
+	}
+	return driver.ErrSkip
+}
+
+// NamedToValues converts the arguments for the methods of drivers without the context interfaces,
+// which take no named arguments
+func NamedToValues(args []driver.NamedValue) ([]driver.Value, error) {
+	values := make([]driver.Value, len(args))
+	for i, arg := range args {
+		if arg.Name != "" {
+			return nil, ErrNamedArgs
+		}
+		values[i] = arg.Value
+	}
+	return values, nil
+}
+
+// ValuesToNamed converts the arguments of the methods without context for their context variants
+func ValuesToNamed(args []driver.Value) []driver.NamedValue {
+	named := make([]driver.NamedValue, len(args))
+	for i, v := range args {
+		named[i] = driver.NamedValue{Ordinal: i + 1, Value: v}
+	}
+	return named
+}
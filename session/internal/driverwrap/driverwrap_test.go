@@ -0,0 +1,33 @@
+package driverwrap
+
+import (
+	"context"
+	"database/sql/driver"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// plainConn implements none of the optional interfaces
+type plainConn struct {
+	driver.Conn
+}
+
+func TestConn_passThroughDefaults(t *testing.T) {
+	c := Conn{Conn: plainConn{}}
+
+	assert.NoError(t, c.Ping(context.Background()))
+	assert.NoError(t, c.ResetSession(context.Background()))
+	assert.True(t, c.IsValid())
+	assert.ErrorIs(t, c.CheckNamedValue(&driver.NamedValue{}), driver.ErrSkip)
+}
+
+func TestNamedToValues(t *testing.T) {
+	values, err := NamedToValues(ValuesToNamed([]driver.Value{"a", int64(1)}))
+	require.NoError(t, err)
+	assert.Equal(t, []driver.Value{"a", int64(1)}, values)
+
+	_, err = NamedToValues([]driver.NamedValue{{Name: "id", Ordinal: 1, Value: "a"}})
+	assert.ErrorIs(t, err, ErrNamedArgs)
+}
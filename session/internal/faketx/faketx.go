@@ -0,0 +1,25 @@
+// Package faketx gives the fakes of package session, such as sessionfake,
+// transactions of package session without a SQL transaction behind them.
+package faketx
+
+import "context"
+
+// Tx is a session transaction, or a savepoint of one, without a SQL transaction behind it.
+// Its context carries a real session.TxState, so the functions of package session reading it
+// behave as in a transaction begun by a session.
+type Tx interface {
+	// Savepoint tells whether the transaction is a savepoint of another one
+	Savepoint() bool
+	// Prepare checks that the transaction may commit. It does nothing for a savepoint.
+	Prepare(ctx context.Context) error
+	// Commit ends the transaction as committed. A savepoint is released instead.
+	Commit(ctx context.Context) error
+	// Rollback ends the transaction or savepoint as rolled back
+	Rollback(ctx context.Context) error
+}
+
+// Begin returns a context carrying a transaction begun with cfg, a session.TxConfig,
+// or a savepoint of the transaction carried by ctx when cfg asks for session.PropagationNested.
+// It never joins the transaction carried by ctx.
+// It is set by package session, which the fakes import.
+var Begin func(ctx context.Context, cfg any) (context.Context, Tx)
@@ -0,0 +1,261 @@
+package session
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrTooManyTransactions is returned by WithTransaction when a transaction waited for a permit of the Limiter
+// longer than its queue timeout
+var ErrTooManyTransactions = errors.New("too many concurrent transactions")
+
+// LimiterWait describes how long an outermost transaction waited for its permits
+type LimiterWait struct {
+	ReadOnly bool
+	Weight   int
+	Wait     time.Duration
+	// Admitted is false when the transaction gave up waiting
+	Admitted bool
+}
+
+// LimiterStats counts the transactions admitted by a Limiter
+type LimiterStats struct {
+	// InUse is the weight of the transactions holding permits
+	InUse int64
+	// Waiting is the number of transactions waiting for permits
+	Waiting  int
+	Admitted int64
+	Rejected int64
+	// WaitTotal and WaitMax are the total and the longest wait of the admitted transactions
+	WaitTotal time.Duration
+	WaitMax   time.Duration
+}
+
+// LimiterOption configures a Limiter
+type LimiterOption func(*Limiter)
+
+// WithReadOnlyLimit bounds the weight of the read-only transactions in progress, within the limit of the limiter
+func WithReadOnlyLimit(n int) LimiterOption {
+	return func(l *Limiter) {
+		l.readOnly = newSemaphore(int64(n))
+	}
+}
+
+// WithReadWriteLimit bounds the weight of the read-write transactions in progress, within the limit of the limiter
+func WithReadWriteLimit(n int) LimiterOption {
+	return func(l *Limiter) {
+		l.readWrite = newSemaphore(int64(n))
+	}
+}
+
+// WithQueueTimeout makes transactions waiting for permits longer than d fail with ErrTooManyTransactions.
+// By default they wait as long as their context allows.
+func WithQueueTimeout(d time.Duration) LimiterOption {
+	return func(l *Limiter) {
+		l.queueTimeout = d
+	}
+}
+
+// WithWaitObserver calls observe after every wait for permits, to export it as a metric
+func WithWaitObserver(observe func(LimiterWait)) LimiterOption {
+	return func(l *Limiter) {
+		l.observe = observe
+	}
+}
+
+// Limiter admits the outermost transactions of the sessions it is added to with WithLimiter,
+// up to a total weight in progress. A transaction weighs 1 unless it sets WithWeight.
+// Calls joining a transaction in progress, in a savepoint or not, and transactions started inside one
+// never wait for permits, so a transaction holding permits cannot wait for itself.
+type Limiter struct {
+	total     *semaphore
+	readOnly  *semaphore
+	readWrite *semaphore
+
+	queueTimeout time.Duration
+	observe      func(LimiterWait)
+
+	mu    sync.Mutex
+	stats LimiterStats
+}
+
+// NewLimiter returns a limiter admitting transactions up to a total weight of max
+func NewLimiter(max int, opts ...LimiterOption) *Limiter {
+	l := &Limiter{total: newSemaphore(int64(max))}
+	for _, opt := range opts {
+		opt(l)
+	}
+	return l
+}
+
+// Stats returns the counters of the limiter
+func (l *Limiter) Stats() LimiterStats {
+	l.mu.Lock()
+	stats := l.stats
+	l.mu.Unlock()
+	stats.InUse = l.total.inUse()
+	stats.Waiting = l.total.waiting()
+	for _, class := range []*semaphore{l.readOnly, l.readWrite} {
+		if class != nil {
+			stats.Waiting += class.waiting()
+		}
+	}
+	return stats
+}
+
+// acquire waits for the permits of a transaction and returns the function releasing them
+func (l *Limiter) acquire(ctx context.Context, readOnly bool, weight int) (func(), error) {
+	weight = max(weight, 1)
+	class := l.readWrite
+	if readOnly {
+		class = l.readOnly
+	}
+
+	waitCtx := ctx
+	if l.queueTimeout > 0 {
+		var cancel context.CancelFunc
+		waitCtx, cancel = context.WithTimeoutCause(ctx, l.queueTimeout, ErrTooManyTransactions)
+		defer cancel()
+	}
+
+	start := time.Now()
+	n := int64(weight)
+	err := class.acquire(waitCtx, n)
+	if err == nil {
+		if err = l.total.acquire(waitCtx, n); err != nil {
+			class.release(n)
+		}
+	}
+	wait := time.Since(start)
+	l.record(LimiterWait{ReadOnly: readOnly, Weight: weight, Wait: wait, Admitted: err == nil})
+
+	if err != nil {
+		if errors.Is(context.Cause(waitCtx), ErrTooManyTransactions) {
+			return nil, fmt.Errorf("%w: waited %s", ErrTooManyTransactions, wait)
+		}
+		return nil, fmt.Errorf("failed to wait for transaction permits: %w", err)
+	}
+	return func() {
+		l.total.release(n)
+		class.release(n)
+	}, nil
+}
+
+func (l *Limiter) record(w LimiterWait) {
+	l.mu.Lock()
+	if w.Admitted {
+		l.stats.Admitted++
+		l.stats.WaitTotal += w.Wait
+		l.stats.WaitMax = max(l.stats.WaitMax, w.Wait)
+	} else {
+		l.stats.Rejected++
+	}
+	l.mu.Unlock()
+
+	if l.observe != nil {
+		l.observe(w)
+	}
+}
+
+// semaphore is a weighted semaphore admitting its waiters in order.
+// A nil semaphore admits everything.
+type semaphore struct {
+	size    int64
+	mu      sync.Mutex
+	cur     int64
+	waiters list.List
+}
+
+type semaphoreWaiter struct {
+	n     int64
+	ready chan struct{}
+}
+
+func newSemaphore(size int64) *semaphore {
+	return &semaphore{size: size}
+}
+
+func (s *semaphore) acquire(ctx context.Context, n int64) error {
+	if s == nil {
+		return nil
+	}
+	// a weight above the size would wait forever
+	n = min(n, s.size)
+
+	s.mu.Lock()
+	if s.size-s.cur >= n && s.waiters.Len() == 0 {
+		s.cur += n
+		s.mu.Unlock()
+		return nil
+	}
+	w := &semaphoreWaiter{n: n, ready: make(chan struct{})}
+	elem := s.waiters.PushBack(w)
+	s.mu.Unlock()
+
+	select {
+	case <-w.ready:
+		return nil
+	case <-ctx.Done():
+		s.mu.Lock()
+		select {
+		case <-w.ready:
+			// admitted while giving up, so the permits are given back
+			s.cur -= n
+		default:
+			isFront := s.waiters.Front() == elem
+			s.waiters.Remove(elem)
+			if !isFront {
+				s.mu.Unlock()
+				return ctx.Err()
+			}
+		}
+		// the waiters behind may fit now
+		s.notify()
+		s.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+func (s *semaphore) release(n int64) {
+	if s == nil {
+		return
+	}
+	n = min(n, s.size)
+	s.mu.Lock()
+	s.cur -= n
+	s.notify()
+	s.mu.Unlock()
+}
+
+// notify admits the waiters in order while they fit
+func (s *semaphore) notify() {
+	for {
+		front := s.waiters.Front()
+		if front == nil {
+			return
+		}
+		w := front.Value.(*semaphoreWaiter)
+		if s.size-s.cur < w.n {
+			return
+		}
+		s.cur += w.n
+		s.waiters.Remove(front)
+		close(w.ready)
+	}
+}
+
+func (s *semaphore) inUse() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.cur
+}
+
+func (s *semaphore) waiting() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.waiters.Len()
+}
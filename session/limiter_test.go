@@ -0,0 +1,163 @@
+package session
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func openLimiterTestDB(t *testing.T) *sql.DB {
+	db, err := sql.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// hold runs a transaction in the background until release is closed, and returns once it began
+func hold(t *testing.T, sess Session, release chan struct{}, opts ...TxOption) chan error {
+	started, done := make(chan struct{}), make(chan error, 1)
+	go func() {
+		done <- sess.WithTransaction(context.Background(), func(ctx context.Context) error {
+			close(started)
+			<-release
+			return nil
+		}, opts...)
+	}()
+	select {
+	case <-started:
+	case <-time.After(time.Second):
+		t.Fatal("transaction not started")
+	}
+	return done
+}
+
+func TestLimiter_concurrency(t *testing.T) {
+	limiter := NewLimiter(2)
+	sess := NewSession(openLimiterTestDB(t), WithLimiter(limiter))
+
+	var active, peak atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := sess.WithTransaction(context.Background(), func(ctx context.Context) error {
+				n := active.Add(1)
+				defer active.Add(-1)
+				for {
+					p := peak.Load()
+					if n <= p || peak.CompareAndSwap(p, n) {
+						break
+					}
+				}
+				time.Sleep(10 * time.Millisecond)
+				return nil
+			})
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+
+	assert.LessOrEqual(t, peak.Load(), int32(2))
+	stats := limiter.Stats()
+	assert.Equal(t, int64(6), stats.Admitted)
+	assert.Zero(t, stats.InUse)
+	assert.Zero(t, stats.Waiting)
+	assert.Positive(t, stats.WaitMax)
+}
+
+func TestLimiter_queueTimeout(t *testing.T) {
+	var waits []LimiterWait
+	limiter := NewLimiter(1, WithQueueTimeout(10*time.Millisecond), WithWaitObserver(func(w LimiterWait) {
+		waits = append(waits, w)
+	}))
+	sess := NewSession(openLimiterTestDB(t), WithLimiter(limiter))
+
+	release := make(chan struct{})
+	done := hold(t, sess, release)
+
+	err := sess.WithTransaction(context.Background(), func(ctx context.Context) error {
+		t.Error("function run without a permit")
+		return nil
+	})
+	assert.ErrorIs(t, err, ErrTooManyTransactions)
+
+	close(release)
+	require.NoError(t, <-done)
+	assert.Equal(t, int64(1), limiter.Stats().Rejected)
+	require.Len(t, waits, 2)
+	assert.True(t, waits[0].Admitted)
+	assert.False(t, waits[1].Admitted)
+	assert.GreaterOrEqual(t, waits[1].Wait, 10*time.Millisecond)
+}
+
+func TestLimiter_contextCancelled(t *testing.T) {
+	sess := NewSession(openLimiterTestDB(t), WithLimiter(NewLimiter(1)))
+
+	release := make(chan struct{})
+	done := hold(t, sess, release)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	err := sess.WithTransaction(ctx, func(ctx context.Context) error { return nil })
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.NotErrorIs(t, err, ErrTooManyTransactions)
+
+	close(release)
+	require.NoError(t, <-done)
+}
+
+func TestLimiter_nestedCallsTakeNoPermit(t *testing.T) {
+	sess := NewSession(openLimiterTestDB(t), WithLimiter(NewLimiter(1, WithQueueTimeout(10*time.Millisecond))))
+
+	err := sess.WithTransaction(context.Background(), func(ctx context.Context) error {
+		for _, p := range []Propagation{PropagationRequired, PropagationNested, PropagationRequiresNew} {
+			if err := sess.WithTransaction(ctx, func(ctx context.Context) error { return nil }, WithPropagation(p)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+}
+
+func TestLimiter_readOnlyLimit(t *testing.T) {
+	limiter := NewLimiter(10, WithReadOnlyLimit(1), WithReadWriteLimit(1), WithQueueTimeout(10*time.Millisecond))
+	sess := NewSession(openLimiterTestDB(t), WithLimiter(limiter))
+
+	release := make(chan struct{})
+	done := hold(t, sess, release, WithReadOnly())
+
+	err := sess.WithTransaction(context.Background(), func(ctx context.Context) error { return nil }, WithReadOnly())
+	assert.ErrorIs(t, err, ErrTooManyTransactions)
+	err = sess.WithTransaction(context.Background(), func(ctx context.Context) error { return nil })
+	assert.NoError(t, err)
+
+	close(release)
+	require.NoError(t, <-done)
+}
+
+func TestLimiter_weight(t *testing.T) {
+	limiter := NewLimiter(2, WithQueueTimeout(10*time.Millisecond))
+	sess := NewSession(openLimiterTestDB(t), WithLimiter(limiter))
+
+	release := make(chan struct{})
+	done := hold(t, sess, release, WithWeight(2))
+	assert.Equal(t, int64(2), limiter.Stats().InUse)
+
+	err := sess.WithTransaction(context.Background(), func(ctx context.Context) error { return nil })
+	assert.ErrorIs(t, err, ErrTooManyTransactions)
+
+	close(release)
+	require.NoError(t, <-done)
+
+	// a weight above the limit takes all the permits instead of waiting forever
+	err = sess.WithTransaction(context.Background(), func(ctx context.Context) error { return nil }, WithWeight(5))
+	assert.NoError(t, err)
+}
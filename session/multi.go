@@ -0,0 +1,343 @@
+package session
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aeramu/sql-transaction/session/dialect"
+)
+
+// DefaultJournalTable is the table used by MultiSession when no journal table is configured
+const DefaultJournalTable = "session_journal"
+
+const (
+	journalCommitting = "committing"
+	journalCommitted  = "committed"
+	journalFailed     = "failed"
+	journalResolved   = "resolved"
+)
+
+// ErrPartialCommit is returned by MultiSession.WithTransaction when some databases committed
+// and a later one failed. The journal holds which ones committed.
+var ErrPartialCommit = errors.New("multi-database transaction partially committed")
+
+// ErrJournalNotUpdated is returned by MultiSession.WithTransaction when every database committed
+// but the journal entry could not be marked committed. The changes must not be applied again:
+// the entry stays committing, and Pending reports it once it is older than the given time.
+var ErrJournalNotUpdated = errors.New("multi-database transaction committed, journal not updated")
+
+type multiKey struct{}
+
+type multiState struct {
+	owner *MultiSession
+	id    string
+	txs   map[string]*sql.Tx
+}
+
+// JournalEntry is the durable record of a multi-database commit
+type JournalEntry struct {
+	ID           string
+	Status       string
+	Participants []string
+	Committed    []string
+	Failed       string
+	Error        string
+	CreatedAt    time.Time
+}
+
+// MultiOption configures a MultiSession
+type MultiOption func(*MultiSession)
+
+// WithJournalTable sets the name of the journal table.
+// The name is inserted into the statements as is and must be trusted.
+func WithJournalTable(table string) MultiOption {
+	return func(m *MultiSession) {
+		m.table = table
+	}
+}
+
+// WithJournalDialect sets the dialect of the journal database
+func WithJournalDialect(d dialect.Dialect) MultiOption {
+	return func(m *MultiSession) {
+		m.dialect = d
+	}
+}
+
+// MultiSession runs a single WithTransaction call over several databases.
+// The transactions are committed one by one in registration order, which is best effort:
+// when a later commit fails the earlier ones stay committed, and the journal records
+// which databases committed so the outcome can be reconciled with Recover.
+type MultiSession struct {
+	names   []string
+	dbs     map[string]*sql.DB
+	journal *sql.DB
+	table   string
+	dialect dialect.Dialect
+	now     func() time.Time
+}
+
+// NewMultiSession creates a MultiSession that keeps its journal in the given database.
+// The journal is written outside the participant transactions, so it should not be a participant
+// on drivers that allow a single writer, such as SQLite.
+func NewMultiSession(journal *sql.DB, opts ...MultiOption) *MultiSession {
+	m := &MultiSession{
+		dbs:     map[string]*sql.DB{},
+		journal: journal,
+		table:   DefaultJournalTable,
+		dialect: dialect.SQLite,
+		now:     time.Now,
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	return m
+}
+
+// Register adds a database under name. Databases are committed in registration order.
+// Register is not safe for concurrent use: register every database before calling DB or WithTransaction.
+func (m *MultiSession) Register(name string, db *sql.DB) {
+	if _, ok := m.dbs[name]; !ok {
+		m.names = append(m.names, name)
+	}
+	m.dbs[name] = db
+}
+
+// DB returns a wrapper resolving to the transaction of the named database.
+// It panics when no database was registered under name.
+func (m *MultiSession) DB(name string) DBWrapper[Executor] {
+	if _, ok := m.dbs[name]; !ok {
+		panic(fmt.Sprintf("session: no database registered as %q", name))
+	}
+	return NewMultiDBWrapper[Executor](m, name, NewDatabase(m.dbs[name]))
+}
+
+// NewMultiDBWrapper returns a wrapper for db resolving to the transaction of the named database
+// when ctx carries a MultiSession transaction
+func NewMultiDBWrapper[T any](m *MultiSession, name string, db Database[T]) DBWrapper[T] {
+	return &multiWrapper[T]{owner: m, name: name, db: db}
+}
+
+type multiWrapper[T any] struct {
+	owner *MultiSession
+	name  string
+	db    Database[T]
+}
+
+func (w *multiWrapper[T]) GetDB(ctx context.Context) T {
+	st, ok := ctx.Value(multiKey{}).(*multiState)
+	if !ok || st.owner != w.owner {
+		return w.db.GetDB(ctx)
+	}
+	tx, ok := st.txs[w.name]
+	if !ok {
+		return w.db.GetDB(ctx)
+	}
+	return w.db.ConvertTx(ctx, tx)
+}
+
+// CreateJournalSchema creates the journal table if it does not exist
+func (m *MultiSession) CreateJournalSchema(ctx context.Context) error {
+	query := `CREATE TABLE IF NOT EXISTS %s (
+		id VARCHAR(64) PRIMARY KEY,
+		status VARCHAR(16) NOT NULL,
+		participants TEXT NOT NULL,
+		committed TEXT NOT NULL,
+		failed TEXT NOT NULL,
+		error TEXT NOT NULL,
+		created_at BIGINT NOT NULL
+	)`
+	if _, err := m.journal.ExecContext(ctx, fmt.Sprintf(query, m.table)); err != nil {
+		return fmt.Errorf("failed to create journal schema: %w", err)
+	}
+	return nil
+}
+
+// WithTransaction runs f with a transaction open on every registered database.
+// If a MultiSession transaction is already in progress, it will be used instead.
+// If f returns an error or panics, every transaction is rolled back.
+// Otherwise they are committed in registration order. If a commit fails after an earlier one
+// succeeded, the remaining transactions are rolled back and ErrPartialCommit is returned.
+// If every database committed but the journal could not be updated, ErrJournalNotUpdated is returned.
+func (m *MultiSession) WithTransaction(ctx context.Context, f func(ctx context.Context) error) error {
+	if st, ok := ctx.Value(multiKey{}).(*multiState); ok && st.owner == m {
+		return f(ctx)
+	}
+
+	id, err := newRandomID()
+	if err != nil {
+		return err
+	}
+	st := &multiState{owner: m, id: id, txs: make(map[string]*sql.Tx, len(m.names))}
+	txs := make([]*sql.Tx, 0, len(m.names))
+	for _, name := range m.names {
+		tx, err := m.dbs[name].BeginTx(ctx, nil)
+		if err != nil {
+			_ = rollbackAll(txs)
+			return fmt.Errorf("failed to begin transaction on %s: %w", name, err)
+		}
+		st.txs[name] = tx
+		txs = append(txs, tx)
+	}
+	ctx = context.WithValue(ctx, multiKey{}, st)
+
+	defer func() {
+		if p := recover(); p != nil {
+			if rbErr := rollbackAll(txs); rbErr != nil {
+				fmt.Printf("rollback error during panic: %v\n", rbErr)
+			}
+			panic(p)
+		}
+	}()
+
+	if err := f(ctx); err != nil {
+		if rbErr := rollbackAll(txs); rbErr != nil {
+			return fmt.Errorf("rollback error: %w (original error: %v)", rbErr, err)
+		}
+		return fmt.Errorf("transaction failed: %w", err)
+	}
+
+	entry := JournalEntry{ID: id, Participants: m.names, CreatedAt: m.now()}
+	if err := m.insertJournal(ctx, entry); err != nil {
+		if rbErr := rollbackAll(txs); rbErr != nil {
+			return fmt.Errorf("rollback error: %w (original error: %v)", rbErr, err)
+		}
+		return err
+	}
+
+	for i := range m.names {
+		if err := txs[i].Commit(); err != nil {
+			return m.commitFailed(ctx, entry, i, txs, err)
+		}
+	}
+
+	entry.Status = journalCommitted
+	entry.Committed = m.names
+	if err := m.updateJournal(ctx, entry); err != nil {
+		return fmt.Errorf("%w: %w", ErrJournalNotUpdated, err)
+	}
+	return nil
+}
+
+func (m *MultiSession) commitFailed(ctx context.Context, entry JournalEntry, i int, txs []*sql.Tx, err error) error {
+	err = fmt.Errorf("commit error on %s: %w", m.names[i], err)
+	if rbErr := rollbackAll(txs[i+1:]); rbErr != nil {
+		err = fmt.Errorf("%w (rollback error: %v)", err, rbErr)
+	}
+
+	entry.Status = journalFailed
+	entry.Committed = m.names[:i]
+	entry.Failed = m.names[i]
+	entry.Error = err.Error()
+	if i == 0 {
+		// nothing was committed, so there is nothing to reconcile
+		entry.Status = journalResolved
+	}
+	if jErr := m.updateJournal(ctx, entry); jErr != nil {
+		err = fmt.Errorf("%w (journal error: %v)", err, jErr)
+	}
+
+	if i == 0 {
+		return err
+	}
+	return fmt.Errorf("%w: %w", ErrPartialCommit, err)
+}
+
+func rollbackAll(txs []*sql.Tx) error {
+	var errs []error
+	for _, tx := range txs {
+		if err := tx.Rollback(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (m *MultiSession) insertJournal(ctx context.Context, e JournalEntry) error {
+	query := fmt.Sprintf(`INSERT INTO %s (id, status, participants, committed, failed, error, created_at)
+		VALUES (?, ?, ?, '', '', '', ?)`, m.table)
+	_, err := m.journal.ExecContext(context.WithoutCancel(ctx), m.dialect.Rebind(query),
+		e.ID, journalCommitting, strings.Join(e.Participants, ","), e.CreatedAt.UnixMilli())
+	if err != nil {
+		return fmt.Errorf("failed to write journal: %w", err)
+	}
+	return nil
+}
+
+func (m *MultiSession) updateJournal(ctx context.Context, e JournalEntry) error {
+	query := fmt.Sprintf(`UPDATE %s SET status = ?, committed = ?, failed = ?, error = ? WHERE id = ?`, m.table)
+	_, err := m.journal.ExecContext(context.WithoutCancel(ctx), m.dialect.Rebind(query),
+		e.Status, strings.Join(e.Committed, ","), e.Failed, e.Error, e.ID)
+	if err != nil {
+		return fmt.Errorf("failed to update journal: %w", err)
+	}
+	return nil
+}
+
+// Pending returns the journal entries that need reconciliation: failed commits,
+// and commits still in progress that started before olderThan (e.g. the process crashed)
+func (m *MultiSession) Pending(ctx context.Context, olderThan time.Time) ([]JournalEntry, error) {
+	query := fmt.Sprintf(`SELECT id, status, participants, committed, failed, error, created_at FROM %s
+		WHERE status = ? OR (status = ? AND created_at < ?) ORDER BY created_at`, m.table)
+	rows, err := m.journal.QueryContext(ctx, m.dialect.Rebind(query), journalFailed, journalCommitting, olderThan.UnixMilli())
+	if err != nil {
+		return nil, fmt.Errorf("failed to read journal: %w", err)
+	}
+	defer rows.Close()
+
+	var entries []JournalEntry
+	for rows.Next() {
+		var e JournalEntry
+		var participants, committed string
+		var createdAt int64
+		if err := rows.Scan(&e.ID, &e.Status, &participants, &committed, &e.Failed, &e.Error, &createdAt); err != nil {
+			return nil, fmt.Errorf("failed to read journal: %w", err)
+		}
+		e.Participants = splitNames(participants)
+		e.Committed = splitNames(committed)
+		e.CreatedAt = time.UnixMilli(createdAt)
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// Resolve marks a journal entry as reconciled
+func (m *MultiSession) Resolve(ctx context.Context, id string) error {
+	query := fmt.Sprintf(`UPDATE %s SET status = ? WHERE id = ?`, m.table)
+	if _, err := m.journal.ExecContext(ctx, m.dialect.Rebind(query), journalResolved, id); err != nil {
+		return fmt.Errorf("failed to resolve journal entry %s: %w", id, err)
+	}
+	return nil
+}
+
+// Recover calls reconcile for every entry returned by Pending: the failed commits of any age,
+// and the commits still in progress that started before olderThan.
+// It resolves the entries it handled without error.
+func (m *MultiSession) Recover(ctx context.Context, olderThan time.Time, reconcile func(ctx context.Context, e JournalEntry) error) error {
+	entries, err := m.Pending(ctx, olderThan)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, e := range entries {
+		if err := reconcile(ctx, e); err != nil {
+			errs = append(errs, fmt.Errorf("failed to reconcile journal entry %s: %w", e.ID, err))
+			continue
+		}
+		if err := m.Resolve(ctx, e.ID); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func splitNames(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, ",")
+}
@@ -0,0 +1,234 @@
+package session
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/suite"
+)
+
+type MultiSessionTestSuite struct {
+	suite.Suite
+	orders   *sql.DB
+	billing  *sql.DB
+	journal  *sql.DB
+	multi    *MultiSession
+	ordersDB DBWrapper[Executor]
+	billDB   DBWrapper[Executor]
+}
+
+func (s *MultiSessionTestSuite) open(name string) *sql.DB {
+	path := filepath.Join(s.T().TempDir(), name+".db")
+	db, err := sql.Open("sqlite3", "file:"+path+"?_foreign_keys=1")
+	s.Require().NoError(err)
+	return db
+}
+
+func (s *MultiSessionTestSuite) SetupTest() {
+	s.orders = s.open("orders")
+	s.billing = s.open("billing")
+	s.journal = s.open("journal")
+
+	_, err := s.orders.Exec(`CREATE TABLE orders (id TEXT PRIMARY KEY)`)
+	s.Require().NoError(err)
+	_, err = s.billing.Exec(`CREATE TABLE accounts (id TEXT PRIMARY KEY)`)
+	s.Require().NoError(err)
+	_, err = s.billing.Exec(`CREATE TABLE invoices (
+		id TEXT PRIMARY KEY,
+		account_id TEXT REFERENCES accounts(id) DEFERRABLE INITIALLY DEFERRED
+	)`)
+	s.Require().NoError(err)
+
+	s.multi = NewMultiSession(s.journal)
+	s.multi.Register("orders", s.orders)
+	s.multi.Register("billing", s.billing)
+	s.Require().NoError(s.multi.CreateJournalSchema(context.Background()))
+
+	s.ordersDB = s.multi.DB("orders")
+	s.billDB = s.multi.DB("billing")
+}
+
+func (s *MultiSessionTestSuite) TearDownTest() {
+	s.orders.Close()
+	s.billing.Close()
+	s.journal.Close()
+}
+
+func (s *MultiSessionTestSuite) count(db *sql.DB, table string) int {
+	var count int
+	s.Require().NoError(db.QueryRow("SELECT COUNT(*) FROM " + table).Scan(&count))
+	return count
+}
+
+func (s *MultiSessionTestSuite) journalStatus() string {
+	var status string
+	s.Require().NoError(s.journal.QueryRow("SELECT status FROM session_journal").Scan(&status))
+	return status
+}
+
+func (s *MultiSessionTestSuite) TestGetDB_perDatabaseTransaction() {
+	err := s.multi.WithTransaction(context.Background(), func(ctx context.Context) error {
+		ordersTx, ok := s.ordersDB.GetDB(ctx).(*sql.Tx)
+		s.True(ok)
+		billingTx, ok := s.billDB.GetDB(ctx).(*sql.Tx)
+		s.True(ok)
+		s.NotEqual(ordersTx, billingTx)
+		return nil
+	})
+	s.NoError(err)
+
+	s.Equal(s.orders, s.ordersDB.GetDB(context.Background()))
+}
+
+func (s *MultiSessionTestSuite) TestWithTransaction_committedOnAll() {
+	err := s.multi.WithTransaction(context.Background(), func(ctx context.Context) error {
+		_, err := s.ordersDB.GetDB(ctx).Exec("INSERT INTO orders (id) VALUES (?)", "o1")
+		s.NoError(err)
+		_, err = s.billDB.GetDB(ctx).Exec("INSERT INTO accounts (id) VALUES (?)", "a1")
+		return err
+	})
+
+	s.NoError(err)
+	s.Equal(1, s.count(s.orders, "orders"))
+	s.Equal(1, s.count(s.billing, "accounts"))
+	s.Equal(journalCommitted, s.journalStatus())
+}
+
+func (s *MultiSessionTestSuite) TestWithTransaction_rolledBackOnAll() {
+	err := s.multi.WithTransaction(context.Background(), func(ctx context.Context) error {
+		_, err := s.ordersDB.GetDB(ctx).Exec("INSERT INTO orders (id) VALUES (?)", "o1")
+		s.NoError(err)
+		_, err = s.billDB.GetDB(ctx).Exec("INSERT INTO accounts (id) VALUES (?)", "a1")
+		s.NoError(err)
+		return errors.New("business error")
+	})
+
+	s.Error(err)
+	s.Equal(0, s.count(s.orders, "orders"))
+	s.Equal(0, s.count(s.billing, "accounts"))
+	s.Equal(0, s.count(s.journal, "session_journal"))
+}
+
+func (s *MultiSessionTestSuite) TestWithTransaction_nestedJoins() {
+	err := s.multi.WithTransaction(context.Background(), func(ctx context.Context) error {
+		outer := s.ordersDB.GetDB(ctx)
+		return s.multi.WithTransaction(ctx, func(ctx context.Context) error {
+			s.Equal(outer, s.ordersDB.GetDB(ctx))
+			return nil
+		})
+	})
+	s.NoError(err)
+}
+
+func (s *MultiSessionTestSuite) TestWithTransaction_partialCommitJournaled() {
+	err := s.multi.WithTransaction(context.Background(), func(ctx context.Context) error {
+		_, err := s.ordersDB.GetDB(ctx).Exec("INSERT INTO orders (id) VALUES (?)", "o1")
+		s.NoError(err)
+		_, err = s.billDB.GetDB(ctx).Exec("INSERT INTO invoices (id, account_id) VALUES (?, ?)", "i1", "missing")
+		return err
+	})
+
+	s.ErrorIs(err, ErrPartialCommit)
+	s.Equal(1, s.count(s.orders, "orders"))
+	s.Equal(0, s.count(s.billing, "invoices"))
+
+	entries, err := s.multi.Pending(context.Background(), time.Now())
+	s.NoError(err)
+	s.Require().Len(entries, 1)
+	s.Equal(journalFailed, entries[0].Status)
+	s.Equal([]string{"orders", "billing"}, entries[0].Participants)
+	s.Equal([]string{"orders"}, entries[0].Committed)
+	s.Equal("billing", entries[0].Failed)
+	s.NotEmpty(entries[0].Error)
+}
+
+func (s *MultiSessionTestSuite) TestWithTransaction_firstCommitFailureIsNotPartial() {
+	multi := NewMultiSession(s.journal)
+	multi.Register("billing", s.billing)
+	multi.Register("orders", s.orders)
+	billDB := multi.DB("billing")
+	ordersDB := multi.DB("orders")
+
+	err := multi.WithTransaction(context.Background(), func(ctx context.Context) error {
+		_, err := ordersDB.GetDB(ctx).Exec("INSERT INTO orders (id) VALUES (?)", "o1")
+		s.NoError(err)
+		_, err = billDB.GetDB(ctx).Exec("INSERT INTO invoices (id, account_id) VALUES (?, ?)", "i1", "missing")
+		return err
+	})
+
+	s.Error(err)
+	s.NotErrorIs(err, ErrPartialCommit)
+	s.Equal(0, s.count(s.orders, "orders"))
+	s.Equal(journalResolved, s.journalStatus())
+}
+
+func (s *MultiSessionTestSuite) TestWithTransaction_journalFailureAfterCommit() {
+	_, err := s.journal.Exec(`CREATE TRIGGER journal_down BEFORE UPDATE ON session_journal
+		BEGIN SELECT RAISE(ABORT, 'journal down'); END`)
+	s.Require().NoError(err)
+
+	err = s.multi.WithTransaction(context.Background(), func(ctx context.Context) error {
+		_, err := s.ordersDB.GetDB(ctx).Exec("INSERT INTO orders (id) VALUES (?)", "o1")
+		return err
+	})
+
+	s.ErrorIs(err, ErrJournalNotUpdated)
+	s.NotErrorIs(err, ErrPartialCommit)
+	s.Equal(1, s.count(s.orders, "orders"))
+	s.Equal(journalCommitting, s.journalStatus())
+}
+
+func (s *MultiSessionTestSuite) TestDB_unregistered() {
+	s.Panics(func() {
+		s.multi.DB("inventory")
+	})
+}
+
+func (s *MultiSessionTestSuite) TestRecover_compensatesPartialCommit() {
+	err := s.multi.WithTransaction(context.Background(), func(ctx context.Context) error {
+		_, err := s.ordersDB.GetDB(ctx).Exec("INSERT INTO orders (id) VALUES (?)", "o1")
+		s.NoError(err)
+		_, err = s.billDB.GetDB(ctx).Exec("INSERT INTO invoices (id, account_id) VALUES (?, ?)", "i1", "missing")
+		return err
+	})
+	s.Require().ErrorIs(err, ErrPartialCommit)
+
+	var reconciled []JournalEntry
+	err = s.multi.Recover(context.Background(), time.Now(), func(ctx context.Context, e JournalEntry) error {
+		reconciled = append(reconciled, e)
+		_, err := s.orders.ExecContext(ctx, "DELETE FROM orders WHERE id = ?", "o1")
+		return err
+	})
+
+	s.NoError(err)
+	s.Len(reconciled, 1)
+	s.Equal(0, s.count(s.orders, "orders"))
+	s.Equal(journalResolved, s.journalStatus())
+
+	entries, err := s.multi.Pending(context.Background(), time.Now())
+	s.NoError(err)
+	s.Empty(entries)
+}
+
+func (s *MultiSessionTestSuite) TestRecover_keepsFailedReconciliation() {
+	_, err := s.journal.Exec(`INSERT INTO session_journal (id, status, participants, committed, failed, error, created_at)
+		VALUES ('stuck', ?, 'orders,billing', '', '', '', 0)`, journalCommitting)
+	s.Require().NoError(err)
+
+	err = s.multi.Recover(context.Background(), time.Now(), func(ctx context.Context, e JournalEntry) error {
+		s.Equal("stuck", e.ID)
+		return errors.New("cannot reach billing")
+	})
+
+	s.Error(err)
+	s.Equal(journalCommitting, s.journalStatus())
+}
+
+func TestMultiSessionTestSuite(t *testing.T) {
+	suite.Run(t, new(MultiSessionTestSuite))
+}
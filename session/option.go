@@ -0,0 +1,123 @@
+package session
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Propagation decides how WithTransaction behaves when ctx already carries a transaction
+type Propagation int
+
+const (
+	// PropagationRequired joins the transaction in progress, or starts a new one
+	PropagationRequired Propagation = iota
+	// PropagationNested runs in a savepoint of the transaction in progress, or starts a new one.
+	// An error rolls back to the savepoint without aborting the outer transaction.
+	PropagationNested
+	// PropagationRequiresNew always starts a new, independent transaction
+	PropagationRequiresNew
+)
+
+func (p Propagation) String() string {
+	switch p {
+	case PropagationRequired:
+		return "required"
+	case PropagationNested:
+		return "nested"
+	case PropagationRequiresNew:
+		return "requires_new"
+	}
+	return "unknown"
+}
+
+// TxConfig holds the settings of a single WithTransaction call
+type TxConfig struct {
+	Propagation Propagation
+	// Isolation and ReadOnly are used to begin a new transaction, and ignored when joining one
+	Isolation sql.IsolationLevel
+	ReadOnly  bool
+	// Attempts is how many times a new transaction is run while it fails with a retryable error.
+	// Zero runs it once.
+	Attempts int
+	// Retryable tells whether an error is worth another attempt, IsRetryable when nil
+	Retryable func(error) bool
+	// MaxDuration bounds how long a new transaction runs before it is rolled back with ErrTxTimeout.
+	// Zero uses the default of the session and a negative value disables it.
+	MaxDuration time.Duration
+	// Weight is how many permits of the Limiter of the session a new outermost transaction takes, 1 when zero
+	Weight int
+	// Budget bounds the statements of a new transaction, or of the savepoint of a nested call,
+	// the default of the session filling the zero limits of a new transaction
+	Budget Budget
+}
+
+// TxOption configures a single WithTransaction call
+type TxOption func(*TxConfig)
+
+// WithPropagation sets how the call behaves when a transaction is already in progress
+func WithPropagation(p Propagation) TxOption {
+	return func(c *TxConfig) {
+		c.Propagation = p
+	}
+}
+
+// WithIsolation sets the isolation level of a new transaction
+func WithIsolation(level sql.IsolationLevel) TxOption {
+	return func(c *TxConfig) {
+		c.Isolation = level
+	}
+}
+
+// WithReadOnly begins a new transaction as read-only
+func WithReadOnly() TxOption {
+	return func(c *TxConfig) {
+		c.ReadOnly = true
+	}
+}
+
+// WithRetry runs a new transaction up to attempts times while it fails with a retryable error.
+// Calls joining a transaction in progress are never retried, since they cannot undo the work of the caller.
+func WithRetry(attempts int) TxOption {
+	return func(c *TxConfig) {
+		c.Attempts = attempts
+	}
+}
+
+// WithRetryIf replaces IsRetryable in deciding which errors are retried
+func WithRetryIf(retryable func(error) bool) TxOption {
+	return func(c *TxConfig) {
+		c.Retryable = retryable
+	}
+}
+
+// WithMaxDuration rolls back a new transaction with ErrTxTimeout once it ran for d, cancelling its context.
+// A negative d disables the default of the session.
+func WithMaxDuration(d time.Duration) TxOption {
+	return func(c *TxConfig) {
+		c.MaxDuration = d
+	}
+}
+
+// WithWeight makes a new outermost transaction take weight permits of the Limiter of the session
+func WithWeight(weight int) TxOption {
+	return func(c *TxConfig) {
+		c.Weight = weight
+	}
+}
+
+// WithBudget bounds the statements of a new transaction, or of the savepoint of a nested call,
+// enforced by the executors running statements in the transaction, see Budget
+func WithBudget(b Budget) TxOption {
+	return func(c *TxConfig) {
+		c.Budget = b
+	}
+}
+
+// NewTxConfig applies opts to the default configuration
+func NewTxConfig(opts ...TxOption) TxConfig {
+	var c TxConfig
+	for _, opt := range opts {
+		opt(&c)
+	}
+	return c
+}
@@ -0,0 +1,30 @@
+package session
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewTxConfig(t *testing.T) {
+	assert.Equal(t, TxConfig{Propagation: PropagationRequired}, NewTxConfig())
+	assert.Equal(t, TxConfig{Propagation: PropagationNested}, NewTxConfig(WithPropagation(PropagationNested)))
+	assert.Equal(t, TxConfig{Isolation: sql.LevelSerializable, ReadOnly: true, Attempts: 3},
+		NewTxConfig(WithIsolation(sql.LevelSerializable), WithReadOnly(), WithRetry(3)))
+	assert.Equal(t, TxConfig{MaxDuration: time.Second}, NewTxConfig(WithMaxDuration(time.Second)))
+	assert.Equal(t, TxConfig{Weight: 3}, NewTxConfig(WithWeight(3)))
+	assert.Equal(t, TxConfig{Budget: Budget{MaxStatements: 10}}, NewTxConfig(WithBudget(Budget{MaxStatements: 10})))
+
+	cfg := NewTxConfig(WithRetryIf(func(err error) bool { return true }))
+	assert.True(t, cfg.Retryable(errors.New("any")))
+}
+
+func TestPropagation_String(t *testing.T) {
+	assert.Equal(t, "required", PropagationRequired.String())
+	assert.Equal(t, "nested", PropagationNested.String())
+	assert.Equal(t, "requires_new", PropagationRequiresNew.String())
+	assert.Equal(t, "unknown", Propagation(42).String())
+}
@@ -0,0 +1,109 @@
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aeramu/sql-transaction/session"
+	"github.com/aeramu/sql-transaction/session/dialect"
+)
+
+// DefaultTable is the name of the outbox table used when no table is configured
+const DefaultTable = "outbox"
+
+const (
+	statusPending = "pending"
+	statusSent    = "sent"
+	statusDead    = "dead"
+)
+
+// ErrNoTransaction is returned by Add when the context carries no session transaction
+var ErrNoTransaction = errors.New("outbox: no transaction in context")
+
+// Message is an event stored in the outbox table
+type Message struct {
+	ID        int64
+	Topic     string
+	Payload   []byte
+	Attempts  int
+	CreatedAt time.Time
+}
+
+// Option configures an Outbox
+type Option func(*Outbox)
+
+// WithTable sets the name of the outbox table.
+// The name is inserted into the statements as is and must be trusted.
+func WithTable(table string) Option {
+	return func(o *Outbox) {
+		o.table = table
+	}
+}
+
+// WithDialect sets the dialect used to build the outbox statements
+func WithDialect(d dialect.Dialect) Option {
+	return func(o *Outbox) {
+		o.dialect = d
+	}
+}
+
+// WithDB sets the database whose executor issues the insert of Add, so that its interceptors,
+// transaction guard and tracking apply to it.
+// By default the insert runs on the plain executor of the transaction.
+func WithDB(db session.DBWrapper[session.Executor]) Option {
+	return func(o *Outbox) {
+		o.db = db
+	}
+}
+
+// Outbox writes events into an outbox table inside the ambient session transaction
+type Outbox struct {
+	table   string
+	dialect dialect.Dialect
+	db      session.DBWrapper[session.Executor]
+	now     func() time.Time
+}
+
+// Default is the outbox used by the package level functions
+var Default = New()
+
+func New(opts ...Option) *Outbox {
+	o := &Outbox{
+		table:   DefaultTable,
+		dialect: dialect.SQLite,
+		// only used with a transaction in the context, which never reaches the nil database
+		db:  session.NewDB(nil),
+		now: time.Now,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// Add writes an event into the default outbox using the transaction carried by ctx
+func Add(ctx context.Context, topic string, payload []byte) error {
+	return Default.Add(ctx, topic, payload)
+}
+
+// Add writes an event into the outbox using the transaction carried by ctx.
+// The event becomes visible to the relay only when that transaction commits.
+// It returns ErrNoTransaction when ctx carries no transaction.
+func (o *Outbox) Add(ctx context.Context, topic string, payload []byte) error {
+	if tx, ok := session.GetTx(ctx).(*sql.Tx); !ok || tx == nil {
+		return ErrNoTransaction
+	}
+
+	now := o.now().UnixMilli()
+	query := o.dialect.Rebind(fmt.Sprintf(
+		`INSERT INTO %s (topic, payload, status, attempts, available_at, created_at) VALUES (?, ?, ?, 0, ?, ?)`,
+		o.table,
+	))
+	if _, err := o.db.GetDB(ctx).ExecContext(ctx, query, topic, payload, statusPending, now, now); err != nil {
+		return fmt.Errorf("failed to add outbox message: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,125 @@
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/suite"
+
+	"github.com/aeramu/sql-transaction/session"
+)
+
+type OutboxTestSuite struct {
+	suite.Suite
+	sqlDB   *sql.DB
+	session session.Session
+	outbox  *Outbox
+}
+
+func (s *OutboxTestSuite) SetupTest() {
+	db, err := sql.Open("sqlite3", ":memory:")
+	s.Require().NoError(err)
+	db.SetMaxOpenConns(1)
+
+	s.sqlDB = db
+	s.session = session.NewSession(db)
+	s.outbox = New()
+	s.Require().NoError(s.outbox.CreateSchema(context.Background(), db))
+}
+
+func (s *OutboxTestSuite) TearDownTest() {
+	s.sqlDB.Close()
+}
+
+func (s *OutboxTestSuite) count(status string) int {
+	var count int
+	err := s.sqlDB.QueryRow("SELECT COUNT(*) FROM outbox WHERE status = ?", status).Scan(&count)
+	s.Require().NoError(err)
+	return count
+}
+
+func (s *OutboxTestSuite) TestAdd_committedWithTransaction() {
+	err := s.session.WithTransaction(context.Background(), func(ctx context.Context) error {
+		return s.outbox.Add(ctx, "orders.created", []byte(`{"id":1}`))
+	})
+	s.NoError(err)
+
+	var topic string
+	var payload []byte
+	err = s.sqlDB.QueryRow("SELECT topic, payload FROM outbox WHERE status = ?", statusPending).Scan(&topic, &payload)
+	s.NoError(err)
+	s.Equal("orders.created", topic)
+	s.Equal(`{"id":1}`, string(payload))
+}
+
+func (s *OutboxTestSuite) TestAdd_rolledBackWithTransaction() {
+	err := s.session.WithTransaction(context.Background(), func(ctx context.Context) error {
+		s.NoError(s.outbox.Add(ctx, "orders.created", []byte(`{"id":1}`)))
+		return errors.New("business error")
+	})
+	s.Error(err)
+
+	s.Equal(0, s.count(statusPending))
+}
+
+func (s *OutboxTestSuite) TestAdd_noTransaction() {
+	err := s.outbox.Add(context.Background(), "orders.created", nil)
+	s.ErrorIs(err, ErrNoTransaction)
+}
+
+func (s *OutboxTestSuite) TestAdd_defaultOutbox() {
+	err := s.session.WithTransaction(context.Background(), func(ctx context.Context) error {
+		return Add(ctx, "orders.created", nil)
+	})
+	s.NoError(err)
+	s.Equal(1, s.count(statusPending))
+}
+
+func (s *OutboxTestSuite) TestAdd_throughDB() {
+	var queries []string
+	o := New(WithDB(session.NewDB(s.sqlDB, session.WithInterceptors(func(ctx context.Context, stmt *session.Statement, next session.StatementHandler) error {
+		queries = append(queries, stmt.Query)
+		return next(ctx, stmt)
+	}))))
+
+	err := s.session.WithTransaction(context.Background(), func(ctx context.Context) error {
+		return o.Add(ctx, "orders.created", nil)
+	})
+	s.NoError(err)
+
+	s.Len(queries, 1)
+	s.Contains(queries[0], "INSERT INTO outbox")
+	s.Equal(1, s.count(statusPending))
+}
+
+func (s *OutboxTestSuite) TestAdd_readOnlyTransaction() {
+	o := New(WithDB(session.NewDB(s.sqlDB, session.WithInterceptors(session.EnforceReadOnly()))))
+
+	err := s.session.WithTransaction(context.Background(), func(ctx context.Context) error {
+		return o.Add(ctx, "orders.created", nil)
+	}, session.WithReadOnly())
+	s.ErrorIs(err, session.ErrReadOnlyViolation)
+	s.Equal(0, s.count(statusPending))
+}
+
+func (s *OutboxTestSuite) TestCreateSchema_customTable() {
+	o := New(WithTable("events"))
+	s.Require().NoError(o.CreateSchema(context.Background(), s.sqlDB))
+	s.Require().NoError(o.CreateSchema(context.Background(), s.sqlDB))
+
+	err := s.session.WithTransaction(context.Background(), func(ctx context.Context) error {
+		return o.Add(ctx, "orders.created", nil)
+	})
+	s.NoError(err)
+
+	var count int
+	s.NoError(s.sqlDB.QueryRow("SELECT COUNT(*) FROM events").Scan(&count))
+	s.Equal(1, count)
+}
+
+func TestOutboxTestSuite(t *testing.T) {
+	suite.Run(t, new(OutboxTestSuite))
+}
@@ -0,0 +1,232 @@
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/aeramu/sql-transaction/session"
+	"github.com/aeramu/sql-transaction/session/dialect"
+)
+
+// Publisher delivers outbox messages to a broker
+type Publisher interface {
+	Publish(ctx context.Context, msg Message) error
+}
+
+// PublisherFunc adapts a function to the Publisher interface
+type PublisherFunc func(ctx context.Context, msg Message) error
+
+func (f PublisherFunc) Publish(ctx context.Context, msg Message) error {
+	return f(ctx, msg)
+}
+
+// RelayOption configures a Relay
+type RelayOption func(*Relay)
+
+// WithBatchSize sets the maximum number of messages locked and delivered per batch.
+// It panics when n is not positive, which would make Run poll without pause.
+func WithBatchSize(n int) RelayOption {
+	if n <= 0 {
+		panic(fmt.Sprintf("outbox: batch size %d is not positive", n))
+	}
+	return func(r *Relay) {
+		r.batchSize = n
+	}
+}
+
+// WithPollInterval sets how long Run waits before polling again when the outbox is drained
+func WithPollInterval(d time.Duration) RelayOption {
+	return func(r *Relay) {
+		r.pollInterval = d
+	}
+}
+
+// WithMaxAttempts sets how many failed deliveries a message gets before it is dead-lettered
+func WithMaxAttempts(n int) RelayOption {
+	return func(r *Relay) {
+		r.maxAttempts = n
+	}
+}
+
+// WithBackoff sets the delay before a failed message is retried, given the number of attempts so far
+func WithBackoff(backoff func(attempts int) time.Duration) RelayOption {
+	return func(r *Relay) {
+		r.backoff = backoff
+	}
+}
+
+// WithDeadLetter sets a publisher that receives messages which ran out of attempts.
+// A message the dead-letter publisher fails to take stays pending, and is retried after the backoff.
+func WithDeadLetter(p Publisher) RelayOption {
+	return func(r *Relay) {
+		r.deadLetter = p
+	}
+}
+
+// WithErrorHandler sets a function called when a batch fails in Run
+func WithErrorHandler(h func(err error)) RelayOption {
+	return func(r *Relay) {
+		r.onError = h
+	}
+}
+
+// Relay delivers committed outbox messages to a Publisher and marks them sent
+type Relay struct {
+	outbox     *Outbox
+	session    session.Session
+	db         session.DBWrapper[session.Executor]
+	publisher  Publisher
+	deadLetter Publisher
+	onError    func(err error)
+
+	batchSize    int
+	pollInterval time.Duration
+	maxAttempts  int
+	backoff      func(attempts int) time.Duration
+}
+
+// NewRelay creates a relay for the default outbox
+func NewRelay(db *sql.DB, publisher Publisher, opts ...RelayOption) *Relay {
+	return Default.NewRelay(db, publisher, opts...)
+}
+
+// NewRelay creates a relay delivering the messages of this outbox stored in db
+func (o *Outbox) NewRelay(db *sql.DB, publisher Publisher, opts ...RelayOption) *Relay {
+	r := &Relay{
+		outbox:       o,
+		session:      session.NewSession(db),
+		db:           session.NewDB(db),
+		publisher:    publisher,
+		onError:      func(err error) {},
+		batchSize:    100,
+		pollInterval: time.Second,
+		maxAttempts:  10,
+		backoff:      exponentialBackoff,
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+func exponentialBackoff(attempts int) time.Duration {
+	d := time.Second << min(attempts, 10)
+	return min(d, 10*time.Minute)
+}
+
+// Run processes batches until ctx is done.
+// It polls again immediately while full batches are found and waits for the poll interval otherwise.
+func (r *Relay) Run(ctx context.Context) error {
+	for {
+		n, err := r.ProcessBatch(ctx)
+		if err != nil {
+			r.onError(err)
+		}
+		if err == nil && n == r.batchSize {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(r.pollInterval):
+		}
+	}
+}
+
+// ProcessBatch locks a batch of pending messages, delivers them and records the outcome
+// in a single transaction. It returns the number of messages processed.
+func (r *Relay) ProcessBatch(ctx context.Context) (int, error) {
+	var processed int
+	err := r.session.WithTransaction(ctx, func(ctx context.Context) error {
+		msgs, err := r.lockBatch(ctx)
+		if err != nil {
+			return err
+		}
+
+		for _, msg := range msgs {
+			if err := r.deliver(ctx, msg); err != nil {
+				return err
+			}
+			processed++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return processed, nil
+}
+
+func (r *Relay) lockBatch(ctx context.Context) ([]Message, error) {
+	o := r.outbox
+	query := fmt.Sprintf(
+		`SELECT id, topic, payload, attempts, created_at FROM %s WHERE status = ? AND available_at <= ? ORDER BY id LIMIT ?`,
+		o.table,
+	)
+	if o.dialect == dialect.Postgres || o.dialect == dialect.MySQL {
+		query += " FOR UPDATE SKIP LOCKED"
+	}
+
+	rows, err := r.db.GetDB(ctx).QueryContext(ctx, o.dialect.Rebind(query), statusPending, o.now().UnixMilli(), r.batchSize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to lock outbox batch: %w", err)
+	}
+	defer rows.Close()
+
+	var msgs []Message
+	for rows.Next() {
+		var msg Message
+		var createdAt int64
+		if err := rows.Scan(&msg.ID, &msg.Topic, &msg.Payload, &msg.Attempts, &createdAt); err != nil {
+			return nil, fmt.Errorf("failed to scan outbox message: %w", err)
+		}
+		msg.CreatedAt = time.UnixMilli(createdAt)
+		msgs = append(msgs, msg)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to lock outbox batch: %w", err)
+	}
+	return msgs, nil
+}
+
+func (r *Relay) deliver(ctx context.Context, msg Message) error {
+	o := r.outbox
+	db := r.db.GetDB(ctx)
+	now := o.now()
+
+	pubErr := r.publisher.Publish(ctx, msg)
+	msg.Attempts++
+	if pubErr == nil {
+		query := fmt.Sprintf(`UPDATE %s SET status = ?, attempts = ?, sent_at = ? WHERE id = ?`, o.table)
+		if _, err := db.ExecContext(ctx, o.dialect.Rebind(query), statusSent, msg.Attempts, now.UnixMilli(), msg.ID); err != nil {
+			return fmt.Errorf("failed to mark outbox message %d sent: %w", msg.ID, err)
+		}
+		return nil
+	}
+
+	if msg.Attempts >= r.maxAttempts {
+		var deadErr error
+		if r.deadLetter != nil {
+			deadErr = r.deadLetter.Publish(ctx, msg)
+		}
+		if deadErr == nil {
+			query := fmt.Sprintf(`UPDATE %s SET status = ?, attempts = ?, last_error = ? WHERE id = ?`, o.table)
+			if _, err := db.ExecContext(ctx, o.dialect.Rebind(query), statusDead, msg.Attempts, pubErr.Error(), msg.ID); err != nil {
+				return fmt.Errorf("failed to dead-letter outbox message %d: %w", msg.ID, err)
+			}
+			return nil
+		}
+		// the message is in no sink yet, so it is retried rather than lost
+		pubErr = fmt.Errorf("%w (dead letter error: %v)", pubErr, deadErr)
+	}
+
+	query := fmt.Sprintf(`UPDATE %s SET attempts = ?, last_error = ?, available_at = ? WHERE id = ?`, o.table)
+	next := now.Add(r.backoff(msg.Attempts)).UnixMilli()
+	if _, err := db.ExecContext(ctx, o.dialect.Rebind(query), msg.Attempts, pubErr.Error(), next, msg.ID); err != nil {
+		return fmt.Errorf("failed to reschedule outbox message %d: %w", msg.ID, err)
+	}
+	return nil
+}
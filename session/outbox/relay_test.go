@@ -0,0 +1,227 @@
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/suite"
+
+	"github.com/aeramu/sql-transaction/session"
+)
+
+// memoryPublisher records the delivered messages and fails the topics listed in failTopics
+type memoryPublisher struct {
+	mu         sync.Mutex
+	messages   []Message
+	failTopics map[string]bool
+}
+
+func (p *memoryPublisher) Publish(ctx context.Context, msg Message) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.failTopics[msg.Topic] {
+		return errors.New("broker unavailable")
+	}
+	p.messages = append(p.messages, msg)
+	return nil
+}
+
+func (p *memoryPublisher) topics() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var topics []string
+	for _, msg := range p.messages {
+		topics = append(topics, msg.Topic)
+	}
+	return topics
+}
+
+type RelayTestSuite struct {
+	suite.Suite
+	sqlDB     *sql.DB
+	session   session.Session
+	outbox    *Outbox
+	publisher *memoryPublisher
+	now       time.Time
+}
+
+func (s *RelayTestSuite) SetupTest() {
+	db, err := sql.Open("sqlite3", ":memory:")
+	s.Require().NoError(err)
+	db.SetMaxOpenConns(1)
+
+	s.sqlDB = db
+	s.session = session.NewSession(db)
+	s.now = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	s.outbox = New()
+	s.outbox.now = func() time.Time { return s.now }
+	s.publisher = &memoryPublisher{failTopics: map[string]bool{}}
+	s.Require().NoError(s.outbox.CreateSchema(context.Background(), db))
+}
+
+func (s *RelayTestSuite) TearDownTest() {
+	s.sqlDB.Close()
+}
+
+func (s *RelayTestSuite) add(topics ...string) {
+	err := s.session.WithTransaction(context.Background(), func(ctx context.Context) error {
+		for _, topic := range topics {
+			if err := s.outbox.Add(ctx, topic, []byte(topic)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	s.Require().NoError(err)
+}
+
+func (s *RelayTestSuite) status(topic string) (string, int) {
+	var status string
+	var attempts int
+	err := s.sqlDB.QueryRow("SELECT status, attempts FROM outbox WHERE topic = ?", topic).Scan(&status, &attempts)
+	s.Require().NoError(err)
+	return status, attempts
+}
+
+func (s *RelayTestSuite) TestProcessBatch_deliversInOrder() {
+	s.add("a", "b", "c")
+	relay := s.outbox.NewRelay(s.sqlDB, s.publisher)
+
+	n, err := relay.ProcessBatch(context.Background())
+
+	s.NoError(err)
+	s.Equal(3, n)
+	s.Equal([]string{"a", "b", "c"}, s.publisher.topics())
+	status, attempts := s.status("b")
+	s.Equal(statusSent, status)
+	s.Equal(1, attempts)
+
+	n, err = relay.ProcessBatch(context.Background())
+	s.NoError(err)
+	s.Equal(0, n)
+}
+
+func (s *RelayTestSuite) TestProcessBatch_batchSize() {
+	s.add("a", "b", "c")
+	relay := s.outbox.NewRelay(s.sqlDB, s.publisher, WithBatchSize(2))
+
+	n, err := relay.ProcessBatch(context.Background())
+	s.NoError(err)
+	s.Equal(2, n)
+
+	n, err = relay.ProcessBatch(context.Background())
+	s.NoError(err)
+	s.Equal(1, n)
+	s.Equal([]string{"a", "b", "c"}, s.publisher.topics())
+}
+
+func (s *RelayTestSuite) TestProcessBatch_retriesAfterBackoff() {
+	s.add("flaky")
+	s.publisher.failTopics["flaky"] = true
+	relay := s.outbox.NewRelay(s.sqlDB, s.publisher, WithBackoff(func(int) time.Duration { return time.Minute }))
+
+	_, err := relay.ProcessBatch(context.Background())
+	s.NoError(err)
+	status, attempts := s.status("flaky")
+	s.Equal(statusPending, status)
+	s.Equal(1, attempts)
+
+	// not yet available
+	s.publisher.failTopics["flaky"] = false
+	n, err := relay.ProcessBatch(context.Background())
+	s.NoError(err)
+	s.Equal(0, n)
+
+	s.now = s.now.Add(time.Minute)
+	n, err = relay.ProcessBatch(context.Background())
+	s.NoError(err)
+	s.Equal(1, n)
+	status, attempts = s.status("flaky")
+	s.Equal(statusSent, status)
+	s.Equal(2, attempts)
+}
+
+func (s *RelayTestSuite) TestProcessBatch_deadLetter() {
+	s.add("poison")
+	s.publisher.failTopics["poison"] = true
+	dead := &memoryPublisher{}
+	relay := s.outbox.NewRelay(s.sqlDB, s.publisher,
+		WithMaxAttempts(2),
+		WithBackoff(func(int) time.Duration { return 0 }),
+		WithDeadLetter(dead),
+	)
+
+	for i := 0; i < 3; i++ {
+		_, err := relay.ProcessBatch(context.Background())
+		s.NoError(err)
+	}
+
+	status, attempts := s.status("poison")
+	s.Equal(statusDead, status)
+	s.Equal(2, attempts)
+	s.Equal([]string{"poison"}, dead.topics())
+
+	var lastError string
+	s.NoError(s.sqlDB.QueryRow("SELECT last_error FROM outbox WHERE topic = ?", "poison").Scan(&lastError))
+	s.Equal("broker unavailable", lastError)
+}
+
+func (s *RelayTestSuite) TestProcessBatch_deadLetterFailure() {
+	s.add("poison")
+	s.publisher.failTopics["poison"] = true
+	dead := &memoryPublisher{failTopics: map[string]bool{"poison": true}}
+	relay := s.outbox.NewRelay(s.sqlDB, s.publisher,
+		WithMaxAttempts(1),
+		WithBackoff(func(int) time.Duration { return 0 }),
+		WithDeadLetter(dead),
+	)
+
+	_, err := relay.ProcessBatch(context.Background())
+	s.NoError(err)
+	status, attempts := s.status("poison")
+	s.Equal(statusPending, status)
+	s.Equal(1, attempts)
+	var lastError string
+	s.NoError(s.sqlDB.QueryRow("SELECT last_error FROM outbox WHERE topic = ?", "poison").Scan(&lastError))
+	s.Contains(lastError, "dead letter error")
+
+	dead.failTopics["poison"] = false
+	_, err = relay.ProcessBatch(context.Background())
+	s.NoError(err)
+	status, attempts = s.status("poison")
+	s.Equal(statusDead, status)
+	s.Equal(2, attempts)
+	s.Equal([]string{"poison"}, dead.topics())
+}
+
+func (s *RelayTestSuite) TestWithBatchSize_notPositive() {
+	s.Panics(func() { WithBatchSize(0) })
+	s.Panics(func() { WithBatchSize(-1) })
+}
+
+func (s *RelayTestSuite) TestRun_stopsOnCancel() {
+	s.add("a")
+	relay := s.outbox.NewRelay(s.sqlDB, s.publisher, WithPollInterval(time.Millisecond))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- relay.Run(ctx)
+	}()
+
+	s.Eventually(func() bool {
+		return len(s.publisher.topics()) == 1
+	}, time.Second, time.Millisecond)
+	cancel()
+
+	s.ErrorIs(<-done, context.Canceled)
+}
+
+func TestRelayTestSuite(t *testing.T) {
+	suite.Run(t, new(RelayTestSuite))
+}
@@ -0,0 +1,74 @@
+package outbox
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aeramu/sql-transaction/session"
+	"github.com/aeramu/sql-transaction/session/dialect"
+)
+
+// CreateSchema creates the default outbox table if it does not exist
+func CreateSchema(ctx context.Context, db session.Executor) error {
+	return Default.CreateSchema(ctx, db)
+}
+
+// CreateSchema creates the outbox table and its index if they do not exist
+func (o *Outbox) CreateSchema(ctx context.Context, db session.Executor) error {
+	for _, stmt := range o.schema() {
+		if _, err := db.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("failed to create outbox schema: %w", err)
+		}
+	}
+	return nil
+}
+
+func (o *Outbox) schema() []string {
+	switch o.dialect {
+	case dialect.Postgres:
+		return []string{
+			fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+				id BIGSERIAL PRIMARY KEY,
+				topic VARCHAR(255) NOT NULL,
+				payload BYTEA,
+				status VARCHAR(16) NOT NULL,
+				attempts INTEGER NOT NULL DEFAULT 0,
+				last_error TEXT,
+				available_at BIGINT NOT NULL,
+				created_at BIGINT NOT NULL,
+				sent_at BIGINT
+			)`, o.table),
+			fmt.Sprintf(`CREATE INDEX IF NOT EXISTS %[1]s_pending_idx ON %[1]s (status, available_at)`, o.table),
+		}
+	case dialect.MySQL:
+		return []string{
+			fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %[1]s (
+				id BIGINT AUTO_INCREMENT PRIMARY KEY,
+				topic VARCHAR(255) NOT NULL,
+				payload LONGBLOB,
+				status VARCHAR(16) NOT NULL,
+				attempts INT NOT NULL DEFAULT 0,
+				last_error TEXT,
+				available_at BIGINT NOT NULL,
+				created_at BIGINT NOT NULL,
+				sent_at BIGINT,
+				INDEX %[1]s_pending_idx (status, available_at)
+			)`, o.table),
+		}
+	default:
+		return []string{
+			fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				topic TEXT NOT NULL,
+				payload BLOB,
+				status TEXT NOT NULL,
+				attempts INTEGER NOT NULL DEFAULT 0,
+				last_error TEXT,
+				available_at INTEGER NOT NULL,
+				created_at INTEGER NOT NULL,
+				sent_at INTEGER
+			)`, o.table),
+			fmt.Sprintf(`CREATE INDEX IF NOT EXISTS %[1]s_pending_idx ON %[1]s (status, available_at)`, o.table),
+		}
+	}
+}
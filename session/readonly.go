@@ -0,0 +1,285 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrReadOnlyViolation is returned for a statement writing to the database in a read-only transaction,
+// refused before reaching the database by EnforceReadOnly
+var ErrReadOnlyViolation = errors.New("write in read-only transaction")
+
+// EnforceReadOnly returns an interceptor refusing the statements writing to the database
+// inside a transaction begun WithReadOnly, since SQLite and several drivers ignore TxOptions.ReadOnly.
+// Statements are classified with WriteKeyword.
+func EnforceReadOnly() Interceptor {
+	return func(ctx context.Context, stmt *Statement, next StatementHandler) error {
+		if err := CheckReadOnly(ctx, stmt.Query); err != nil {
+			return err
+		}
+		return next(ctx, stmt)
+	}
+}
+
+// CheckReadOnly returns an error wrapping ErrReadOnlyViolation when query writes to the database
+// and ctx carries a transaction begun WithReadOnly
+func CheckReadOnly(ctx context.Context, query string) error {
+	st := getState(ctx)
+	if st == nil || !st.Options().ReadOnly {
+		return nil
+	}
+	if keyword := WriteKeyword(query); keyword != "" {
+		return fmt.Errorf("%w: %s in transaction %s", ErrReadOnlyViolation, keyword, st.ID())
+	}
+	return nil
+}
+
+// readKeywords start the statements which do not write to the database
+var readKeywords = map[string]bool{
+	"SELECT": true, "VALUES": true, "TABLE": true, "SHOW": true, "DESCRIBE": true, "DESC": true,
+	"BEGIN": true, "START": true, "COMMIT": true, "END": true, "ROLLBACK": true, "SAVEPOINT": true, "RELEASE": true,
+	"SET": true, "RESET": true, "DECLARE": true, "FETCH": true, "MOVE": true, "CLOSE": true, "USE": true,
+}
+
+// dataWriteKeywords start the statements writing rows, which may be nested in a CTE or an EXPLAIN ANALYZE
+var dataWriteKeywords = map[string]bool{
+	"INSERT": true, "UPDATE": true, "DELETE": true, "MERGE": true, "UPSERT": true, "REPLACE": true,
+}
+
+// WriteKeyword returns the keyword of the first statement of query writing to the database, such as INSERT,
+// or an empty string when query only reads.
+// It skips comments and literals, looks into the CTEs of WITH and the statement of EXPLAIN ANALYZE,
+// and sees statements with RETURNING and SELECT statements creating a table with INTO as writes.
+// Unknown statements are seen as writes.
+func WriteKeyword(query string) string {
+	t := &sqlTokenizer{s: query}
+	statementStart := true
+	depth := 0
+	// with is set in a WITH statement, whose main statement follows its CTEs at depth 0
+	with, explain, analyze := false, false, false
+	// selecting is set in a SELECT statement, whose INTO at selectDepth creates a table
+	selecting, selectDepth := false, 0
+	var prev sqlToken
+	for tok := t.next(); tok.kind != tokenEOF; prev, tok = tok, t.next() {
+		word := ""
+		if tok.kind == tokenWord {
+			word = strings.ToUpper(tok.text)
+		}
+
+		switch {
+		case tok.text == ";":
+			statementStart, depth, with, explain, analyze, selecting = true, 0, false, false, false, false
+			continue
+		case tok.text == "(":
+			depth++
+			continue
+		case tok.text == ")":
+			depth--
+			continue
+		}
+
+		if statementStart {
+			statementStart = false
+			switch {
+			case word == "WITH":
+				with = true
+			case word == "EXPLAIN":
+				explain = true
+			case word == "SELECT":
+				selecting, selectDepth = true, depth
+			case word == "PRAGMA":
+				// a PRAGMA assigning a value changes the database
+				if strings.Contains(statementText(query, t.pos), "=") {
+					return word
+				}
+			case !readKeywords[word]:
+				return word
+			}
+			continue
+		}
+
+		// REPLACE is also a string function
+		if word == "" || word == "REPLACE" && t.peek().text == "(" {
+			continue
+		}
+		switch {
+		case selecting && depth == selectDepth && word == "INTO":
+			return word
+		case explain && (word == "ANALYZE" || word == "ANALYSE"):
+			analyze = true
+		case explain && analyze && dataWriteKeywords[word]:
+			return word
+		case prev.text == "(" && dataWriteKeywords[word]:
+			// a CTE or a subquery writing rows
+			return word
+		case with && depth == 0 && prev.text == ")" && !withClauses[word]:
+			// the main statement of the WITH
+			with = false
+			if !readKeywords[word] {
+				return word
+			}
+			selecting, selectDepth = word == "SELECT", depth
+		}
+	}
+	return ""
+}
+
+// withClauses may follow the closing parenthesis of a CTE or of its columns
+var withClauses = map[string]bool{"AS": true, "NOT": true, "MATERIALIZED": true, "SEARCH": true, "CYCLE": true}
+
+// statementText returns the text of the statement of query running from pos to the next semicolon
+func statementText(query string, pos int) string {
+	t := &sqlTokenizer{s: query, pos: pos}
+	for tok := t.next(); tok.kind != tokenEOF; tok = t.next() {
+		if tok.text == ";" {
+			return query[pos:t.pos]
+		}
+	}
+	return query[pos:]
+}
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	// tokenWord is a keyword or an unquoted identifier
+	tokenWord
+	// tokenLiteral is a string, a number, a quoted identifier or a parameter
+	tokenLiteral
+	tokenPunct
+)
+
+type sqlToken struct {
+	kind tokenKind
+	text string
+}
+
+// sqlTokenizer splits SQL into words, literals and punctuation, skipping spaces and comments
+type sqlTokenizer struct {
+	s   string
+	pos int
+	// comments holds the comments skipped so far
+	comments []string
+}
+
+func (t *sqlTokenizer) peek() sqlToken {
+	pos := t.pos
+	tok := t.next()
+	t.pos = pos
+	return tok
+}
+
+func (t *sqlTokenizer) next() sqlToken {
+	for t.pos < len(t.s) {
+		c := t.s[t.pos]
+		start := t.pos
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f':
+			t.pos++
+		case strings.HasPrefix(t.s[t.pos:], "--"):
+			t.skipLine()
+			t.comments = append(t.comments, t.s[start:t.pos])
+		case strings.HasPrefix(t.s[t.pos:], "/*"):
+			t.skipComment()
+			t.comments = append(t.comments, t.s[start:t.pos])
+		case c == '\'' || c == '"' || c == '`':
+			t.skipQuoted(c)
+			return sqlToken{kind: tokenLiteral, text: t.s[start:t.pos]}
+		case c == '$' && t.skipDollarQuoted():
+			return sqlToken{kind: tokenLiteral, text: t.s[start:t.pos]}
+		case isWordStart(c):
+			for t.pos < len(t.s) && isWordPart(t.s[t.pos]) {
+				t.pos++
+			}
+			return sqlToken{kind: tokenWord, text: t.s[start:t.pos]}
+		case isDigit(c) || c == '$' || c == '?' || c == ':' && t.pos+1 < len(t.s) && isWordStart(t.s[t.pos+1]):
+			t.pos++
+			for t.pos < len(t.s) && (isWordPart(t.s[t.pos]) || t.s[t.pos] == '.') {
+				t.pos++
+			}
+			return sqlToken{kind: tokenLiteral, text: t.s[start:t.pos]}
+		default:
+			t.pos++
+			return sqlToken{kind: tokenPunct, text: t.s[start:t.pos]}
+		}
+	}
+	return sqlToken{kind: tokenEOF}
+}
+
+func (t *sqlTokenizer) skipLine() {
+	for t.pos < len(t.s) && t.s[t.pos] != '\n' {
+		t.pos++
+	}
+}
+
+// skipComment skips a block comment, which may be nested
+func (t *sqlTokenizer) skipComment() {
+	nesting := 0
+	for t.pos < len(t.s) {
+		switch {
+		case strings.HasPrefix(t.s[t.pos:], "/*"):
+			nesting++
+			t.pos += 2
+		case strings.HasPrefix(t.s[t.pos:], "*/"):
+			nesting--
+			t.pos += 2
+			if nesting == 0 {
+				return
+			}
+		default:
+			t.pos++
+		}
+	}
+}
+
+// skipQuoted skips a literal quoted with quote, in which a doubled quote stands for itself
+func (t *sqlTokenizer) skipQuoted(quote byte) {
+	t.pos++
+	for t.pos < len(t.s) {
+		if t.s[t.pos] == quote {
+			t.pos++
+			if t.pos < len(t.s) && t.s[t.pos] == quote {
+				t.pos++
+				continue
+			}
+			return
+		}
+		t.pos++
+	}
+}
+
+// skipDollarQuoted skips a PostgreSQL dollar quoted string such as $tag$...$tag$,
+// and reports false without moving for anything else
+func (t *sqlTokenizer) skipDollarQuoted() bool {
+	end := t.pos + 1
+	for end < len(t.s) && t.s[end] != '$' {
+		if !isWordStart(t.s[end]) {
+			return false
+		}
+		end++
+	}
+	if end == len(t.s) {
+		return false
+	}
+	tag := t.s[t.pos : end+1]
+	if i := strings.Index(t.s[end+1:], tag); i >= 0 {
+		t.pos = end + 1 + i + len(tag)
+	} else {
+		t.pos = len(t.s)
+	}
+	return true
+}
+
+func isWordStart(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_' || c >= 0x80
+}
+
+func isWordPart(c byte) bool {
+	return isWordStart(c) || isDigit(c) || c == '$'
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
@@ -0,0 +1,89 @@
+package session
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteKeyword(t *testing.T) {
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"SELECT * FROM models", ""},
+		{"  select id from models where id = 'DELETE'", ""},
+		{"SELECT replace(id, 'a', 'b') FROM models", ""},
+		{"-- UPDATE models\nSELECT 1", ""},
+		{"/* DELETE /* nested */ FROM models */ SELECT 1", ""},
+		{`SELECT "update" FROM models`, ""},
+		{"SELECT $tag$ DELETE $tag$", ""},
+		{"SELECT * FROM models WHERE id IN (SELECT id FROM others)", ""},
+		{"(SELECT 1) UNION (SELECT 2)", ""},
+		{"WITH t AS (SELECT 1) SELECT * FROM t", ""},
+		{"WITH RECURSIVE t(n) AS (SELECT 1 UNION ALL SELECT n + 1 FROM t) SELECT n FROM t", ""},
+		{"EXPLAIN DELETE FROM models", ""},
+		{"PRAGMA table_info(models)", ""},
+		{"SHOW TRANSACTION ISOLATION LEVEL", ""},
+		{"SELECT 'INTO' FROM models WHERE id IN (SELECT id FROM others)", ""},
+		{"", ""},
+		{"INSERT INTO models (id) VALUES (?)", "INSERT"},
+		{"/* tx_id='1' */ update models SET n = 1", "UPDATE"},
+		{"DELETE FROM models RETURNING id", "DELETE"},
+		{"INSERT INTO models (id) VALUES ('a') RETURNING id", "INSERT"},
+		{"REPLACE INTO models (id) VALUES ('a')", "REPLACE"},
+		{"WITH d AS (DELETE FROM models RETURNING id) SELECT * FROM d", "DELETE"},
+		{"WITH t AS (SELECT 1 AS id) INSERT INTO models (id) SELECT id FROM t", "INSERT"},
+		{"WITH t(id) AS MATERIALIZED (SELECT 1) UPDATE models SET n = 1", "UPDATE"},
+		{"EXPLAIN ANALYZE DELETE FROM models", "DELETE"},
+		{"EXPLAIN (ANALYZE, BUFFERS) UPDATE models SET n = 1", "UPDATE"},
+		{"SELECT 1; DROP TABLE models", "DROP"},
+		{"CREATE TABLE t (id INTEGER)", "CREATE"},
+		{"TRUNCATE models", "TRUNCATE"},
+		{"PRAGMA journal_mode = WAL", "PRAGMA"},
+		{"SELECT * INTO archive FROM models", "INTO"},
+		{"select id into temp archive from models where n > 1", "INTO"},
+		{"WITH t AS (SELECT 1 AS id) SELECT id INTO archive FROM t", "INTO"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			assert.Equal(t, tt.want, WriteKeyword(tt.query))
+		})
+	}
+}
+
+func TestEnforceReadOnly(t *testing.T) {
+	db := openSerialTestDB(t)
+	wrapper := NewDB(db, WithInterceptors(EnforceReadOnly()))
+	sess := NewSession(db)
+
+	err := sess.WithTransaction(context.Background(), func(ctx context.Context) error {
+		executor := wrapper.GetDB(ctx)
+		var count int
+		require.NoError(t, executor.QueryRowContext(ctx, "SELECT COUNT(*) FROM models").Scan(&count))
+
+		_, err := executor.ExecContext(ctx, "INSERT INTO models (id) VALUES (?)", "a")
+		assert.ErrorIs(t, err, ErrReadOnlyViolation)
+		assert.ErrorContains(t, err, "INSERT")
+		_, err = executor.QueryContext(ctx, "DELETE FROM models RETURNING id")
+		assert.ErrorIs(t, err, ErrReadOnlyViolation)
+
+		// joining calls share the read-only transaction
+		return sess.WithTransaction(ctx, func(ctx context.Context) error {
+			_, err := wrapper.GetDB(ctx).ExecContext(ctx, "UPDATE models SET n = 1")
+			return err
+		}, WithPropagation(PropagationNested))
+	}, WithReadOnly())
+	assert.ErrorIs(t, err, ErrReadOnlyViolation)
+
+	err = sess.WithTransaction(context.Background(), func(ctx context.Context) error {
+		_, err := wrapper.GetDB(ctx).ExecContext(ctx, "INSERT INTO models (id) VALUES (?)", "a")
+		return err
+	})
+	require.NoError(t, err)
+	_, err = wrapper.GetDB(context.Background()).Exec("INSERT INTO models (id) VALUES (?)", "b")
+	require.NoError(t, err)
+	assert.Equal(t, 2, countModels(t, db))
+}
@@ -0,0 +1,122 @@
+package session
+
+import (
+	"context"
+	"database/sql"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ActiveTx describes a transaction in progress, as listed by Registry.Snapshot
+type ActiveTx struct {
+	ID        string        `json:"id"`
+	StartedAt time.Time     `json:"started_at"`
+	Age       time.Duration `json:"age"`
+	Isolation string        `json:"isolation"`
+	ReadOnly  bool          `json:"read_only"`
+	// MaxDuration is zero when the transaction has no maximum duration
+	MaxDuration time.Duration `json:"max_duration,omitempty"`
+	// Depth is the number of savepoints currently open in the transaction
+	Depth int `json:"depth"`
+	// Stack is the stack of the goroutine that began the transaction
+	Stack string `json:"stack"`
+	// Statements and LastStatement count the statements run through a session executor,
+	// a database opened with WrapDriver or an adapter adding TrackStatements
+	Statements    int64  `json:"statements"`
+	LastStatement string `json:"last_statement,omitempty"`
+}
+
+// Registry lists the transactions in progress of the sessions it is added to with WithRegistry.
+// The goroutines running those transactions carry the pprof label tx_id.
+type Registry struct {
+	mu     sync.Mutex
+	active map[*TxState]struct{}
+}
+
+func NewRegistry() *Registry {
+	return &Registry{active: make(map[*TxState]struct{})}
+}
+
+// Snapshot returns the transactions in progress, the oldest first
+func (r *Registry) Snapshot() []ActiveTx {
+	now := time.Now()
+	r.mu.Lock()
+	snapshot := make([]ActiveTx, 0, len(r.active))
+	for st := range r.active {
+		snapshot = append(snapshot, st.activeTx(now))
+	}
+	r.mu.Unlock()
+
+	sort.Slice(snapshot, func(i, j int) bool {
+		return snapshot[i].StartedAt.Before(snapshot[j].StartedAt)
+	})
+	return snapshot
+}
+
+// activeTx describes the transaction of st, as it is at now
+func (st *TxState) activeTx(now time.Time) ActiveTx {
+	return ActiveTx{
+		ID:            st.id,
+		StartedAt:     st.startedAt,
+		Age:           now.Sub(st.startedAt),
+		Isolation:     isolationName(st.config.Isolation),
+		ReadOnly:      st.config.ReadOnly,
+		MaxDuration:   max(st.config.MaxDuration, 0),
+		Depth:         int(st.nesting.Load()),
+		Stack:         string(st.stack),
+		Statements:    st.statements.Load(),
+		LastStatement: st.LastStatement(),
+	}
+}
+
+func (r *Registry) add(st *TxState) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.active[st] = struct{}{}
+}
+
+func (r *Registry) remove(st *TxState) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.active, st)
+}
+
+func isolationName(level sql.IsolationLevel) string {
+	if level == sql.LevelDefault {
+		return "default"
+	}
+	return level.String()
+}
+
+// noteStatement records query as the last statement of the transaction carried by ctx, if it is tracked
+func noteStatement(ctx context.Context, query string) {
+	if st := getState(ctx); st != nil {
+		st.root().noteStatement(query)
+	}
+}
+
+// TrackStatements returns an interceptor recording the statements it runs in the transaction of st
+// for its watchdog and registry, and enforcing the budgets of the transaction and of its savepoints like EnforceBudget,
+// or nil when st is nil or its transaction is neither tracked nor budgeted.
+// Every statement is recorded once, by the executor running it on the transaction: the executors of this package
+// add this interceptor to the executors they convert from a transaction and so must adapters,
+// and the databases opened with WrapDriver only record the statements they route to it.
+func TrackStatements(st *TxState) Interceptor {
+	if st == nil {
+		return nil
+	}
+	root := st.root()
+	if !root.tracked && !root.budgeted.Load() {
+		return nil
+	}
+	return func(ctx context.Context, stmt *Statement, next StatementHandler) error {
+		if root.tracked {
+			root.noteStatement(stmt.Query)
+		}
+		if root.budgeted.Load() {
+			return enforceBudget(ctx, stmt, next)
+		}
+		return next(ctx, stmt)
+	}
+}
@@ -0,0 +1,61 @@
+package session
+
+import (
+	"context"
+	"database/sql"
+	"runtime/pprof"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistry_Snapshot(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+	_, err = db.Exec(`CREATE TABLE models (id TEXT PRIMARY KEY)`)
+	require.NoError(t, err)
+
+	registry := NewRegistry()
+	sess := NewSession(db, WithRegistry(registry))
+	wrapper := NewDB(db)
+	assert.Empty(t, registry.Snapshot())
+
+	err = sess.WithTransaction(context.Background(), func(ctx context.Context) error {
+		label, ok := pprof.Label(ctx, "tx_id")
+		assert.True(t, ok)
+		assert.Equal(t, TxID(ctx), label)
+
+		_, err := wrapper.GetDB(ctx).ExecContext(ctx, "INSERT INTO models (id) VALUES (?)", "a")
+		require.NoError(t, err)
+
+		return sess.WithTransaction(ctx, func(ctx context.Context) error {
+			_, err := wrapper.GetDB(ctx).ExecContext(ctx, "INSERT INTO models (id) VALUES (?)", "b")
+			require.NoError(t, err)
+
+			snapshot := registry.Snapshot()
+			require.Len(t, snapshot, 1)
+			active := snapshot[0]
+			assert.Equal(t, TxID(ctx), active.ID)
+			assert.Equal(t, "Serializable", active.Isolation)
+			assert.Equal(t, time.Minute, active.MaxDuration)
+			assert.Equal(t, 1, active.Depth)
+			assert.Equal(t, int64(2), active.Statements)
+			assert.Equal(t, "INSERT INTO models (id) VALUES (?)", active.LastStatement)
+			assert.Contains(t, active.Stack, "TestRegistry_Snapshot")
+			assert.Positive(t, active.Age)
+			return nil
+		}, WithPropagation(PropagationNested))
+	}, WithIsolation(sql.LevelSerializable), WithMaxDuration(time.Minute))
+	require.NoError(t, err)
+
+	assert.Empty(t, registry.Snapshot())
+}
+
+func TestTrackStatements_untracked(t *testing.T) {
+	assert.Nil(t, TrackStatements(nil))
+	assert.Nil(t, TrackStatements(Current(WithExternalTx(context.Background(), &sql.Tx{}, PropagationRequired))))
+}
@@ -0,0 +1,156 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrNoTransaction is returned when an operation requires a session transaction but ctx carries none
+	ErrNoTransaction = errors.New("no session transaction in context")
+
+	// ErrResourceCommit is returned by WithTransaction when the SQL transaction committed
+	// but at least one enlisted resource failed to commit
+	ErrResourceCommit = errors.New("resource commit failed after transaction committed")
+)
+
+// Resource is a non-SQL participant enlisted in a session transaction.
+// Prepare is called after the transaction function succeeded and before the SQL commit,
+// and must make the pending change durable enough that Commit is very unlikely to fail.
+// Commit is called after the SQL commit and Rollback whenever the transaction does not commit.
+type Resource interface {
+	Prepare(ctx context.Context) error
+	Commit(ctx context.Context) error
+	Rollback(ctx context.Context) error
+}
+
+// Enlist adds r to the session transaction carried by ctx.
+// The resource is driven by the outermost WithTransaction call.
+// In a transaction owned by the caller, no call is outermost: the caller rolls the resources back
+// with RollbackResources, and they are committed by no one.
+func Enlist(ctx context.Context, r Resource) error {
+	st := getState(ctx)
+	if st == nil {
+		return ErrNoTransaction
+	}
+	root := st.root()
+	root.mu.Lock()
+	defer root.mu.Unlock()
+	root.resources = append(root.resources, r)
+	return nil
+}
+
+// RollbackResources rolls back the resources enlisted in the transaction owned by the caller of st,
+// for the caller to run once it rolled the transaction back. The resources of a transaction
+// begun by a session are driven by the session, and rolling them back returns an error.
+func (st *TxState) RollbackResources(ctx context.Context) error {
+	root := st.root()
+	if !root.external {
+		return fmt.Errorf("transaction %s: resources are rolled back by its session", root.id)
+	}
+	root.mu.Lock()
+	resources := root.resources
+	root.resources = nil
+	root.mu.Unlock()
+	return rollbackResources(ctx, resources)
+}
+
+// RecoveryEntry describes a resource whose commit failed after the SQL transaction committed
+type RecoveryEntry struct {
+	Resource Resource
+	Err      error
+	Time     time.Time
+}
+
+// RecoveryLog records the resources whose commit failed after the SQL transaction committed,
+// so they can be committed again later
+type RecoveryLog interface {
+	Record(ctx context.Context, entry RecoveryEntry) error
+}
+
+// MemoryRecoveryLog is a RecoveryLog kept in memory, suitable for in-process resources
+type MemoryRecoveryLog struct {
+	mu      sync.Mutex
+	entries []RecoveryEntry
+}
+
+func NewMemoryRecoveryLog() *MemoryRecoveryLog {
+	return &MemoryRecoveryLog{}
+}
+
+func (l *MemoryRecoveryLog) Record(ctx context.Context, entry RecoveryEntry) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.entries = append(l.entries, entry)
+	return nil
+}
+
+// Entries returns the resources still waiting for recovery
+func (l *MemoryRecoveryLog) Entries() []RecoveryEntry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]RecoveryEntry(nil), l.entries...)
+}
+
+// Recover commits the recorded resources again and keeps the ones that still fail
+func (l *MemoryRecoveryLog) Recover(ctx context.Context) error {
+	l.mu.Lock()
+	entries := l.entries
+	l.entries = nil
+	l.mu.Unlock()
+
+	var errs []error
+	for _, entry := range entries {
+		if err := entry.Resource.Commit(ctx); err != nil {
+			errs = append(errs, err)
+			_ = l.Record(ctx, RecoveryEntry{Resource: entry.Resource, Err: err, Time: time.Now()})
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func prepareResources(ctx context.Context, resources []Resource) error {
+	for _, r := range resources {
+		if err := r.Prepare(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rollbackResources rolls back every resource, even when some of them fail
+func rollbackResources(ctx context.Context, resources []Resource) error {
+	var errs []error
+	for _, r := range resources {
+		if err := r.Rollback(ctx); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// commitResources commits every resource after the SQL commit.
+// Failed resources are recorded in the recovery log, since the SQL changes can no longer be undone.
+func commitResources(ctx context.Context, resources []Resource, log RecoveryLog) error {
+	var errs []error
+	for _, r := range resources {
+		err := r.Commit(ctx)
+		if err == nil {
+			continue
+		}
+		errs = append(errs, err)
+		if log == nil {
+			continue
+		}
+		if logErr := log.Record(ctx, RecoveryEntry{Resource: r, Err: err, Time: time.Now()}); logErr != nil {
+			errs = append(errs, fmt.Errorf("failed to record resource for recovery: %w", logErr))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%w: %w", ErrResourceCommit, errors.Join(errs...))
+	}
+	return nil
+}
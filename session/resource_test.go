@@ -0,0 +1,218 @@
+package session
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/suite"
+)
+
+// cacheResource stages a value and publishes it into the cache only on commit
+type cacheResource struct {
+	cache  map[string]string
+	key    string
+	value  string
+	calls  []string
+	failOn map[string]error
+}
+
+func (r *cacheResource) step(name string) error {
+	r.calls = append(r.calls, name)
+	return r.failOn[name]
+}
+
+func (r *cacheResource) Prepare(ctx context.Context) error {
+	return r.step("prepare")
+}
+
+func (r *cacheResource) Commit(ctx context.Context) error {
+	if err := r.step("commit"); err != nil {
+		return err
+	}
+	r.cache[r.key] = r.value
+	return nil
+}
+
+func (r *cacheResource) Rollback(ctx context.Context) error {
+	return r.step("rollback")
+}
+
+type ResourceTestSuite struct {
+	suite.Suite
+	sqlDB   *sql.DB
+	db      DBWrapper[Executor]
+	log     *MemoryRecoveryLog
+	session Session
+	cache   map[string]string
+}
+
+func (s *ResourceTestSuite) SetupTest() {
+	db, err := sql.Open("sqlite3", "file::memory:?_foreign_keys=1")
+	s.Require().NoError(err)
+	db.SetMaxOpenConns(1)
+
+	_, err = db.Exec(`CREATE TABLE parents (id TEXT PRIMARY KEY)`)
+	s.Require().NoError(err)
+	_, err = db.Exec(`CREATE TABLE children (
+		id TEXT PRIMARY KEY,
+		parent_id TEXT REFERENCES parents(id) DEFERRABLE INITIALLY DEFERRED
+	)`)
+	s.Require().NoError(err)
+
+	s.sqlDB = db
+	s.db = NewDB(db)
+	s.log = NewMemoryRecoveryLog()
+	s.session = NewSession(db, WithRecoveryLog(s.log))
+	s.cache = map[string]string{}
+}
+
+func (s *ResourceTestSuite) TearDownTest() {
+	s.sqlDB.Close()
+}
+
+func (s *ResourceTestSuite) newResource(failOn map[string]error) *cacheResource {
+	return &cacheResource{cache: s.cache, key: "k", value: "v", failOn: failOn}
+}
+
+func (s *ResourceTestSuite) countParents() int {
+	var count int
+	s.Require().NoError(s.sqlDB.QueryRow("SELECT COUNT(*) FROM parents").Scan(&count))
+	return count
+}
+
+func (s *ResourceTestSuite) TestEnlist_noTransaction() {
+	err := Enlist(context.Background(), s.newResource(nil))
+	s.ErrorIs(err, ErrNoTransaction)
+}
+
+func (s *ResourceTestSuite) TestWithTransaction_resourceCommitted() {
+	r := s.newResource(nil)
+	err := s.session.WithTransaction(context.Background(), func(ctx context.Context) error {
+		_, err := s.db.GetDB(ctx).Exec("INSERT INTO parents (id) VALUES (?)", "p1")
+		s.NoError(err)
+		return Enlist(ctx, r)
+	})
+
+	s.NoError(err)
+	s.Equal([]string{"prepare", "commit"}, r.calls)
+	s.Equal("v", s.cache["k"])
+	s.Equal(1, s.countParents())
+}
+
+func (s *ResourceTestSuite) TestWithTransaction_resourceRolledBackOnError() {
+	r := s.newResource(nil)
+	err := s.session.WithTransaction(context.Background(), func(ctx context.Context) error {
+		s.NoError(Enlist(ctx, r))
+		return errors.New("business error")
+	})
+
+	s.Error(err)
+	s.Equal([]string{"rollback"}, r.calls)
+	s.Empty(s.cache)
+}
+
+func (s *ResourceTestSuite) TestWithTransaction_resourceRolledBackOnCancel() {
+	r := s.newResource(nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	err := s.session.WithTransaction(ctx, func(ctx context.Context) error {
+		s.NoError(Enlist(ctx, r))
+		cancel()
+		// database/sql rolls back the cancelled transaction on its own, after which Rollback fails
+		tx := GetTx(ctx).(*sql.Tx)
+		s.Eventually(func() bool {
+			_, err := tx.ExecContext(context.Background(), "SELECT 1")
+			return errors.Is(err, sql.ErrTxDone)
+		}, time.Second, time.Millisecond)
+		return ctx.Err()
+	})
+
+	s.ErrorIs(err, context.Canceled)
+	s.Equal([]string{"rollback"}, r.calls)
+	s.Empty(s.cache)
+}
+
+func (s *ResourceTestSuite) TestWithTransaction_prepareFailureRollsBackAll() {
+	prepareErr := errors.New("disk full")
+	first := s.newResource(nil)
+	second := s.newResource(map[string]error{"prepare": prepareErr})
+	err := s.session.WithTransaction(context.Background(), func(ctx context.Context) error {
+		_, err := s.db.GetDB(ctx).Exec("INSERT INTO parents (id) VALUES (?)", "p1")
+		s.NoError(err)
+		s.NoError(Enlist(ctx, first))
+		return Enlist(ctx, second)
+	})
+
+	s.ErrorIs(err, prepareErr)
+	s.Equal([]string{"prepare", "rollback"}, first.calls)
+	s.Equal([]string{"prepare", "rollback"}, second.calls)
+	s.Equal(0, s.countParents())
+}
+
+func (s *ResourceTestSuite) TestWithTransaction_sqlCommitFailureRollsBackResources() {
+	r := s.newResource(nil)
+	err := s.session.WithTransaction(context.Background(), func(ctx context.Context) error {
+		_, err := s.db.GetDB(ctx).Exec("INSERT INTO children (id, parent_id) VALUES (?, ?)", "c1", "missing")
+		s.NoError(err)
+		return Enlist(ctx, r)
+	})
+
+	s.Error(err)
+	s.Equal([]string{"prepare", "rollback"}, r.calls)
+	s.Empty(s.cache)
+}
+
+func (s *ResourceTestSuite) TestWithTransaction_resourceCommitFailureRecorded() {
+	commitErr := errors.New("cache unavailable")
+	r := s.newResource(map[string]error{"commit": commitErr})
+	err := s.session.WithTransaction(context.Background(), func(ctx context.Context) error {
+		_, err := s.db.GetDB(ctx).Exec("INSERT INTO parents (id) VALUES (?)", "p1")
+		s.NoError(err)
+		return Enlist(ctx, r)
+	})
+
+	s.ErrorIs(err, ErrResourceCommit)
+	s.ErrorIs(err, commitErr)
+	s.Equal(1, s.countParents())
+	s.Require().Len(s.log.Entries(), 1)
+	s.Equal(r, s.log.Entries()[0].Resource)
+
+	delete(r.failOn, "commit")
+	s.NoError(s.log.Recover(context.Background()))
+	s.Empty(s.log.Entries())
+	s.Equal("v", s.cache["k"])
+}
+
+func (s *ResourceTestSuite) TestWithTransaction_nestedEnlistDrivenByOutermost() {
+	r := s.newResource(nil)
+	err := s.session.WithTransaction(context.Background(), func(ctx context.Context) error {
+		err := s.session.WithTransaction(ctx, func(ctx context.Context) error {
+			return Enlist(ctx, r)
+		})
+		s.NoError(err)
+		s.Empty(r.calls)
+		return nil
+	})
+
+	s.NoError(err)
+	s.Equal([]string{"prepare", "commit"}, r.calls)
+}
+
+func (s *ResourceTestSuite) TestWithTransaction_resourceRolledBackOnPanic() {
+	r := s.newResource(nil)
+	s.Panics(func() {
+		_ = s.session.WithTransaction(context.Background(), func(ctx context.Context) error {
+			s.NoError(Enlist(ctx, r))
+			panic("test panic")
+		})
+	})
+
+	s.Equal([]string{"rollback"}, r.calls)
+}
+
+func TestResourceTestSuite(t *testing.T) {
+	suite.Run(t, new(ResourceTestSuite))
+}
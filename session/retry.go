@@ -0,0 +1,42 @@
+package session
+
+import (
+	"errors"
+	"strings"
+)
+
+// retryableStates are the SQLSTATE codes of serialization failures and deadlocks
+var retryableStates = map[string]bool{
+	"40001": true,
+	"40P01": true,
+}
+
+// retryableMessages identify the same failures for drivers that do not expose a SQLSTATE
+var retryableMessages = []string{
+	"deadlock",
+	"could not serialize access",
+	"lock wait timeout exceeded",
+	"database is locked",
+	"database table is locked",
+}
+
+// IsRetryable tells whether err is a serialization failure or a deadlock,
+// after which running the whole transaction again may succeed.
+// Errors exposing a SQLState method, like the ones of pgx, are checked by their code,
+// and the others by the messages of PostgreSQL, MySQL and SQLite.
+func IsRetryable(err error) bool {
+	if err == nil {
+		return false
+	}
+	var stateErr interface{ SQLState() string }
+	if errors.As(err, &stateErr) {
+		return retryableStates[stateErr.SQLState()]
+	}
+	msg := strings.ToLower(err.Error())
+	for _, m := range retryableMessages {
+		if strings.Contains(msg, m) {
+			return true
+		}
+	}
+	return false
+}
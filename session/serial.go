@@ -0,0 +1,238 @@
+package session
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"runtime/debug"
+	"sync"
+)
+
+// ErrSerializedPrepare is returned by the executors of WithSerializedTx for prepared statements,
+// whose later runs could not be serialized
+var ErrSerializedPrepare = errors.New("prepared statements cannot be serialized")
+
+// ConcurrentUse describes a statement that had to wait for another one running on the same transaction
+type ConcurrentUse struct {
+	Query string
+	Stack []byte
+	// HolderQuery and HolderStack describe the statement running when Query had to wait,
+	// and are empty when it was not run by an executor reporting concurrent use
+	HolderQuery string
+	HolderStack []byte
+}
+
+// connLock serializes the statements run on the connection of a transaction
+type connLock struct {
+	mu sync.Mutex
+
+	// holder describes the statement holding mu, recorded while reporting concurrent use only
+	holderMu sync.Mutex
+	holder   *ConcurrentUse
+}
+
+func (l *connLock) lock(query string, report func(ConcurrentUse)) {
+	if report == nil {
+		l.mu.Lock()
+		return
+	}
+	stack := debug.Stack()
+	if !l.mu.TryLock() {
+		use := ConcurrentUse{Query: query, Stack: stack}
+		l.holderMu.Lock()
+		if l.holder != nil {
+			use.HolderQuery, use.HolderStack = l.holder.Query, l.holder.Stack
+		}
+		l.holderMu.Unlock()
+		report(use)
+		l.mu.Lock()
+	}
+	l.holderMu.Lock()
+	l.holder = &ConcurrentUse{Query: query, Stack: stack}
+	l.holderMu.Unlock()
+}
+
+func (l *connLock) unlock() {
+	l.holderMu.Lock()
+	l.holder = nil
+	l.holderMu.Unlock()
+	l.mu.Unlock()
+}
+
+// serialTx runs one statement at a time on tx, whichever goroutine runs it.
+// Result sets are read entirely before the next statement runs, so rows left open never block the connection.
+// It refuses to prepare statements, and the SAVEPOINT statements of nested calls run on tx directly,
+// so they are not serialized either.
+type serialTx struct {
+	tx     *sql.Tx
+	lock   *connLock
+	report func(ConcurrentUse)
+}
+
+func (s *serialTx) Exec(query string, args ...any) (sql.Result, error) {
+	return s.ExecContext(context.Background(), query, args...)
+}
+
+func (s *serialTx) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	s.lock.lock(query, s.report)
+	defer s.lock.unlock()
+	return s.tx.ExecContext(ctx, query, args...)
+}
+
+func (s *serialTx) Prepare(query string) (*sql.Stmt, error) {
+	return s.PrepareContext(context.Background(), query)
+}
+
+// PrepareContext returns ErrSerializedPrepare, since the returned *sql.Stmt would run outside of the lock
+func (s *serialTx) PrepareContext(ctx context.Context, query string) (*sql.Stmt, error) {
+	return nil, fmt.Errorf("%w: %s", ErrSerializedPrepare, query)
+}
+
+func (s *serialTx) Query(query string, args ...any) (*sql.Rows, error) {
+	return s.QueryContext(context.Background(), query, args...)
+}
+
+// QueryContext returns the rows of query read into memory.
+// The values are the ones the driver returned, and the rows carry no column types.
+func (s *serialTx) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	buf, err := s.buffer(ctx, query, args, -1)
+	if err != nil {
+		return nil, err
+	}
+	return bufferDB.QueryContext(ctx, "", buf)
+}
+
+func (s *serialTx) QueryRow(query string, args ...any) *sql.Row {
+	return s.QueryRowContext(context.Background(), query, args...)
+}
+
+func (s *serialTx) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	buf, err := s.buffer(ctx, query, args, 1)
+	if err != nil {
+		buf = &bufferedRows{err: err}
+	}
+	return bufferDB.QueryRowContext(ctx, "", buf)
+}
+
+// buffer reads up to limit rows of query, all of them when limit is negative
+func (s *serialTx) buffer(ctx context.Context, query string, args []any, limit int) (*bufferedRows, error) {
+	s.lock.lock(query, s.report)
+	defer s.lock.unlock()
+
+	rows, err := s.tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	buf := &bufferedRows{columns: columns}
+	for limit < 0 || len(buf.rows) < limit {
+		if !rows.Next() {
+			buf.err = rows.Err()
+			break
+		}
+		values := make([]any, len(columns))
+		ptrs := make([]any, len(columns))
+		for i := range values {
+			ptrs[i] = &values[i]
+		}
+		if err := rows.Scan(ptrs...); err != nil {
+			return nil, err
+		}
+		buf.rows = append(buf.rows, values)
+	}
+	return buf, nil
+}
+
+// bufferDB serves rows read into memory as *sql.Rows, the only way to build one.
+// The buffered rows are passed as the single argument of the query.
+// It is a process-wide database over a fake driver connection, opened once and never closed,
+// so the rows of every serialized transaction come from it rather than from the database of the transaction:
+// they report no column types, and the statistics of that database do not count them.
+var bufferDB = sql.OpenDB(bufferConnector{})
+
+type bufferConnector struct{}
+
+func (bufferConnector) Connect(context.Context) (driver.Conn, error) {
+	return bufferConn{}, nil
+}
+
+func (bufferConnector) Driver() driver.Driver {
+	return bufferDriver{}
+}
+
+type bufferDriver struct{}
+
+func (bufferDriver) Open(string) (driver.Conn, error) {
+	return bufferConn{}, nil
+}
+
+var errBufferConn = errors.New("buffered rows only support queries")
+
+type bufferConn struct{}
+
+func (bufferConn) Prepare(string) (driver.Stmt, error) {
+	return nil, errBufferConn
+}
+
+func (bufferConn) Close() error {
+	return nil
+}
+
+func (bufferConn) Begin() (driver.Tx, error) {
+	return nil, errBufferConn
+}
+
+// CheckNamedValue passes the buffered rows through unconverted
+func (bufferConn) CheckNamedValue(*driver.NamedValue) error {
+	return nil
+}
+
+func (bufferConn) QueryContext(_ context.Context, _ string, args []driver.NamedValue) (driver.Rows, error) {
+	buf := args[0].Value.(*bufferedRows)
+	if buf.columns == nil && buf.err != nil {
+		return nil, buf.err
+	}
+	return &bufferRows{buf: buf}, nil
+}
+
+// bufferedRows is a result set read into memory, with the error that ended the read if any
+type bufferedRows struct {
+	columns []string
+	rows    [][]any
+	err     error
+}
+
+type bufferRows struct {
+	buf  *bufferedRows
+	next int
+}
+
+func (r *bufferRows) Columns() []string {
+	return r.buf.columns
+}
+
+func (r *bufferRows) Close() error {
+	return nil
+}
+
+func (r *bufferRows) Next(dest []driver.Value) error {
+	if r.next == len(r.buf.rows) {
+		if r.buf.err != nil {
+			return r.buf.err
+		}
+		return io.EOF
+	}
+	for i, v := range r.buf.rows[r.next] {
+		dest[i] = v
+	}
+	r.next++
+	return nil
+}
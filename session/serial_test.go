@@ -0,0 +1,173 @@
+package session
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func openSerialTestDB(t *testing.T) *sql.DB {
+	db, err := sql.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	db.SetMaxOpenConns(1)
+	_, err = db.Exec(`CREATE TABLE models (id TEXT PRIMARY KEY, n INTEGER, data BLOB)`)
+	require.NoError(t, err)
+	return db
+}
+
+func TestSerializedTx_concurrentWorkers(t *testing.T) {
+	db := openSerialTestDB(t)
+	wrapper := NewDB(db, WithSerializedTx())
+
+	err := NewSession(db).WithTransaction(context.Background(), func(ctx context.Context) error {
+		var wg sync.WaitGroup
+		errs := make(chan error, 8)
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				executor := wrapper.GetDB(ctx)
+				rows, err := executor.QueryContext(ctx, "SELECT id FROM models")
+				if err != nil {
+					errs <- err
+					return
+				}
+				defer rows.Close()
+				// the rows are still open while the worker writes
+				if _, err := executor.ExecContext(ctx, "INSERT INTO models (id, n) VALUES (?, ?)", fmt.Sprint(i), i); err != nil {
+					errs <- err
+					return
+				}
+				for rows.Next() {
+					var id string
+					if err := rows.Scan(&id); err != nil {
+						errs <- err
+						return
+					}
+				}
+				errs <- rows.Err()
+			}(i)
+		}
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	require.NoError(t, err)
+
+	var count int
+	require.NoError(t, db.QueryRow("SELECT COUNT(*) FROM models").Scan(&count))
+	assert.Equal(t, 8, count)
+}
+
+func TestSerializedTx_bufferedRows(t *testing.T) {
+	db := openSerialTestDB(t)
+	wrapper := NewDB(db, WithSerializedTx())
+
+	err := NewSession(db).WithTransaction(context.Background(), func(ctx context.Context) error {
+		executor := wrapper.GetDB(ctx)
+		_, err := executor.Exec("INSERT INTO models (id, n, data) VALUES (?, ?, ?), (?, NULL, NULL)", "a", 1, []byte("blob"), "b")
+		require.NoError(t, err)
+
+		rows, err := executor.Query("SELECT id, n, data FROM models ORDER BY id")
+		require.NoError(t, err)
+		columns, err := rows.Columns()
+		require.NoError(t, err)
+		assert.Equal(t, []string{"id", "n", "data"}, columns)
+
+		var ids []string
+		for rows.Next() {
+			var id string
+			var n sql.NullInt64
+			var data []byte
+			require.NoError(t, rows.Scan(&id, &n, &data))
+			ids = append(ids, id)
+			if id == "a" {
+				assert.Equal(t, int64(1), n.Int64)
+				assert.Equal(t, []byte("blob"), data)
+			} else {
+				assert.False(t, n.Valid)
+				assert.Nil(t, data)
+			}
+		}
+		require.NoError(t, rows.Err())
+		assert.Equal(t, []string{"a", "b"}, ids)
+
+		var id string
+		assert.NoError(t, executor.QueryRow("SELECT id FROM models ORDER BY id").Scan(&id))
+		assert.Equal(t, "a", id)
+		assert.ErrorIs(t, executor.QueryRow("SELECT id FROM models WHERE id = ?", "c").Scan(&id), sql.ErrNoRows)
+		assert.Error(t, executor.QueryRow("SELECT id FROM missing").Scan(&id))
+		_, err = executor.Query("SELECT id FROM missing")
+		assert.Error(t, err)
+		return nil
+	})
+	require.NoError(t, err)
+}
+
+func TestSerializedTx_concurrentUseReported(t *testing.T) {
+	db := openSerialTestDB(t)
+	reports := make(chan ConcurrentUse, 1)
+	wrapper := NewDB(db, WithConcurrentUseReport(func(use ConcurrentUse) {
+		reports <- use
+	}))
+
+	err := NewSession(db).WithTransaction(context.Background(), func(ctx context.Context) error {
+		lock := &Current(ctx).root().conn
+		lock.lock("SELECT held", func(ConcurrentUse) {})
+
+		done := make(chan error)
+		go func() {
+			_, err := wrapper.GetDB(ctx).ExecContext(ctx, "INSERT INTO models (id) VALUES (?)", "waiting")
+			done <- err
+		}()
+
+		use := <-reports
+		assert.Equal(t, "INSERT INTO models (id) VALUES (?)", use.Query)
+		assert.Equal(t, "SELECT held", use.HolderQuery)
+		assert.Contains(t, string(use.Stack), "TestSerializedTx_concurrentUseReported")
+		assert.Contains(t, string(use.HolderStack), "TestSerializedTx_concurrentUseReported")
+
+		lock.unlock()
+		return <-done
+	})
+	require.NoError(t, err)
+}
+
+func TestSerializedTx_guarded(t *testing.T) {
+	db := openSerialTestDB(t)
+	wrapper := NewDB(db, WithSerializedTx(), WithTxGuard())
+
+	var executor Executor
+	err := NewSession(db).WithTransaction(context.Background(), func(ctx context.Context) error {
+		executor = wrapper.GetDB(ctx)
+		_, err := executor.Exec("INSERT INTO models (id) VALUES (?)", "a")
+		return err
+	})
+	require.NoError(t, err)
+
+	_, err = executor.Query("SELECT id FROM models")
+	assert.ErrorIs(t, err, ErrTxFinished)
+}
+
+func TestSerializedTx_prepareRejected(t *testing.T) {
+	db := openSerialTestDB(t)
+	wrapper := NewDB(db, WithSerializedTx())
+
+	err := NewSession(db).WithTransaction(context.Background(), func(ctx context.Context) error {
+		stmt, err := wrapper.GetDB(ctx).PrepareContext(ctx, "SELECT id FROM models")
+		assert.Nil(t, stmt)
+		return err
+	})
+	assert.ErrorIs(t, err, ErrSerializedPrepare)
+}
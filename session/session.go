@@ -3,57 +3,400 @@ package session
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"runtime/debug"
+	"runtime/pprof"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
 )
 
+// Session runs functions in transactions.
+//
+// The interface grew the variadic TxOption parameter of WithTransaction and the Shutdown method,
+// which breaks implementations outside of this module: they must add both, and may ignore
+// the options they do not support, as sessionfake documents for its own.
 type Session interface {
-	WithTransaction(ctx context.Context, f func(ctx context.Context) error) error
+	WithTransaction(ctx context.Context, f func(ctx context.Context) error, opts ...TxOption) error
+	Shutdown(ctx context.Context) error
 }
 
-func NewSession(db *sql.DB) Session {
-	return &session{db: db}
+// Option configures a session
+type Option func(*session)
+
+// WithRecoveryLog sets the log receiving enlisted resources whose commit failed
+// after the SQL transaction committed
+func WithRecoveryLog(log RecoveryLog) Option {
+	return func(s *session) {
+		s.recoveryLog = log
+	}
+}
+
+// WithDefaultMaxDuration rolls back the transactions of the session with ErrTxTimeout once they ran for d,
+// unless a call sets WithMaxDuration
+func WithDefaultMaxDuration(d time.Duration) Option {
+	return func(s *session) {
+		s.maxDuration = d
+	}
+}
+
+// WithRegistry lists the transactions of the session in r while they are in progress,
+// and labels the goroutines running them with their ID for pprof
+func WithRegistry(r *Registry) Option {
+	return func(s *session) {
+		s.registry = r
+	}
+}
+
+// WithLimiter makes the outermost transactions of the session wait for permits of l before they begin
+func WithLimiter(l *Limiter) Option {
+	return func(s *session) {
+		s.limiter = l
+	}
+}
+
+// WithDefaultBudget bounds the statements of the new transactions of the session,
+// filling the zero limits of the budget a call sets WithBudget
+func WithDefaultBudget(b Budget) Option {
+	return func(s *session) {
+		s.budget = b
+	}
+}
+
+// WithWatchdog registers the transactions of the session with w,
+// recording the stack that began them and their last statement.
+// The statements are recorded by the executors of this package and of the sqlx adapter,
+// by the databases opened with WrapDriver, and by gorm databases with the Interceptors plugin.
+func WithWatchdog(w *Watchdog) Option {
+	return func(s *session) {
+		s.watchdog = w
+	}
+}
+
+// WithRouting routes the statements that dbs, opened with WrapDriver or Connector, run with the context
+// of a transaction of the session to that transaction. It panics when a database was opened otherwise.
+// A session running on a database opened with WrapDriver or Connector routes the statements of that database
+// without it.
+func WithRouting(dbs ...*sql.DB) Option {
+	return func(s *session) {
+		for _, db := range dbs {
+			source, ok := routeSourceOf(db)
+			if !ok {
+				panic("session: WithRouting given a database not opened with WrapDriver or Connector")
+			}
+			s.routes = append(s.routes, source)
+		}
+	}
+}
+
+func NewSession(db *sql.DB, opts ...Option) Session {
+	s := &session{db: db}
+	if source, ok := routeSourceOf(db); ok {
+		s.routes = append(s.routes, source)
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
 }
 
 type session struct {
-	db *sql.DB
+	db          *sql.DB
+	recoveryLog RecoveryLog
+	maxDuration time.Duration
+	watchdog    *Watchdog
+	registry    *Registry
+	limiter     *Limiter
+	budget      Budget
+	// routes are the databases opened with WrapDriver whose statements are routed to the transactions
+	routes []routeSource
+
+	mu       sync.Mutex
+	closed   bool
+	inflight map[*inflightTx]struct{}
+	// drained is closed once the session is closed and no transaction is in progress
+	drained chan struct{}
 }
 
 // WithTransaction runs the function f in a transaction.
-// If a transaction is already in progress, it will be used instead of starting a new one.
+// If a transaction is already in progress, it will be used instead of starting a new one,
+// unless the propagation option asks for a savepoint or a new transaction.
 // If a transaction is not in progress, it will start a new one.
 // If the function f returns an error, the transaction will be rolled back.
 // If the function f returns nil, the transaction will be committed.
-func (s *session) WithTransaction(ctx context.Context, f func(ctx context.Context) error) error {
-	tx, ok := GetTx(ctx).(*sql.Tx)
-	if ok && tx != nil {
-		return f(ctx)
+// Resources enlisted during f are prepared before the commit, committed after it
+// and rolled back whenever the transaction does not commit.
+// A transaction marked with SetRollbackOnly rolls back and returns ErrRollbackOnly even though f succeeded.
+// A new transaction running past its maximum duration has its context cancelled, rolls back and returns ErrTxTimeout.
+// With a Limiter, a call starting a transaction outside of one in progress first waits for its permits.
+// Once the session is shut down, calls that would start a transaction outside of one in progress return ErrSessionClosed.
+func (s *session) WithTransaction(ctx context.Context, f func(ctx context.Context) error, opts ...TxOption) error {
+	cfg := NewTxConfig(opts...)
+	if cfg.MaxDuration == 0 {
+		cfg.MaxDuration = s.maxDuration
+	}
+
+	if st := getState(ctx); st != nil {
+		switch st.propagation(cfg.Propagation) {
+		case PropagationRequired:
+			return f(ctx)
+		case PropagationNested:
+			return s.withSavepoint(ctx, st, f, cfg.Budget)
+		}
+	}
+	cfg.Budget = cfg.Budget.orDefault(s.budget)
+
+	if s.limiter != nil && getState(ctx) == nil {
+		release, err := s.limiter.acquire(ctx, cfg.ReadOnly, cfg.Weight)
+		if err != nil {
+			return err
+		}
+		defer release()
+	}
+
+	retryable := cfg.Retryable
+	if retryable == nil {
+		retryable = IsRetryable
+	}
+	for attempt := 1; ; attempt++ {
+		err := s.withNewTransaction(ctx, f, cfg)
+		if err == nil || attempt >= cfg.Attempts || !retryable(err) || ctx.Err() != nil || s.isClosed() {
+			return err
+		}
+	}
+}
+
+func (s *session) withNewTransaction(ctx context.Context, f func(ctx context.Context) error, cfg TxConfig) error {
+	// database/sql rolls the transaction back as soon as its context is done,
+	// which Shutdown and the maximum duration rely on
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	inflight := &inflightTx{cancel: cancel}
+	if err := s.begin(inflight, getState(ctx) == nil); err != nil {
+		return err
+	}
+	defer s.end(inflight)
+
+	if cfg.MaxDuration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeoutCause(ctx, cfg.MaxDuration, ErrTxTimeout)
+		defer cancel()
+	}
+	// aborted returns ErrTxTimeout or ErrSessionClosed when the transaction was cancelled by either, nil otherwise
+	aborted := func() error {
+		cause := context.Cause(ctx)
+		if errors.Is(cause, ErrTxTimeout) || errors.Is(cause, ErrSessionClosed) {
+			return cause
+		}
+		return nil
 	}
 
-	tx, err := s.db.BeginTx(ctx, nil)
+	// a database opened through WrapDriver would otherwise begin in a savepoint of the transaction in ctx
+	opts := &sql.TxOptions{Isolation: cfg.Isolation, ReadOnly: cfg.ReadOnly}
+	tx, err := s.db.BeginTx(context.WithValue(ctx, newTxKey{}, true), opts)
 	if err != nil {
 		return fmt.Errorf("failed to begin transaction: %w", err)
 	}
-	ctx = WithTx(ctx, tx)
+	st := &TxState{
+		tx:        tx,
+		db:        s.db,
+		id:        newTxID(),
+		startedAt: time.Now(),
+		config:    cfg,
+		budget:    cfg.Budget,
+		routes:    s.routes,
+	}
+	st.budgeted.Store(cfg.Budget != Budget{})
+	ctx = withState(ctx, st)
+	s.started(inflight, st)
+	if s.watchdog != nil || s.registry != nil {
+		st.tracked = true
+		st.stack = debug.Stack()
+	}
+	if s.watchdog != nil {
+		s.watchdog.add(st)
+		defer s.watchdog.remove(st)
+	}
+	if s.registry != nil {
+		s.registry.add(st)
+		defer s.registry.remove(st)
+	}
 
 	defer func() {
 		if p := recover(); p != nil {
 			if rbErr := tx.Rollback(); rbErr != nil {
 				fmt.Printf("rollback error during panic: %v\n", rbErr)
 			}
+			if rbErr := rollbackResources(ctx, st.resources); rbErr != nil {
+				fmt.Printf("resource rollback error during panic: %v\n", rbErr)
+			}
+			st.end(ctx, st.afterRollback)
 			panic(p)
 		}
 	}()
 
-	err = f(ctx)
+	if s.registry != nil {
+		pprof.Do(ctx, pprof.Labels("tx_id", st.id), func(ctx context.Context) {
+			err = f(ctx)
+		})
+	} else {
+		err = f(ctx)
+	}
+	if cause := aborted(); cause != nil && !errors.Is(err, cause) {
+		err = abortError(cause, err)
+	}
+	if err == nil && st.RollbackOnly() {
+		err = ErrRollbackOnly
+	}
+	if err == nil {
+		if prepErr := prepareResources(ctx, st.resources); prepErr != nil {
+			err = fmt.Errorf("resource prepare error: %w", prepErr)
+		}
+	}
 	if err != nil {
-		if rbErr := tx.Rollback(); rbErr != nil {
-			return fmt.Errorf("rollback error: %w (original error: %v)", rbErr, err)
+		defer st.end(ctx, st.afterRollback)
+		// an aborted or cancelled transaction was already rolled back by database/sql
+		rbErr := tx.Rollback()
+		if errors.Is(rbErr, sql.ErrTxDone) && (aborted() != nil || ctx.Err() != nil) {
+			rbErr = nil
+		}
+		// the resources are rolled back even when the SQL rollback failed, and with a cancelled ctx
+		resErr := rollbackResources(context.WithoutCancel(ctx), st.resources)
+		if rbErr != nil {
+			return fmt.Errorf("rollback error: %w (original error: %v)", errors.Join(rbErr, resErr), err)
+		}
+		if resErr != nil {
+			return fmt.Errorf("resource rollback error: %w (original error: %v)", resErr, err)
 		}
 		return fmt.Errorf("transaction failed: %w", err)
 	}
 
 	if err := tx.Commit(); err != nil {
+		defer st.end(ctx, st.afterRollback)
+		if cause := aborted(); cause != nil {
+			err = abortError(cause, err)
+		}
+		if rbErr := rollbackResources(ctx, st.resources); rbErr != nil {
+			return fmt.Errorf("commit error: %w (resource rollback error: %v)", err, rbErr)
+		}
 		return fmt.Errorf("commit error: %w", err)
 	}
+	defer st.end(ctx, st.afterCommit)
+	return commitResources(ctx, st.resources, s.recoveryLog)
+}
+
+// abortError wraps the error an aborted transaction ended with in the cause of the abort
+func abortError(cause, err error) error {
+	if err == nil {
+		return cause
+	}
+	return fmt.Errorf("%w: %w", cause, err)
+}
+
+// runHooks runs the functions registered with AfterCommit or AfterRollback,
+// with a context that outlives the cancellation of ctx
+func runHooks(ctx context.Context, hooks []func(ctx context.Context)) {
+	ctx = context.WithoutCancel(ctx)
+	for _, hook := range hooks {
+		hook(ctx)
+	}
+}
+
+// withSavepoint runs f in a savepoint of the transaction in progress.
+// If f returns an error or panics, only the changes made since the savepoint are rolled back,
+// together with the resources enlisted during f.
+func (s *session) withSavepoint(ctx context.Context, parent *TxState, f func(ctx context.Context) error, budget Budget) error {
+	st, mark := parent.savepoint(budget)
+	name := "session_sp_" + strconv.Itoa(st.depth)
+	root := st.root()
+
+	if _, err := st.tx.ExecContext(ctx, "SAVEPOINT "+name); err != nil {
+		return fmt.Errorf("failed to create savepoint: %w", err)
+	}
+	ctx = withState(ctx, st)
+	root.nesting.Add(1)
+	defer root.nesting.Add(-1)
+
+	rollback := func() error {
+		rbCtx := context.WithoutCancel(ctx)
+		_, err := st.tx.ExecContext(rbCtx, "ROLLBACK TO SAVEPOINT "+name)
+		if err == nil {
+			_, err = st.tx.ExecContext(rbCtx, "RELEASE SAVEPOINT "+name)
+		}
+		return errors.Join(err, root.rollbackTo(rbCtx, mark))
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			if rbErr := rollback(); rbErr != nil {
+				fmt.Printf("savepoint rollback error during panic: %v\n", rbErr)
+			}
+			panic(p)
+		}
+	}()
+
+	err := f(ctx)
+	if err == nil {
+		err = st.budgetErr()
+	}
+	if err != nil {
+		if rbErr := rollback(); rbErr != nil {
+			return fmt.Errorf("savepoint rollback error: %w (original error: %v)", rbErr, err)
+		}
+		return err
+	}
+
+	if _, err := st.tx.ExecContext(ctx, "RELEASE SAVEPOINT "+name); err != nil {
+		return fmt.Errorf("failed to release savepoint: %w", err)
+	}
 	return nil
 }
+
+// savepointMark holds the lengths of the lists of the root when a savepoint began,
+// which rolling back to the savepoint truncates them to
+type savepointMark struct {
+	enlisted, committing, rollingBack int
+}
+
+// savepoint returns the state of a savepoint of st, and the mark to roll the root back to
+func (st *TxState) savepoint(budget Budget) (*TxState, savepointMark) {
+	sp := &TxState{
+		tx:       st.tx,
+		parent:   st,
+		depth:    st.depth + 1,
+		external: st.external,
+		nested:   st.nested,
+		budget:   budget,
+	}
+	root := st.root()
+	if budget != (Budget{}) && !root.budgeted.Swap(true) {
+		// the executors converted so far from the transaction do not enforce budgets
+		root.handles.Store(nil)
+	}
+	root.mu.Lock()
+	defer root.mu.Unlock()
+	return sp, savepointMark{
+		enlisted:    len(root.resources),
+		committing:  len(root.afterCommit),
+		rollingBack: len(root.afterRollback),
+	}
+}
+
+// rollbackTo rolls back the resources enlisted in the root st since mark and runs the AfterRollback functions
+// registered since, dropping them together with the AfterCommit functions
+func (st *TxState) rollbackTo(ctx context.Context, mark savepointMark) error {
+	st.mu.Lock()
+	resources := st.resources[mark.enlisted:]
+	hooks := slices.Clone(st.afterRollback[mark.rollingBack:])
+	st.resources = st.resources[:mark.enlisted]
+	st.afterCommit = st.afterCommit[:mark.committing]
+	st.afterRollback = st.afterRollback[:mark.rollingBack]
+	st.mu.Unlock()
+	err := rollbackResources(ctx, resources)
+	if !st.external {
+		runHooks(ctx, hooks)
+	}
+	return err
+}
@@ -3,10 +3,12 @@ package session
 import (
 	"context"
 	"database/sql"
+	"database/sql/driver"
 	"errors"
+	"path/filepath"
 	"testing"
 
-	_ "github.com/mattn/go-sqlite3"
+	"github.com/mattn/go-sqlite3"
 	"github.com/stretchr/testify/suite"
 )
 
@@ -173,6 +175,338 @@ func (s *SessionTestSuite) TestWithTransaction_panicRecovery() {
 	s.Equal(0, count)
 }
 
+func (s *SessionTestSuite) TestWithTransaction_nestedSavepointRolledBack() {
+	err := s.session.WithTransaction(context.Background(), func(ctx context.Context) error {
+		_, err := s.db.GetDB(ctx).Exec("INSERT INTO models (id) VALUES (?)", "test-outer")
+		s.NoError(err)
+
+		err = s.session.WithTransaction(ctx, func(ctx context.Context) error {
+			_, err := s.db.GetDB(ctx).Exec("INSERT INTO models (id) VALUES (?)", "test-savepoint")
+			s.NoError(err)
+			return errors.New("rollback savepoint")
+		}, WithPropagation(PropagationNested))
+		s.Error(err)
+		return nil
+	})
+
+	s.NoError(err)
+
+	var ids []string
+	rows, err := s.sqlDB.Query("SELECT id FROM models")
+	s.Require().NoError(err)
+	defer rows.Close()
+	for rows.Next() {
+		var id string
+		s.NoError(rows.Scan(&id))
+		ids = append(ids, id)
+	}
+	s.Equal([]string{"test-outer"}, ids)
+}
+
+func (s *SessionTestSuite) TestWithTransaction_nestedSavepointCommitted() {
+	err := s.session.WithTransaction(context.Background(), func(ctx context.Context) error {
+		return s.session.WithTransaction(ctx, func(ctx context.Context) error {
+			_, err := s.db.GetDB(ctx).Exec("INSERT INTO models (id) VALUES (?)", "test-savepoint")
+			return err
+		}, WithPropagation(PropagationNested))
+	})
+
+	s.NoError(err)
+
+	var count int
+	err = s.sqlDB.QueryRow("SELECT COUNT(*) FROM models").Scan(&count)
+	s.NoError(err)
+	s.Equal(1, count)
+}
+
+func (s *SessionTestSuite) TestWithTransaction_nestedSavepointWithoutTransaction() {
+	err := s.session.WithTransaction(context.Background(), func(ctx context.Context) error {
+		s.NotNil(GetTx(ctx))
+		return nil
+	}, WithPropagation(PropagationNested))
+
+	s.NoError(err)
+}
+
+func (s *SessionTestSuite) TestWithTransaction_requiresNew() {
+	db, err := sql.Open("sqlite3", "file:"+filepath.Join(s.T().TempDir(), "test.db")+"?_busy_timeout=1000")
+	s.Require().NoError(err)
+	defer db.Close()
+	_, err = db.Exec(`CREATE TABLE models (id TEXT PRIMARY KEY)`)
+	s.Require().NoError(err)
+	sess := NewSession(db)
+
+	err = sess.WithTransaction(context.Background(), func(ctx context.Context) error {
+		outerTx := GetTx(ctx)
+		err := sess.WithTransaction(ctx, func(ctx context.Context) error {
+			s.NotEqual(outerTx, GetTx(ctx))
+			_, err := db.Exec("SELECT 1")
+			return err
+		}, WithPropagation(PropagationRequiresNew))
+		s.NoError(err)
+		return errors.New("rollback outer")
+	})
+
+	s.Error(err)
+}
+
+func (s *SessionTestSuite) TestWithTransaction_externalTxNeverCommitted() {
+	tx, err := s.sqlDB.Begin()
+	s.Require().NoError(err)
+	ctx := WithExternalTx(context.Background(), tx, PropagationNested)
+
+	err = s.session.WithTransaction(ctx, func(ctx context.Context) error {
+		s.Equal(tx, GetTx(ctx))
+		_, err := s.db.GetDB(ctx).Exec("INSERT INTO models (id) VALUES (?)", "test-external")
+		return err
+	}, WithPropagation(PropagationRequiresNew))
+	s.NoError(err)
+
+	err = s.session.WithTransaction(ctx, func(ctx context.Context) error {
+		_, err := s.db.GetDB(ctx).Exec("INSERT INTO models (id) VALUES (?)", "test-external-failed")
+		s.NoError(err)
+		return errors.New("rollback savepoint")
+	})
+	s.Error(err)
+
+	var count int
+	s.NoError(tx.QueryRow("SELECT COUNT(*) FROM models").Scan(&count))
+	s.Equal(1, count)
+
+	s.NoError(tx.Rollback())
+	s.NoError(s.sqlDB.QueryRow("SELECT COUNT(*) FROM models").Scan(&count))
+	s.Equal(0, count)
+}
+
+func (s *SessionTestSuite) TestWithTransaction_state() {
+	err := s.session.WithTransaction(context.Background(), func(ctx context.Context) error {
+		st := Current(ctx)
+		s.Require().NotNil(st)
+		s.Equal(GetTx(ctx), st.Tx())
+		s.Equal(s.sqlDB, st.DB())
+		s.False(st.External())
+		s.False(st.StartedAt().IsZero())
+		s.Equal(sql.LevelSerializable, st.Options().Isolation)
+		s.Equal(0, Depth(ctx))
+		id := TxID(ctx)
+		s.NotEmpty(id)
+
+		return s.session.WithTransaction(ctx, func(ctx context.Context) error {
+			s.Equal(1, Depth(ctx))
+			s.Equal(id, TxID(ctx))
+			s.Equal(s.sqlDB, Current(ctx).DB())
+			return nil
+		}, WithPropagation(PropagationNested))
+	}, WithIsolation(sql.LevelSerializable))
+
+	s.NoError(err)
+}
+
+func (s *SessionTestSuite) TestWithTransaction_rollbackOnly() {
+	err := s.session.WithTransaction(context.Background(), func(ctx context.Context) error {
+		_, err := s.db.GetDB(ctx).Exec("INSERT INTO models (id) VALUES (?)", "test-rollback-only")
+		s.NoError(err)
+		return s.session.WithTransaction(ctx, func(ctx context.Context) error {
+			return SetRollbackOnly(ctx)
+		})
+	})
+
+	s.ErrorIs(err, ErrRollbackOnly)
+	var count int
+	s.NoError(s.sqlDB.QueryRow("SELECT COUNT(*) FROM models").Scan(&count))
+	s.Equal(0, count)
+}
+
+func (s *SessionTestSuite) TestWithTransaction_hooks() {
+	var calls []string
+	hook := func(name string) func(context.Context) {
+		return func(ctx context.Context) {
+			s.NoError(ctx.Err())
+			calls = append(calls, name)
+		}
+	}
+
+	err := s.session.WithTransaction(context.Background(), func(ctx context.Context) error {
+		s.NoError(AfterCommit(ctx, hook("commit")))
+		s.NoError(AfterRollback(ctx, hook("rollback")))
+
+		err := s.session.WithTransaction(ctx, func(ctx context.Context) error {
+			s.NoError(AfterCommit(ctx, hook("savepoint commit")))
+			s.NoError(AfterRollback(ctx, hook("savepoint rollback")))
+			return errors.New("rollback savepoint")
+		}, WithPropagation(PropagationNested))
+		s.Error(err)
+		s.Equal([]string{"savepoint rollback"}, calls)
+		return nil
+	})
+	s.NoError(err)
+	s.Equal([]string{"savepoint rollback", "commit"}, calls)
+
+	calls = nil
+	err = s.session.WithTransaction(context.Background(), func(ctx context.Context) error {
+		s.NoError(AfterCommit(ctx, hook("commit")))
+		s.NoError(AfterRollback(ctx, hook("rollback")))
+		return errors.New("rollback")
+	})
+	s.Error(err)
+	s.Equal([]string{"rollback"}, calls)
+}
+
+func (s *SessionTestSuite) TestWithTransaction_txGuard() {
+	db := NewDB(s.sqlDB, WithTxGuard())
+	var executor Executor
+	err := s.session.WithTransaction(context.Background(), func(ctx context.Context) error {
+		executor = db.GetDB(ctx)
+		_, err := executor.ExecContext(ctx, "INSERT INTO models (id) VALUES (?)", "test-guard")
+		return err
+	})
+	s.Require().NoError(err)
+
+	_, err = executor.Exec("INSERT INTO models (id) VALUES (?)", "test-guard-late")
+	s.ErrorIs(err, ErrTxFinished)
+	_, err = executor.Query("SELECT id FROM models")
+	s.ErrorIs(err, ErrTxFinished)
+
+	tx, err := s.sqlDB.Begin()
+	s.Require().NoError(err)
+	executor = db.GetDB(WithTx(context.Background(), tx))
+	s.NoError(tx.Rollback())
+	_, err = executor.Exec("INSERT INTO models (id) VALUES (?)", "test-guard-external")
+	s.ErrorIs(err, ErrTxFinished)
+	s.ErrorIs(err, sql.ErrTxDone)
+}
+
+func (s *SessionTestSuite) TestTxGuard() {
+	s.NoError(NewTxGuard(nil).Check())
+
+	var guard TxGuard
+	err := s.session.WithTransaction(context.Background(), func(ctx context.Context) error {
+		guard = NewTxGuard(Current(ctx))
+		return guard.Check()
+	})
+	s.Require().NoError(err)
+	s.ErrorIs(guard.Check(), ErrTxFinished)
+
+	s.ErrorIs(guard.Wrap(sql.ErrTxDone), ErrTxFinished)
+	s.ErrorIs(guard.Wrap(sql.ErrTxDone), sql.ErrTxDone)
+	s.Equal(sql.ErrNoRows, guard.Wrap(sql.ErrNoRows))
+}
+
+func (s *SessionTestSuite) TestWithTransaction_detached() {
+	err := s.session.WithTransaction(context.Background(), func(ctx context.Context) error {
+		_, err := s.db.GetDB(ctx).Exec("INSERT INTO models (id) VALUES (?)", "test-tx")
+		s.NoError(err)
+		s.Equal(s.sqlDB, s.db.GetDB(Detach(ctx)))
+		return errors.New("rollback")
+	})
+	s.Error(err)
+}
+
+func (s *SessionTestSuite) TestWithTransaction_retry() {
+	attempts := 0
+	err := s.session.WithTransaction(context.Background(), func(ctx context.Context) error {
+		attempts++
+		_, err := s.db.GetDB(ctx).Exec("INSERT INTO models (id) VALUES (?)", "test-retry")
+		s.Require().NoError(err)
+		if attempts < 3 {
+			return errors.New("database is locked")
+		}
+		return nil
+	}, WithRetry(3))
+
+	s.NoError(err)
+	s.Equal(3, attempts)
+	var count int
+	s.NoError(s.sqlDB.QueryRow("SELECT COUNT(*) FROM models").Scan(&count))
+	s.Equal(1, count)
+}
+
+func (s *SessionTestSuite) TestWithTransaction_retryExhausted() {
+	attempts := 0
+	err := s.session.WithTransaction(context.Background(), func(ctx context.Context) error {
+		attempts++
+		return errors.New("deadlock detected")
+	}, WithRetry(2))
+
+	s.Error(err)
+	s.Equal(2, attempts)
+}
+
+func (s *SessionTestSuite) TestWithTransaction_retryOnlyRetryableErrors() {
+	attempts := 0
+	err := s.session.WithTransaction(context.Background(), func(ctx context.Context) error {
+		attempts++
+		return errors.New("insufficient funds")
+	}, WithRetry(3))
+	s.Error(err)
+	s.Equal(1, attempts)
+
+	attempts = 0
+	err = s.session.WithTransaction(context.Background(), func(ctx context.Context) error {
+		attempts++
+		return errors.New("insufficient funds")
+	}, WithRetry(3), WithRetryIf(func(err error) bool { return true }))
+	s.Error(err)
+	s.Equal(3, attempts)
+}
+
+func (s *SessionTestSuite) TestWithTransaction_joinedCallNotRetried() {
+	attempts := 0
+	err := s.session.WithTransaction(context.Background(), func(ctx context.Context) error {
+		return s.session.WithTransaction(ctx, func(ctx context.Context) error {
+			attempts++
+			return errors.New("database is locked")
+		}, WithRetry(3))
+	})
+
+	s.Error(err)
+	s.Equal(1, attempts)
+}
+
+// txOptionsConnector records the options of the transactions begun on its sqlite connections
+type txOptionsConnector struct {
+	driver sqlite3.SQLiteDriver
+	opts   []driver.TxOptions
+}
+
+func (c *txOptionsConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.driver.Open(":memory:")
+	if err != nil {
+		return nil, err
+	}
+	return &txOptionsConn{Conn: conn, c: c}, nil
+}
+
+func (c *txOptionsConnector) Driver() driver.Driver {
+	return &c.driver
+}
+
+type txOptionsConn struct {
+	driver.Conn
+	c *txOptionsConnector
+}
+
+func (c *txOptionsConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	c.c.opts = append(c.c.opts, opts)
+	return c.Conn.(driver.ConnBeginTx).BeginTx(ctx, opts)
+}
+
+func (s *SessionTestSuite) TestWithTransaction_txOptions() {
+	connector := &txOptionsConnector{}
+	db := sql.OpenDB(connector)
+	defer db.Close()
+	sess := NewSession(db)
+
+	err := sess.WithTransaction(context.Background(), func(ctx context.Context) error {
+		return sess.WithTransaction(ctx, func(ctx context.Context) error {
+			return nil
+		}, WithIsolation(sql.LevelReadCommitted))
+	}, WithIsolation(sql.LevelSerializable), WithReadOnly())
+
+	s.NoError(err)
+	s.Equal([]driver.TxOptions{{Isolation: driver.IsolationLevel(sql.LevelSerializable), ReadOnly: true}}, connector.opts)
+}
+
 func TestSessionTestSuite(t *testing.T) {
 	suite.Run(t, new(SessionTestSuite))
 }
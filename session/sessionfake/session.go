@@ -0,0 +1,322 @@
+// Package sessionfake provides in-memory fakes of session.Session and session.DBWrapper
+// for unit tests that should not need a database.
+package sessionfake
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/aeramu/sql-transaction/session"
+	"github.com/aeramu/sql-transaction/session/internal/faketx"
+)
+
+// Outcome is how a WithTransaction call ended
+type Outcome int
+
+const (
+	// OutcomeCommitted is a transaction or savepoint that committed
+	OutcomeCommitted Outcome = iota
+	// OutcomeRolledBack is a transaction or savepoint rolled back because f returned an error
+	OutcomeRolledBack
+	// OutcomeJoined is a call that ran inside the transaction in progress
+	OutcomeJoined
+	// OutcomePanicked is a transaction or savepoint rolled back because f panicked
+	OutcomePanicked
+	// OutcomeBeginFailed is a call whose transaction could not be started
+	OutcomeBeginFailed
+	// OutcomeCommitFailed is a transaction whose commit failed
+	OutcomeCommitFailed
+	// OutcomeRollbackFailed is a transaction whose rollback failed
+	OutcomeRollbackFailed
+	// OutcomeClosed is a call refused because the session was shut down
+	OutcomeClosed
+)
+
+func (o Outcome) String() string {
+	switch o {
+	case OutcomeCommitted:
+		return "committed"
+	case OutcomeRolledBack:
+		return "rolled_back"
+	case OutcomeJoined:
+		return "joined"
+	case OutcomePanicked:
+		return "panicked"
+	case OutcomeBeginFailed:
+		return "begin_failed"
+	case OutcomeCommitFailed:
+		return "commit_failed"
+	case OutcomeRollbackFailed:
+		return "rollback_failed"
+	case OutcomeClosed:
+		return "closed"
+	}
+	return "unknown"
+}
+
+// Tx is the fake transaction carried by the context inside Session.WithTransaction
+type Tx struct {
+	// ID numbers the transactions started by the session, from 1
+	ID int
+	// Savepoint is set for a nested call run in a savepoint of the transaction
+	Savepoint bool
+	// Depth is 0 for a transaction and increases with every savepoint
+	Depth  int
+	Config session.TxConfig
+}
+
+// Call is a recorded WithTransaction call
+type Call struct {
+	Config session.TxConfig
+	// InTransaction tells whether the context already carried a transaction
+	InTransaction bool
+	// Tx is the transaction the function ran in, nil when the begin failed
+	Tx      *Tx
+	Outcome Outcome
+	Err     error
+}
+
+type txKey struct{}
+
+// txValue ties the fake transaction to the session state it was begun with
+type txValue struct {
+	tx *Tx
+	st *session.TxState
+}
+
+// TxFromContext returns the fake transaction carried by ctx, or nil
+func TxFromContext(ctx context.Context) *Tx {
+	v, ok := ctx.Value(txKey{}).(txValue)
+	if !ok || v.st != session.Current(ctx) {
+		// session.WithoutTx or Detach dropped the transaction
+		return nil
+	}
+	return v.tx
+}
+
+// InTransaction tells whether ctx carries a fake transaction
+func InTransaction(ctx context.Context) bool {
+	return TxFromContext(ctx) != nil
+}
+
+// Session is a fake session.Session recording every WithTransaction call.
+// Failures at begin, commit or rollback can be scripted with the Fail methods.
+type Session struct {
+	mu           sync.Mutex
+	calls        []Call
+	txs          int
+	beginErrs    []error
+	commitErrs   []error
+	rollbackErrs []error
+	closed       bool
+}
+
+var _ session.Session = (*Session)(nil)
+
+func New() *Session {
+	return &Session{}
+}
+
+// FailBegin makes the next transaction fail to begin with err
+func (s *Session) FailBegin(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.beginErrs = append(s.beginErrs, err)
+}
+
+// FailCommit makes the next commit fail with err
+func (s *Session) FailCommit(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.commitErrs = append(s.commitErrs, err)
+}
+
+// FailRollback makes the next rollback fail with err
+func (s *Session) FailRollback(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rollbackErrs = append(s.rollbackErrs, err)
+}
+
+func (s *Session) next(errs *[]error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(*errs) == 0 {
+		return nil
+	}
+	err := (*errs)[0]
+	*errs = (*errs)[1:]
+	return err
+}
+
+func (s *Session) record(c Call) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls = append(s.calls, c)
+}
+
+// WithTransaction runs f with a fake transaction in the context, following the propagation
+// of the real session, and returns the same errors the real session would.
+// The context carries a real session.TxState, so session.SetRollbackOnly,
+// session.AfterCommit, session.AfterRollback, session.Enlist, session.CheckReadOnly and session.EnforceBudget
+// work as with the real session.
+// Of the options, only the propagation, ReadOnly and Budget have an effect: the isolation level,
+// retries, the maximum duration and the limiter weight are recorded in Call.Config but ignored.
+func (s *Session) WithTransaction(ctx context.Context, f func(ctx context.Context) error, opts ...session.TxOption) error {
+	cfg := session.NewTxConfig(opts...)
+	call := Call{Config: cfg}
+
+	parent := TxFromContext(ctx)
+	call.InTransaction = parent != nil
+	if parent == nil && s.Closed() {
+		call.Outcome = OutcomeClosed
+		call.Err = session.ErrSessionClosed
+		s.record(call)
+		return call.Err
+	}
+	if parent != nil && cfg.Propagation == session.PropagationRequired {
+		call.Tx = parent
+		call.Outcome = OutcomeJoined
+		call.Err = f(ctx)
+		s.record(call)
+		return call.Err
+	}
+
+	if parent != nil && cfg.Propagation == session.PropagationNested {
+		call.Tx = &Tx{ID: parent.ID, Savepoint: true, Depth: parent.Depth + 1, Config: cfg}
+	} else {
+		if err := s.next(&s.beginErrs); err != nil {
+			call.Outcome = OutcomeBeginFailed
+			call.Err = fmt.Errorf("failed to begin transaction: %w", err)
+			s.record(call)
+			return call.Err
+		}
+		s.mu.Lock()
+		s.txs++
+		call.Tx = &Tx{ID: s.txs, Config: cfg}
+		s.mu.Unlock()
+		// a new transaction is independent of the one carried by ctx
+		ctx = session.WithoutTx(ctx)
+	}
+	ctx, tx := faketx.Begin(ctx, cfg)
+	ctx = context.WithValue(ctx, txKey{}, txValue{tx: call.Tx, st: session.Current(ctx)})
+
+	defer func() {
+		if p := recover(); p != nil {
+			_ = tx.Rollback(ctx)
+			call.Outcome = OutcomePanicked
+			call.Err = fmt.Errorf("panic: %v", p)
+			s.record(call)
+			panic(p)
+		}
+	}()
+
+	err := f(ctx)
+	if err == nil {
+		err = tx.Prepare(ctx)
+	}
+	if err != nil {
+		call.Outcome = OutcomeRolledBack
+		call.Err = err
+		if !call.Tx.Savepoint {
+			call.Err = fmt.Errorf("transaction failed: %w", err)
+		}
+		rbErr := s.next(&s.rollbackErrs)
+		if resErr := tx.Rollback(ctx); resErr != nil {
+			rbErr = errors.Join(rbErr, resErr)
+		}
+		if rbErr != nil {
+			call.Outcome = OutcomeRollbackFailed
+			call.Err = fmt.Errorf("rollback error: %w (original error: %v)", rbErr, err)
+		}
+		s.record(call)
+		return call.Err
+	}
+
+	call.Outcome = OutcomeCommitted
+	if !call.Tx.Savepoint {
+		if cErr := s.next(&s.commitErrs); cErr != nil {
+			call.Outcome = OutcomeCommitFailed
+			call.Err = fmt.Errorf("commit error: %w", cErr)
+			_ = tx.Rollback(ctx)
+			s.record(call)
+			return call.Err
+		}
+	}
+	call.Err = tx.Commit(ctx)
+	s.record(call)
+	return call.Err
+}
+
+// Shutdown makes the calls outside of a transaction fail with session.ErrSessionClosed.
+// The fake runs transactions synchronously, so there is nothing to wait for.
+func (s *Session) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	return nil
+}
+
+// Closed tells whether Shutdown was called since the last Reset
+func (s *Session) Closed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closed
+}
+
+// Calls returns the recorded calls in the order they finished
+func (s *Session) Calls() []Call {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Call(nil), s.calls...)
+}
+
+// Count returns how many calls ended with outcome, not counting savepoints
+func (s *Session) Count(outcome Outcome) int {
+	var n int
+	for _, c := range s.Calls() {
+		if c.Outcome == outcome && (c.Tx == nil || !c.Tx.Savepoint) {
+			n++
+		}
+	}
+	return n
+}
+
+// Reset forgets the recorded calls and the scripted failures, and reopens the session
+func (s *Session) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls = nil
+	s.txs = 0
+	s.beginErrs = nil
+	s.commitErrs = nil
+	s.rollbackErrs = nil
+	s.closed = false
+}
+
+// AssertCommitted fails the test unless exactly n transactions committed
+func (s *Session) AssertCommitted(tb testing.TB, n int) {
+	tb.Helper()
+	if got := s.Count(OutcomeCommitted); got != n {
+		tb.Errorf("sessionfake: got %d committed transactions, want %d (calls: %v)", got, n, s.Calls())
+	}
+}
+
+// AssertRolledBack fails the test unless exactly n transactions rolled back because of an error
+func (s *Session) AssertRolledBack(tb testing.TB, n int) {
+	tb.Helper()
+	if got := s.Count(OutcomeRolledBack); got != n {
+		tb.Errorf("sessionfake: got %d rolled back transactions, want %d (calls: %v)", got, n, s.Calls())
+	}
+}
+
+// AssertInTransaction fails the test unless ctx carries a fake transaction
+func AssertInTransaction(tb testing.TB, ctx context.Context) {
+	tb.Helper()
+	if !InTransaction(ctx) {
+		tb.Errorf("sessionfake: expected to run inside a transaction")
+	}
+}
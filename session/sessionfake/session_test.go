@@ -0,0 +1,280 @@
+package sessionfake
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+
+	"github.com/aeramu/sql-transaction/session"
+)
+
+type SessionTestSuite struct {
+	suite.Suite
+	session *Session
+}
+
+func (s *SessionTestSuite) SetupTest() {
+	s.session = New()
+}
+
+func (s *SessionTestSuite) TestWithTransaction_committed() {
+	err := s.session.WithTransaction(context.Background(), func(ctx context.Context) error {
+		AssertInTransaction(s.T(), ctx)
+		s.Equal(1, TxFromContext(ctx).ID)
+		return nil
+	})
+
+	s.NoError(err)
+	s.session.AssertCommitted(s.T(), 1)
+	s.session.AssertRolledBack(s.T(), 0)
+	calls := s.session.Calls()
+	s.Require().Len(calls, 1)
+	s.False(calls[0].InTransaction)
+	s.Equal(OutcomeCommitted, calls[0].Outcome)
+}
+
+func (s *SessionTestSuite) TestWithTransaction_rolledBack() {
+	expectedErr := errors.New("business error")
+	err := s.session.WithTransaction(context.Background(), func(ctx context.Context) error {
+		return expectedErr
+	})
+
+	s.ErrorIs(err, expectedErr)
+	s.session.AssertRolledBack(s.T(), 1)
+	s.session.AssertCommitted(s.T(), 0)
+}
+
+func (s *SessionTestSuite) TestWithTransaction_joined() {
+	err := s.session.WithTransaction(context.Background(), func(ctx context.Context) error {
+		outer := TxFromContext(ctx)
+		return s.session.WithTransaction(ctx, func(ctx context.Context) error {
+			s.Same(outer, TxFromContext(ctx))
+			return nil
+		})
+	})
+
+	s.NoError(err)
+	calls := s.session.Calls()
+	s.Require().Len(calls, 2)
+	s.Equal(OutcomeJoined, calls[0].Outcome)
+	s.True(calls[0].InTransaction)
+	s.Equal(OutcomeCommitted, calls[1].Outcome)
+	s.session.AssertCommitted(s.T(), 1)
+}
+
+func (s *SessionTestSuite) TestWithTransaction_savepointRecorded() {
+	err := s.session.WithTransaction(context.Background(), func(ctx context.Context) error {
+		err := s.session.WithTransaction(ctx, func(ctx context.Context) error {
+			tx := TxFromContext(ctx)
+			s.True(tx.Savepoint)
+			s.Equal(1, tx.Depth)
+			return errors.New("savepoint error")
+		}, session.WithPropagation(session.PropagationNested))
+		s.Error(err)
+		return nil
+	})
+
+	s.NoError(err)
+	calls := s.session.Calls()
+	s.Require().Len(calls, 2)
+	s.Equal(session.PropagationNested, calls[0].Config.Propagation)
+	s.Equal(OutcomeRolledBack, calls[0].Outcome)
+	s.session.AssertCommitted(s.T(), 1)
+	s.session.AssertRolledBack(s.T(), 0)
+}
+
+func (s *SessionTestSuite) TestWithTransaction_requiresNew() {
+	err := s.session.WithTransaction(context.Background(), func(ctx context.Context) error {
+		return s.session.WithTransaction(ctx, func(ctx context.Context) error {
+			s.Equal(2, TxFromContext(ctx).ID)
+			return nil
+		}, session.WithPropagation(session.PropagationRequiresNew))
+	})
+
+	s.NoError(err)
+	s.session.AssertCommitted(s.T(), 2)
+}
+
+func (s *SessionTestSuite) TestWithTransaction_beginFailure() {
+	beginErr := errors.New("too many connections")
+	s.session.FailBegin(beginErr)
+
+	called := false
+	err := s.session.WithTransaction(context.Background(), func(ctx context.Context) error {
+		called = true
+		return nil
+	})
+
+	s.ErrorIs(err, beginErr)
+	s.False(called)
+	s.Equal(1, s.session.Count(OutcomeBeginFailed))
+
+	s.NoError(s.session.WithTransaction(context.Background(), func(ctx context.Context) error { return nil }))
+}
+
+func (s *SessionTestSuite) TestWithTransaction_commitFailure() {
+	commitErr := errors.New("serialization failure")
+	s.session.FailCommit(commitErr)
+
+	err := s.session.WithTransaction(context.Background(), func(ctx context.Context) error {
+		return nil
+	})
+
+	s.ErrorIs(err, commitErr)
+	s.Equal(1, s.session.Count(OutcomeCommitFailed))
+	s.session.AssertCommitted(s.T(), 0)
+}
+
+func (s *SessionTestSuite) TestWithTransaction_rollbackFailure() {
+	rollbackErr := errors.New("connection lost")
+	s.session.FailRollback(rollbackErr)
+
+	err := s.session.WithTransaction(context.Background(), func(ctx context.Context) error {
+		return errors.New("business error")
+	})
+
+	s.ErrorIs(err, rollbackErr)
+	s.Equal(1, s.session.Count(OutcomeRollbackFailed))
+}
+
+func (s *SessionTestSuite) TestWithTransaction_panic() {
+	s.Panics(func() {
+		_ = s.session.WithTransaction(context.Background(), func(ctx context.Context) error {
+			panic("test panic")
+		})
+	})
+
+	s.Equal(1, s.session.Count(OutcomePanicked))
+}
+
+func (s *SessionTestSuite) TestWithTransaction_sessionState() {
+	err := s.session.WithTransaction(context.Background(), func(ctx context.Context) error {
+		s.True(session.InTransaction(ctx))
+		s.NotEmpty(session.TxID(ctx))
+		s.Nil(session.GetTx(ctx))
+		s.False(InTransaction(session.WithoutTx(ctx)))
+		return nil
+	})
+	s.NoError(err)
+}
+
+func (s *SessionTestSuite) TestWithTransaction_rollbackOnly() {
+	err := s.session.WithTransaction(context.Background(), func(ctx context.Context) error {
+		return session.SetRollbackOnly(ctx)
+	})
+
+	s.ErrorIs(err, session.ErrRollbackOnly)
+	s.session.AssertRolledBack(s.T(), 1)
+}
+
+func (s *SessionTestSuite) TestWithTransaction_hooks() {
+	var ran []string
+	hook := func(name string) func(context.Context) {
+		return func(context.Context) { ran = append(ran, name) }
+	}
+	err := s.session.WithTransaction(context.Background(), func(ctx context.Context) error {
+		s.NoError(session.AfterCommit(ctx, hook("commit")))
+		err := s.session.WithTransaction(ctx, func(ctx context.Context) error {
+			s.NoError(session.AfterCommit(ctx, hook("savepoint commit")))
+			s.NoError(session.AfterRollback(ctx, hook("savepoint rollback")))
+			return errors.New("savepoint error")
+		}, session.WithPropagation(session.PropagationNested))
+		s.Error(err)
+		return nil
+	})
+	s.NoError(err)
+	s.Equal([]string{"savepoint rollback", "commit"}, ran)
+
+	ran = nil
+	s.session.FailCommit(errors.New("commit failed"))
+	err = s.session.WithTransaction(context.Background(), func(ctx context.Context) error {
+		s.NoError(session.AfterCommit(ctx, hook("commit")))
+		return session.AfterRollback(ctx, hook("rollback"))
+	})
+	s.Error(err)
+	s.Equal([]string{"rollback"}, ran)
+}
+
+// stepResource records the steps it was driven through
+type stepResource struct {
+	steps []string
+}
+
+func (r *stepResource) Prepare(ctx context.Context) error {
+	r.steps = append(r.steps, "prepare")
+	return nil
+}
+
+func (r *stepResource) Commit(ctx context.Context) error {
+	r.steps = append(r.steps, "commit")
+	return nil
+}
+
+func (r *stepResource) Rollback(ctx context.Context) error {
+	r.steps = append(r.steps, "rollback")
+	return nil
+}
+
+func (s *SessionTestSuite) TestWithTransaction_enlist() {
+	committed, rolledBack := &stepResource{}, &stepResource{}
+	s.NoError(s.session.WithTransaction(context.Background(), func(ctx context.Context) error {
+		return session.Enlist(ctx, committed)
+	}))
+	s.Error(s.session.WithTransaction(context.Background(), func(ctx context.Context) error {
+		s.NoError(session.Enlist(ctx, rolledBack))
+		return errors.New("business error")
+	}))
+
+	s.Equal([]string{"prepare", "commit"}, committed.steps)
+	s.Equal([]string{"rollback"}, rolledBack.steps)
+}
+
+func (s *SessionTestSuite) TestWithTransaction_options() {
+	budget := session.Budget{MaxStatements: 3}
+	err := s.session.WithTransaction(context.Background(), func(ctx context.Context) error {
+		s.ErrorIs(session.CheckReadOnly(ctx, "DELETE FROM orders"), session.ErrReadOnlyViolation)
+		s.Equal(budget, session.Current(ctx).Budget())
+		return nil
+	}, session.WithReadOnly(), session.WithBudget(budget))
+	s.NoError(err)
+}
+
+func (s *SessionTestSuite) TestShutdown() {
+	s.NoError(s.session.WithTransaction(context.Background(), func(ctx context.Context) error {
+		s.NoError(s.session.Shutdown(context.Background()))
+		// the transaction in progress still runs nested calls
+		return s.session.WithTransaction(ctx, func(ctx context.Context) error { return nil },
+			session.WithPropagation(session.PropagationRequiresNew))
+	}))
+
+	err := s.session.WithTransaction(context.Background(), func(ctx context.Context) error {
+		s.Fail("function run after shutdown")
+		return nil
+	})
+
+	s.ErrorIs(err, session.ErrSessionClosed)
+	s.Equal(1, s.session.Count(OutcomeClosed))
+	s.session.AssertCommitted(s.T(), 2)
+	s.True(s.session.Closed())
+	s.session.Reset()
+	s.False(s.session.Closed())
+}
+
+func (s *SessionTestSuite) TestReset() {
+	s.session.FailCommit(errors.New("commit error"))
+	s.Error(s.session.WithTransaction(context.Background(), func(ctx context.Context) error {
+		return errors.New("business error")
+	}))
+
+	s.session.Reset()
+
+	s.Empty(s.session.Calls())
+	s.NoError(s.session.WithTransaction(context.Background(), func(ctx context.Context) error { return nil }))
+	s.session.AssertCommitted(s.T(), 1)
+}
+
+func TestSessionTestSuite(t *testing.T) {
+	suite.Run(t, new(SessionTestSuite))
+}
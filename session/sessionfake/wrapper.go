@@ -0,0 +1,26 @@
+package sessionfake
+
+import (
+	"context"
+
+	"github.com/aeramu/sql-transaction/session"
+)
+
+// DBWrapper is a fake session.DBWrapper returning caller-supplied handles
+// depending on the fake transaction carried by the context
+type DBWrapper[T any] struct {
+	// DB is returned outside of a transaction
+	DB T
+	// Tx returns the handle used inside tx. When nil, DB is returned in transactions too.
+	Tx func(tx *Tx) T
+}
+
+var _ session.DBWrapper[any] = (*DBWrapper[any])(nil)
+
+func (w *DBWrapper[T]) GetDB(ctx context.Context) T {
+	tx := TxFromContext(ctx)
+	if tx == nil || w.Tx == nil {
+		return w.DB
+	}
+	return w.Tx(tx)
+}
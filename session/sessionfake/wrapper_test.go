@@ -0,0 +1,41 @@
+package sessionfake
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type handle struct {
+	name string
+}
+
+func TestDBWrapper_GetDB(t *testing.T) {
+	pool := &handle{name: "pool"}
+	w := &DBWrapper[*handle]{
+		DB: pool,
+		Tx: func(tx *Tx) *handle {
+			return &handle{name: "tx"}
+		},
+	}
+	s := New()
+
+	assert.Same(t, pool, w.GetDB(context.Background()))
+	err := s.WithTransaction(context.Background(), func(ctx context.Context) error {
+		assert.Equal(t, "tx", w.GetDB(ctx).name)
+		return nil
+	})
+	assert.NoError(t, err)
+}
+
+func TestDBWrapper_GetDBWithoutTxHandle(t *testing.T) {
+	pool := &handle{name: "pool"}
+	w := &DBWrapper[*handle]{DB: pool}
+
+	err := New().WithTransaction(context.Background(), func(ctx context.Context) error {
+		assert.Same(t, pool, w.GetDB(ctx))
+		return nil
+	})
+	assert.NoError(t, err)
+}
@@ -0,0 +1,119 @@
+package sessionfault
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+
+	"github.com/aeramu/sql-transaction/session/internal/driverwrap"
+)
+
+// OpenDB opens a database through the driver d with the rules of the injector applied
+// to every connection
+func (in *Injector) OpenDB(d driver.Driver, dsn string) *sql.DB {
+	return sql.OpenDB(in.Connector(d, dsn))
+}
+
+// Connector returns a connector opening connections of d with the rules of the injector applied
+func (in *Injector) Connector(d driver.Driver, dsn string) driver.Connector {
+	return &driverwrap.Connector{Inner: d, DSN: dsn, Wrap: func(conn driver.Conn) driver.Conn {
+		return &faultConn{in: in, Conn: driverwrap.Conn{Conn: conn}}
+	}}
+}
+
+// faultConn applies the rules before delegating to the wrapped connection.
+// Statements on drivers without the context interfaces fall back to prepared statements,
+// which apply the rules themselves.
+type faultConn struct {
+	in *Injector
+	driverwrap.Conn
+}
+
+func (c *faultConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if err := c.in.inject(ctx, OpBegin, ""); err != nil {
+		return nil, err
+	}
+
+	tx, err := c.Conn.BeginTx(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &faultTx{in: c.in, tx: tx}, nil
+}
+
+func (c *faultConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	if err := c.in.inject(ctx, OpPrepare, query); err != nil {
+		return nil, err
+	}
+
+	stmt, err := c.Conn.PrepareContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	return &faultStmt{in: c.in, query: query, Stmt: driverwrap.Stmt{Stmt: stmt}}, nil
+}
+
+func (c *faultConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	ec, ok := c.Conn.Conn.(driver.ExecerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	if err := c.in.inject(ctx, OpExec, query); err != nil {
+		return nil, err
+	}
+	return ec.ExecContext(ctx, query, args)
+}
+
+func (c *faultConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	qc, ok := c.Conn.Conn.(driver.QueryerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	if err := c.in.inject(ctx, OpQuery, query); err != nil {
+		return nil, err
+	}
+	return qc.QueryContext(ctx, query, args)
+}
+
+// faultTx applies the commit and rollback rules.
+// An injected commit failure rolls back the wrapped transaction, so the connection is left clean.
+type faultTx struct {
+	in *Injector
+	tx driver.Tx
+}
+
+func (t *faultTx) Commit() error {
+	if err := t.in.inject(context.Background(), OpCommit, ""); err != nil {
+		return errors.Join(err, t.tx.Rollback())
+	}
+	return t.tx.Commit()
+}
+
+func (t *faultTx) Rollback() error {
+	err := t.tx.Rollback()
+	if injErr := t.in.inject(context.Background(), OpRollback, ""); injErr != nil {
+		return injErr
+	}
+	return err
+}
+
+type faultStmt struct {
+	in    *Injector
+	query string
+	driverwrap.Stmt
+}
+
+func (s *faultStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	if err := s.in.inject(ctx, OpExec, s.query); err != nil {
+		return nil, err
+	}
+	return s.Stmt.ExecContext(ctx, args)
+}
+
+func (s *faultStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	if err := s.in.inject(ctx, OpQuery, s.query); err != nil {
+		return nil, err
+	}
+	return s.Stmt.QueryContext(ctx, args)
+}
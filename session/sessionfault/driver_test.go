@@ -0,0 +1,144 @@
+package sessionfault
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aeramu/sql-transaction/session"
+	"github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/suite"
+)
+
+type DriverTestSuite struct {
+	suite.Suite
+	in      *Injector
+	db      *sql.DB
+	session session.Session
+	wrapper session.DBWrapper[session.Executor]
+}
+
+func TestDriver(t *testing.T) {
+	suite.Run(t, new(DriverTestSuite))
+}
+
+func (s *DriverTestSuite) SetupTest() {
+	s.in = New()
+	s.db = s.in.OpenDB(&sqlite3.SQLiteDriver{}, "file:"+filepath.Join(s.T().TempDir(), "fault.db")+"?_busy_timeout=5000")
+	s.session = session.NewSession(s.db)
+	s.wrapper = session.NewDB(s.db)
+
+	_, err := s.db.Exec("CREATE TABLE orders (id INTEGER PRIMARY KEY)")
+	s.Require().NoError(err)
+}
+
+func (s *DriverTestSuite) TearDownTest() {
+	s.db.Close()
+}
+
+func (s *DriverTestSuite) count() int {
+	var n int
+	s.Require().NoError(s.db.QueryRow("SELECT COUNT(*) FROM orders").Scan(&n))
+	return n
+}
+
+func (s *DriverTestSuite) insertOrders(ctx context.Context, n int) error {
+	for i := 1; i <= n; i++ {
+		if _, err := s.wrapper.GetDB(ctx).ExecContext(ctx, "INSERT INTO orders (id) VALUES (?)", i); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *DriverTestSuite) TestFailNthExec() {
+	errInjected := errors.New("injected")
+	s.in.Add(FailNth(OpExec, `INSERT INTO orders`, 3, errInjected))
+
+	err := s.session.WithTransaction(context.Background(), func(ctx context.Context) error {
+		return s.insertOrders(ctx, 5)
+	})
+	s.ErrorIs(err, errInjected)
+	s.Equal(0, s.count())
+
+	err = s.session.WithTransaction(context.Background(), func(ctx context.Context) error {
+		return s.insertOrders(ctx, 5)
+	})
+	s.NoError(err, "the rule only applies to the 3rd match")
+	s.Equal(5, s.count())
+}
+
+func (s *DriverTestSuite) TestFailCommit() {
+	s.in.Add(Fail(OpCommit, driver.ErrBadConn))
+
+	err := s.session.WithTransaction(context.Background(), func(ctx context.Context) error {
+		return s.insertOrders(ctx, 2)
+	})
+	s.ErrorIs(err, driver.ErrBadConn)
+	s.Equal(0, s.count())
+
+	s.in.Reset()
+	err = s.session.WithTransaction(context.Background(), func(ctx context.Context) error {
+		return s.insertOrders(ctx, 2)
+	})
+	s.NoError(err)
+	s.Equal(2, s.count())
+}
+
+func (s *DriverTestSuite) TestFailRollback() {
+	errInjected := errors.New("injected")
+	errRollback := errors.New("rollback")
+	s.in.Add(Fail(OpRollback, errInjected))
+
+	err := s.session.WithTransaction(context.Background(), func(ctx context.Context) error {
+		if err := s.insertOrders(ctx, 1); err != nil {
+			return err
+		}
+		return errRollback
+	})
+	s.ErrorIs(err, errInjected)
+	s.Equal(0, s.count(), "the transaction is rolled back even though the rollback reports an error")
+}
+
+func (s *DriverTestSuite) TestDelayBegin() {
+	s.in.Add(Delay(OpBegin, 50*time.Millisecond))
+
+	start := time.Now()
+	err := s.session.WithTransaction(context.Background(), func(ctx context.Context) error {
+		return nil
+	})
+	s.NoError(err)
+	s.GreaterOrEqual(time.Since(start), 50*time.Millisecond)
+}
+
+func (s *DriverTestSuite) TestDelayBeginCancelled() {
+	s.in.Add(Delay(OpBegin, time.Minute))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	err := s.session.WithTransaction(ctx, func(ctx context.Context) error {
+		return nil
+	})
+	s.ErrorIs(err, context.DeadlineExceeded)
+}
+
+func (s *DriverTestSuite) TestFailQueryRow() {
+	errInjected := errors.New("injected")
+	s.in.Add(FailNth(OpQuery, `SELECT`, 0, errInjected))
+
+	var n int
+	err := s.db.QueryRow("SELECT COUNT(*) FROM orders").Scan(&n)
+	s.ErrorIs(err, errInjected)
+}
+
+func (s *DriverTestSuite) TestFailPrepare() {
+	errInjected := errors.New("injected")
+	s.in.Add(Fail(OpPrepare, errInjected))
+
+	_, err := s.db.Prepare("INSERT INTO orders (id) VALUES (?)")
+	s.ErrorIs(err, errInjected)
+}
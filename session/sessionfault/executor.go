@@ -0,0 +1,80 @@
+package sessionfault
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/aeramu/sql-transaction/session"
+)
+
+// Executor wraps e with the exec, query and prepare rules of the injector.
+// QueryRow and QueryRowContext only honour delays, since a *sql.Row cannot carry an injected error;
+// use the driver wrapper to make them fail.
+func (in *Injector) Executor(e session.Executor) session.Executor {
+	return &executor{in: in, Executor: e}
+}
+
+// Database wraps the handles returned by db with Executor
+func (in *Injector) Database(db session.Database[session.Executor]) session.Database[session.Executor] {
+	return &database{in: in, db: db}
+}
+
+type database struct {
+	in *Injector
+	db session.Database[session.Executor]
+}
+
+func (d *database) GetDB(ctx context.Context) session.Executor {
+	return d.in.Executor(d.db.GetDB(ctx))
+}
+
+func (d *database) ConvertTx(ctx context.Context, tx *sql.Tx) session.Executor {
+	return d.in.Executor(d.db.ConvertTx(ctx, tx))
+}
+
+type executor struct {
+	in *Injector
+	session.Executor
+}
+
+func (e *executor) Exec(query string, args ...any) (sql.Result, error) {
+	return e.ExecContext(context.Background(), query, args...)
+}
+
+func (e *executor) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	if err := e.in.inject(ctx, OpExec, query); err != nil {
+		return nil, err
+	}
+	return e.Executor.ExecContext(ctx, query, args...)
+}
+
+func (e *executor) Prepare(query string) (*sql.Stmt, error) {
+	return e.PrepareContext(context.Background(), query)
+}
+
+func (e *executor) PrepareContext(ctx context.Context, query string) (*sql.Stmt, error) {
+	if err := e.in.inject(ctx, OpPrepare, query); err != nil {
+		return nil, err
+	}
+	return e.Executor.PrepareContext(ctx, query)
+}
+
+func (e *executor) Query(query string, args ...any) (*sql.Rows, error) {
+	return e.QueryContext(context.Background(), query, args...)
+}
+
+func (e *executor) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	if err := e.in.inject(ctx, OpQuery, query); err != nil {
+		return nil, err
+	}
+	return e.Executor.QueryContext(ctx, query, args...)
+}
+
+func (e *executor) QueryRow(query string, args ...any) *sql.Row {
+	return e.QueryRowContext(context.Background(), query, args...)
+}
+
+func (e *executor) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	_ = e.in.inject(ctx, OpQuery, query)
+	return e.Executor.QueryRowContext(ctx, query, args...)
+}
@@ -0,0 +1,89 @@
+package sessionfault
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aeramu/sql-transaction/session"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/suite"
+)
+
+type ExecutorTestSuite struct {
+	suite.Suite
+	in      *Injector
+	db      *sql.DB
+	session session.Session
+	wrapper session.DBWrapper[session.Executor]
+}
+
+func TestExecutor(t *testing.T) {
+	suite.Run(t, new(ExecutorTestSuite))
+}
+
+func (s *ExecutorTestSuite) SetupTest() {
+	db, err := sql.Open("sqlite3", ":memory:")
+	s.Require().NoError(err)
+	db.SetMaxOpenConns(1)
+	_, err = db.Exec("CREATE TABLE orders (id INTEGER PRIMARY KEY)")
+	s.Require().NoError(err)
+
+	s.in = New()
+	s.db = db
+	s.session = session.NewSession(db)
+	s.wrapper = session.NewDBWrapper(s.in.Database(session.NewDatabase(db)))
+}
+
+func (s *ExecutorTestSuite) TearDownTest() {
+	s.db.Close()
+}
+
+func (s *ExecutorTestSuite) count() int {
+	var n int
+	s.Require().NoError(s.db.QueryRow("SELECT COUNT(*) FROM orders").Scan(&n))
+	return n
+}
+
+func (s *ExecutorTestSuite) TestFailNthExecInTransaction() {
+	errInjected := errors.New("injected")
+	s.in.Add(FailNth(OpExec, `INSERT INTO orders`, 2, errInjected))
+
+	err := s.session.WithTransaction(context.Background(), func(ctx context.Context) error {
+		for i := 1; i <= 3; i++ {
+			if _, err := s.wrapper.GetDB(ctx).ExecContext(ctx, "INSERT INTO orders (id) VALUES (?)", i); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	s.ErrorIs(err, errInjected)
+	s.Equal(0, s.count())
+}
+
+func (s *ExecutorTestSuite) TestPatternMismatch() {
+	s.in.Add(FailNth(OpExec, `DELETE`, 0, errors.New("injected")))
+
+	_, err := s.wrapper.GetDB(context.Background()).Exec("INSERT INTO orders (id) VALUES (1)")
+	s.NoError(err)
+	s.Equal(1, s.count())
+}
+
+func (s *ExecutorTestSuite) TestFailQuery() {
+	errInjected := errors.New("injected")
+	s.in.Add(Fail(OpQuery, errInjected))
+
+	_, err := s.wrapper.GetDB(context.Background()).Query("SELECT id FROM orders")
+	s.ErrorIs(err, errInjected)
+}
+
+func (s *ExecutorTestSuite) TestDelayQueryRow() {
+	s.in.Add(Delay(OpQuery, 20*time.Millisecond))
+
+	var n int
+	start := time.Now()
+	s.NoError(s.wrapper.GetDB(context.Background()).QueryRow("SELECT COUNT(*) FROM orders").Scan(&n))
+	s.GreaterOrEqual(time.Since(start), 20*time.Millisecond)
+}
@@ -0,0 +1,119 @@
+// Package sessionfault injects failures and delays into transaction paths for chaos testing.
+// Rules are evaluated by a database/sql/driver wrapper, which works with every adapter built
+// on *sql.DB, and by an Executor decorator for the session adapter.
+package sessionfault
+
+import (
+	"context"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// Op is an operation a rule applies to
+type Op string
+
+const (
+	OpBegin    Op = "begin"
+	OpCommit   Op = "commit"
+	OpRollback Op = "rollback"
+	OpExec     Op = "exec"
+	OpQuery    Op = "query"
+	OpPrepare  Op = "prepare"
+)
+
+// Rule describes a fault injected into the matching operations
+type Rule struct {
+	Op Op
+	// Pattern restricts the rule to statements matching it. Nil matches every statement.
+	Pattern *regexp.Regexp
+	// Nth restricts the rule to the nth matching operation, counted from 1. Zero applies it to every one.
+	Nth int
+	// Delay is waited before the operation runs, or until its context is done
+	Delay time.Duration
+	// Err is returned instead of running the operation
+	Err error
+}
+
+// Fail returns a rule failing every op with err
+func Fail(op Op, err error) Rule {
+	return Rule{Op: op, Err: err}
+}
+
+// FailNth returns a rule failing the nth op whose statement matches pattern
+func FailNth(op Op, pattern string, n int, err error) Rule {
+	return Rule{Op: op, Pattern: regexp.MustCompile(pattern), Nth: n, Err: err}
+}
+
+// Delay returns a rule delaying every op by d
+func Delay(op Op, d time.Duration) Rule {
+	return Rule{Op: op, Delay: d}
+}
+
+type rule struct {
+	Rule
+	matched int
+}
+
+// Injector holds the rules applied by the wrappers built from it.
+// Rules can be added and reset while the wrappers are in use.
+type Injector struct {
+	mu    sync.Mutex
+	rules []*rule
+}
+
+func New(rules ...Rule) *Injector {
+	in := &Injector{}
+	for _, r := range rules {
+		in.Add(r)
+	}
+	return in
+}
+
+// Add appends a rule
+func (in *Injector) Add(r Rule) {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	in.rules = append(in.rules, &rule{Rule: r})
+}
+
+// Reset removes every rule
+func (in *Injector) Reset() {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	in.rules = nil
+}
+
+// inject applies the rules matching op and query.
+// It waits for their delays and returns the error of the first failing rule.
+func (in *Injector) inject(ctx context.Context, op Op, query string) error {
+	var delay time.Duration
+	var err error
+
+	in.mu.Lock()
+	for _, r := range in.rules {
+		if r.Op != op || (r.Pattern != nil && !r.Pattern.MatchString(query)) {
+			continue
+		}
+		r.matched++
+		if r.Nth != 0 && r.matched != r.Nth {
+			continue
+		}
+		delay += r.Delay
+		if err == nil {
+			err = r.Err
+		}
+	}
+	in.mu.Unlock()
+
+	if delay > 0 {
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+	return err
+}
@@ -0,0 +1,161 @@
+package sessionhttp
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/aeramu/sql-transaction/session"
+)
+
+// errRollback is returned from the transaction function when the handler
+// responded with a status that must not be committed.
+var errRollback = errors.New("sessionhttp: handler responded with error status")
+
+// Option configures the transaction middleware
+type Option func(*config)
+
+type config struct {
+	skip                 func(r *http.Request) bool
+	errorHandler         func(w http.ResponseWriter, r *http.Request, err error)
+	rollbackErrorHandler func(r *http.Request, err error)
+	commitErrorHandler   func(r *http.Request, err error)
+	txOptions            []session.TxOption
+}
+
+// SkipMethods disables the transaction for requests with one of the given methods.
+// By default GET, HEAD and OPTIONS requests are skipped.
+func SkipMethods(methods ...string) Option {
+	set := methodSet(methods)
+	return func(c *config) {
+		c.skip = func(r *http.Request) bool {
+			return set[r.Method]
+		}
+	}
+}
+
+// OnlyMethods enables the transaction only for requests with one of the given methods.
+func OnlyMethods(methods ...string) Option {
+	set := methodSet(methods)
+	return func(c *config) {
+		c.skip = func(r *http.Request) bool {
+			return !set[r.Method]
+		}
+	}
+}
+
+// WithSkipper sets a custom predicate deciding which requests run without a transaction.
+func WithSkipper(skip func(r *http.Request) bool) Option {
+	return func(c *config) {
+		c.skip = skip
+	}
+}
+
+// WithErrorHandler sets the handler used to respond when the transaction could not
+// be started or committed and no response has been flushed to the client yet.
+// The default handler responds with 500 Internal Server Error.
+func WithErrorHandler(h func(w http.ResponseWriter, r *http.Request, err error)) Option {
+	return func(c *config) {
+		c.errorHandler = h
+	}
+}
+
+// WithRollbackErrorHandler sets the function reporting a failed rollback after the handler responded
+// with a 4xx or 5xx status, whose response is still written.
+// By default the error is logged.
+func WithRollbackErrorHandler(h func(r *http.Request, err error)) Option {
+	return func(c *config) {
+		c.rollbackErrorHandler = h
+	}
+}
+
+// WithCommitErrorHandler sets the function reporting every transaction that failed to begin or commit,
+// including the commits failing after the handler flushed its response, when the client already got a success status.
+// By default the error is logged.
+func WithCommitErrorHandler(h func(r *http.Request, err error)) Option {
+	return func(c *config) {
+		c.commitErrorHandler = h
+	}
+}
+
+// WithTxOptions sets the options of the transaction of every request, such as its isolation level
+func WithTxOptions(opts ...session.TxOption) Option {
+	return func(c *config) {
+		c.txOptions = append(c.txOptions, opts...)
+	}
+}
+
+func methodSet(methods []string) map[string]bool {
+	set := make(map[string]bool, len(methods))
+	for _, m := range methods {
+		set[m] = true
+	}
+	return set
+}
+
+func defaultErrorHandler(w http.ResponseWriter, r *http.Request, err error) {
+	http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+}
+
+func defaultErrorLogger(r *http.Request, err error) {
+	log.Printf("sessionhttp: %s %s: %v", r.Method, r.URL.Path, err)
+}
+
+// Middleware returns a net/http middleware that runs every request in a transaction
+// started through s.WithTransaction.
+// The response is buffered until the handler returns.
+// If the handler responds with a 2xx or 3xx status, the transaction is committed.
+// If the handler responds with a 4xx or 5xx status or panics, the transaction is rolled back.
+// If the commit fails, the error goes to the commit error handler, and the error handler responds instead
+// when the response has not been flushed yet.
+// If the rollback after a 4xx or 5xx status fails, the response of the handler is still written
+// and the error goes to the rollback error handler.
+func Middleware(s session.Session, opts ...Option) func(http.Handler) http.Handler {
+	cfg := &config{
+		errorHandler:         defaultErrorHandler,
+		rollbackErrorHandler: defaultErrorLogger,
+		commitErrorHandler:   defaultErrorLogger,
+	}
+	SkipMethods(http.MethodGet, http.MethodHead, http.MethodOptions)(cfg)
+	for _, opt := range opts {
+		opt(cfg)
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if cfg.skip != nil && cfg.skip(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			bw := newBufferedWriter(w)
+			rejected := false
+			err := s.WithTransaction(r.Context(), func(ctx context.Context) error {
+				next.ServeHTTP(bw, r.WithContext(ctx))
+				if bw.status >= http.StatusBadRequest {
+					rejected = true
+					return errRollback
+				}
+				return nil
+			}, cfg.txOptions...)
+			if rejected {
+				// the response of the handler stands, whether the rollback succeeded or not
+				if err != nil && !errors.Is(err, errRollback) {
+					cfg.rollbackErrorHandler(r, err)
+				}
+				bw.flush()
+				return
+			}
+			if err != nil {
+				cfg.commitErrorHandler(r, err)
+				if !bw.flushed {
+					bw.reset()
+					cfg.errorHandler(w, r, err)
+					return
+				}
+			}
+			bw.flush()
+		})
+	}
+}
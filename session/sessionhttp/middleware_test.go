@@ -0,0 +1,244 @@
+package sessionhttp
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/suite"
+
+	"github.com/aeramu/sql-transaction/session"
+)
+
+type MiddlewareTestSuite struct {
+	suite.Suite
+	sqlDB   *sql.DB
+	db      session.DBWrapper[session.Executor]
+	session session.Session
+}
+
+func (s *MiddlewareTestSuite) SetupTest() {
+	db, err := sql.Open("sqlite3", "file::memory:?_foreign_keys=1")
+	s.Require().NoError(err)
+	db.SetMaxOpenConns(1)
+
+	_, err = db.Exec(`CREATE TABLE parents (id TEXT PRIMARY KEY)`)
+	s.Require().NoError(err)
+	_, err = db.Exec(`CREATE TABLE children (
+		id TEXT PRIMARY KEY,
+		parent_id TEXT REFERENCES parents(id) DEFERRABLE INITIALLY DEFERRED
+	)`)
+	s.Require().NoError(err)
+
+	s.sqlDB = db
+	s.db = session.NewDB(db)
+	s.session = session.NewSession(db)
+}
+
+func (s *MiddlewareTestSuite) TearDownTest() {
+	s.sqlDB.Close()
+}
+
+func (s *MiddlewareTestSuite) insertParent(status int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, err := s.db.GetDB(r.Context()).ExecContext(r.Context(), "INSERT INTO parents (id) VALUES (?)", "p1")
+		s.Require().NoError(err)
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte("done"))
+	})
+}
+
+func (s *MiddlewareTestSuite) countParents() int {
+	var count int
+	err := s.sqlDB.QueryRow("SELECT COUNT(*) FROM parents").Scan(&count)
+	s.Require().NoError(err)
+	return count
+}
+
+func (s *MiddlewareTestSuite) serve(h http.Handler, method string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(method, "/", nil))
+	return rec
+}
+
+func (s *MiddlewareTestSuite) TestMiddleware_committedOnSuccess() {
+	h := Middleware(s.session)(s.insertParent(http.StatusCreated))
+
+	rec := s.serve(h, http.MethodPost)
+
+	s.Equal(http.StatusCreated, rec.Code)
+	s.Equal("done", rec.Body.String())
+	s.Equal(1, s.countParents())
+}
+
+func (s *MiddlewareTestSuite) TestMiddleware_committedOnRedirect() {
+	h := Middleware(s.session)(s.insertParent(http.StatusSeeOther))
+
+	rec := s.serve(h, http.MethodPost)
+
+	s.Equal(http.StatusSeeOther, rec.Code)
+	s.Equal(1, s.countParents())
+}
+
+func (s *MiddlewareTestSuite) TestMiddleware_rolledBackOnClientError() {
+	h := Middleware(s.session)(s.insertParent(http.StatusConflict))
+
+	rec := s.serve(h, http.MethodPost)
+
+	s.Equal(http.StatusConflict, rec.Code)
+	s.Equal("done", rec.Body.String())
+	s.Equal(0, s.countParents())
+}
+
+func (s *MiddlewareTestSuite) TestMiddleware_rolledBackOnServerError() {
+	h := Middleware(s.session)(s.insertParent(http.StatusInternalServerError))
+
+	rec := s.serve(h, http.MethodPut)
+
+	s.Equal(http.StatusInternalServerError, rec.Code)
+	s.Equal(0, s.countParents())
+}
+
+func (s *MiddlewareTestSuite) TestMiddleware_rolledBackOnPanic() {
+	h := Middleware(s.session)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, err := s.db.GetDB(r.Context()).ExecContext(r.Context(), "INSERT INTO parents (id) VALUES (?)", "p1")
+		s.Require().NoError(err)
+		panic("handler panic")
+	}))
+
+	s.Panics(func() {
+		s.serve(h, http.MethodPost)
+	})
+	s.Equal(0, s.countParents())
+}
+
+func (s *MiddlewareTestSuite) TestMiddleware_skipsGetByDefault() {
+	h := Middleware(s.session)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.Nil(session.GetTx(r.Context()))
+	}))
+
+	rec := s.serve(h, http.MethodGet)
+
+	s.Equal(http.StatusOK, rec.Code)
+}
+
+func (s *MiddlewareTestSuite) TestMiddleware_onlyMethods() {
+	var inTx bool
+	h := Middleware(s.session, OnlyMethods(http.MethodGet))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		inTx = session.GetTx(r.Context()) != nil
+	}))
+
+	s.serve(h, http.MethodGet)
+	s.True(inTx)
+
+	s.serve(h, http.MethodPost)
+	s.False(inTx)
+}
+
+func (s *MiddlewareTestSuite) TestMiddleware_commitFailureReturns500() {
+	h := Middleware(s.session)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, err := s.db.GetDB(r.Context()).ExecContext(r.Context(), "INSERT INTO children (id, parent_id) VALUES (?, ?)", "c1", "missing")
+		s.Require().NoError(err)
+		w.Header().Set("X-Handler", "yes")
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write([]byte("created"))
+	}))
+
+	rec := s.serve(h, http.MethodPost)
+
+	s.Equal(http.StatusInternalServerError, rec.Code)
+	s.Empty(rec.Header().Get("X-Handler"))
+	s.NotContains(rec.Body.String(), "created")
+}
+
+func (s *MiddlewareTestSuite) TestMiddleware_commitFailureAfterFlush() {
+	var commitErr error
+	h := Middleware(s.session, WithCommitErrorHandler(func(r *http.Request, err error) {
+		commitErr = err
+	}))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, err := s.db.GetDB(r.Context()).ExecContext(r.Context(), "INSERT INTO children (id, parent_id) VALUES (?, ?)", "c1", "missing")
+		s.Require().NoError(err)
+		w.WriteHeader(http.StatusAccepted)
+		w.(http.Flusher).Flush()
+		_, _ = w.Write([]byte("streamed"))
+	}))
+
+	rec := s.serve(h, http.MethodPost)
+
+	s.Equal(http.StatusAccepted, rec.Code)
+	s.Equal("streamed", rec.Body.String())
+	s.Error(commitErr)
+}
+
+func (s *MiddlewareTestSuite) TestMiddleware_customErrorHandler() {
+	h := Middleware(s.session, WithErrorHandler(func(w http.ResponseWriter, r *http.Request, err error) {
+		http.Error(w, "commit failed: "+err.Error(), http.StatusServiceUnavailable)
+	}))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, err := s.db.GetDB(r.Context()).ExecContext(r.Context(), "INSERT INTO children (id, parent_id) VALUES (?, ?)", "c1", "missing")
+		s.Require().NoError(err)
+	}))
+
+	rec := s.serve(h, http.MethodPost)
+
+	s.Equal(http.StatusServiceUnavailable, rec.Code)
+	s.True(strings.HasPrefix(rec.Body.String(), "commit failed"))
+}
+
+func (s *MiddlewareTestSuite) TestMiddleware_txOptions() {
+	var readOnly bool
+	h := Middleware(s.session, WithTxOptions(session.WithReadOnly()))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		readOnly = session.Current(r.Context()).Options().ReadOnly
+	}))
+
+	s.serve(h, http.MethodPost)
+	s.True(readOnly)
+}
+
+// rollbackFailingSession runs f outside of any transaction and fails to roll back
+type rollbackFailingSession struct{}
+
+func (rollbackFailingSession) WithTransaction(ctx context.Context, f func(ctx context.Context) error, opts ...session.TxOption) error {
+	if err := f(ctx); err != nil {
+		return fmt.Errorf("rollback error: %w (original error: %v)", sql.ErrConnDone, err)
+	}
+	return nil
+}
+
+func (rollbackFailingSession) Shutdown(ctx context.Context) error {
+	return nil
+}
+
+func (s *MiddlewareTestSuite) TestMiddleware_rollbackFailureKeepsResponse() {
+	var rollbackErr error
+	h := Middleware(rollbackFailingSession{}, WithRollbackErrorHandler(func(r *http.Request, err error) {
+		rollbackErr = err
+	}))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "invalid input", http.StatusBadRequest)
+	}))
+
+	rec := s.serve(h, http.MethodPost)
+
+	s.Equal(http.StatusBadRequest, rec.Code)
+	s.Contains(rec.Body.String(), "invalid input")
+	s.True(errors.Is(rollbackErr, sql.ErrConnDone))
+}
+
+func (s *MiddlewareTestSuite) TestMiddleware_rollbackSuccessNotReported() {
+	called := false
+	h := Middleware(s.session, WithRollbackErrorHandler(func(r *http.Request, err error) {
+		called = true
+	}))(s.insertParent(http.StatusConflict))
+
+	s.serve(h, http.MethodPost)
+	s.False(called)
+}
+
+func TestMiddlewareTestSuite(t *testing.T) {
+	suite.Run(t, new(MiddlewareTestSuite))
+}
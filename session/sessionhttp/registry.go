@@ -0,0 +1,42 @@
+package sessionhttp
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/aeramu/sql-transaction/session"
+)
+
+// RegistryHandler returns a handler listing the transactions in progress in r, the oldest first.
+// It responds with JSON, or with plain text when the request has format=text in its query
+// or accepts text/plain.
+func RegistryHandler(r *session.Registry) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		snapshot := r.Snapshot()
+		if req.URL.Query().Get("format") == "text" || strings.Contains(req.Header.Get("Accept"), "text/plain") {
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			writeText(w, snapshot)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(snapshot)
+	})
+}
+
+func writeText(w http.ResponseWriter, snapshot []session.ActiveTx) {
+	fmt.Fprintf(w, "%d active transactions\n", len(snapshot))
+	for _, tx := range snapshot {
+		fmt.Fprintf(w, "\ntransaction %s: age=%s started=%s isolation=%s read_only=%t depth=%d statements=%d",
+			tx.ID, tx.Age, tx.StartedAt.Format("2006-01-02T15:04:05.000Z07:00"), tx.Isolation, tx.ReadOnly, tx.Depth, tx.Statements)
+		if tx.MaxDuration > 0 {
+			fmt.Fprintf(w, " max_duration=%s", tx.MaxDuration)
+		}
+		fmt.Fprintln(w)
+		if tx.LastStatement != "" {
+			fmt.Fprintf(w, "last statement: %s\n", tx.LastStatement)
+		}
+		fmt.Fprintf(w, "begun at:\n%s", tx.Stack)
+	}
+}
@@ -0,0 +1,58 @@
+package sessionhttp
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/aeramu/sql-transaction/session"
+)
+
+func TestRegistryHandler(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+	_, err = db.Exec(`CREATE TABLE parents (id TEXT PRIMARY KEY)`)
+	require.NoError(t, err)
+
+	registry := session.NewRegistry()
+	sess := session.NewSession(db, session.WithRegistry(registry))
+	handler := RegistryHandler(registry)
+
+	err = sess.WithTransaction(context.Background(), func(ctx context.Context) error {
+		_, err := session.NewDB(db).GetDB(ctx).ExecContext(ctx, "INSERT INTO parents (id) VALUES (?)", "p1")
+		require.NoError(t, err)
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/debug/transactions", nil))
+		assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+		var snapshot []session.ActiveTx
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &snapshot))
+		require.Len(t, snapshot, 1)
+		assert.Equal(t, session.TxID(ctx), snapshot[0].ID)
+		assert.Equal(t, "INSERT INTO parents (id) VALUES (?)", snapshot[0].LastStatement)
+
+		rec = httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/debug/transactions?format=text", nil))
+		assert.Equal(t, "text/plain; charset=utf-8", rec.Header().Get("Content-Type"))
+		assert.Contains(t, rec.Body.String(), "1 active transactions\n")
+		assert.Contains(t, rec.Body.String(), "transaction "+session.TxID(ctx)+": age=")
+		assert.Contains(t, rec.Body.String(), "last statement: INSERT INTO parents (id) VALUES (?)\n")
+		assert.Contains(t, rec.Body.String(), "TestRegistryHandler")
+		return nil
+	})
+	require.NoError(t, err)
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/debug/transactions", nil)
+	req.Header.Set("Accept", "text/plain")
+	handler.ServeHTTP(rec, req)
+	assert.Equal(t, "0 active transactions\n", rec.Body.String())
+}
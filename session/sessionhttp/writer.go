@@ -0,0 +1,92 @@
+package sessionhttp
+
+import (
+	"bytes"
+	"net/http"
+)
+
+// bufferedWriter holds the status, headers and body written by the handler
+// until the outcome of the transaction is known.
+// Calling Flush sends the buffered response to the client, after which writes pass through.
+type bufferedWriter struct {
+	w       http.ResponseWriter
+	header  http.Header
+	status  int
+	body    bytes.Buffer
+	flushed bool
+}
+
+func newBufferedWriter(w http.ResponseWriter) *bufferedWriter {
+	return &bufferedWriter{
+		w:      w,
+		header: make(http.Header),
+	}
+}
+
+func (bw *bufferedWriter) Header() http.Header {
+	if bw.flushed {
+		return bw.w.Header()
+	}
+	return bw.header
+}
+
+func (bw *bufferedWriter) WriteHeader(status int) {
+	if bw.status != 0 {
+		return
+	}
+	bw.status = status
+	if bw.flushed {
+		bw.w.WriteHeader(status)
+	}
+}
+
+func (bw *bufferedWriter) Write(p []byte) (int, error) {
+	if bw.status == 0 {
+		bw.WriteHeader(http.StatusOK)
+	}
+	if bw.flushed {
+		return bw.w.Write(p)
+	}
+	return bw.body.Write(p)
+}
+
+// Flush sends the buffered response to the client.
+// After this the status can no longer be replaced when the commit fails.
+func (bw *bufferedWriter) Flush() {
+	bw.flush()
+	if f, ok := bw.w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap allows http.ResponseController to reach the underlying writer.
+func (bw *bufferedWriter) Unwrap() http.ResponseWriter {
+	return bw.w
+}
+
+func (bw *bufferedWriter) flush() {
+	if bw.flushed {
+		return
+	}
+	bw.flushed = true
+
+	dst := bw.w.Header()
+	for k, v := range bw.header {
+		dst[k] = v
+	}
+	if bw.status == 0 {
+		bw.status = http.StatusOK
+	}
+	bw.w.WriteHeader(bw.status)
+	if bw.body.Len() > 0 {
+		_, _ = bw.w.Write(bw.body.Bytes())
+	}
+	bw.body.Reset()
+}
+
+// reset discards the buffered response.
+func (bw *bufferedWriter) reset() {
+	bw.header = make(http.Header)
+	bw.status = 0
+	bw.body.Reset()
+}
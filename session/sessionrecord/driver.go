@@ -0,0 +1,196 @@
+package sessionrecord
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"strings"
+
+	"github.com/aeramu/sql-transaction/session/internal/driverwrap"
+)
+
+// Recorder wraps database/sql drivers to record what runs through them.
+// Entries go to the transcript carried by the context of the statement or of the transaction it runs in,
+// and to the default transcript of the recorder otherwise.
+type Recorder struct {
+	transcript *Transcript
+}
+
+func New() *Recorder {
+	return &Recorder{transcript: NewTranscript()}
+}
+
+// Transcript returns the transcript receiving the entries whose context carries none
+func (r *Recorder) Transcript() *Transcript {
+	return r.transcript
+}
+
+// OpenDB opens a database through the driver d, recording every connection
+func (r *Recorder) OpenDB(d driver.Driver, dsn string) *sql.DB {
+	return sql.OpenDB(r.Connector(d, dsn))
+}
+
+// Connector returns a connector opening connections of d that are recorded
+func (r *Recorder) Connector(d driver.Driver, dsn string) driver.Connector {
+	return &driverwrap.Connector{Inner: d, DSN: dsn, Wrap: func(conn driver.Conn) driver.Conn {
+		return &recordConn{r: r, Conn: driverwrap.Conn{Conn: conn}}
+	}}
+}
+
+func (r *Recorder) transcriptFor(ctx context.Context) *Transcript {
+	if t := FromContext(ctx); t != nil {
+		return t
+	}
+	return r.transcript
+}
+
+// recordConn records the statements run on the wrapped connection.
+// database/sql uses a connection from one goroutine at a time, so tx needs no locking.
+type recordConn struct {
+	r *Recorder
+	driverwrap.Conn
+	tx *recordTx
+}
+
+// record appends a statement to the transcript of the transaction in progress on the connection,
+// or to the transcript of ctx when there is none
+func (c *recordConn) record(ctx context.Context, kind Kind, query string, args []driver.NamedValue, err error) {
+	e := Entry{Kind: kind, Query: normalize(query), Args: argValues(args), Err: err}
+	if c.tx == nil {
+		c.r.transcriptFor(ctx).record(e)
+		return
+	}
+
+	e.TxID = c.tx.id
+	if err != nil {
+		e.Depth = len(c.tx.savepoints)
+		c.tx.transcript.record(e)
+		return
+	}
+	// SAVEPOINT is recorded at the depth it is created from and RELEASE at the depth it returns to,
+	// so the statements of a savepoint are the only ones indented by it
+	fields := strings.Fields(strings.ToUpper(e.Query))
+	switch {
+	case len(fields) == 2 && fields[0] == "SAVEPOINT":
+		e.Depth = len(c.tx.savepoints)
+		c.tx.savepoints = append(c.tx.savepoints, fields[1])
+	case len(fields) >= 2 && fields[0] == "RELEASE":
+		c.tx.release(fields[len(fields)-1])
+		e.Depth = len(c.tx.savepoints)
+	default:
+		e.Depth = len(c.tx.savepoints)
+	}
+	c.tx.transcript.record(e)
+}
+
+func (c *recordConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	tx, err := c.Conn.BeginTx(ctx, opts)
+	t := c.r.transcriptFor(ctx)
+	if err != nil {
+		t.record(Entry{Kind: KindBegin, Err: err})
+		return nil, err
+	}
+	c.tx = &recordTx{conn: c, tx: tx, transcript: t, id: t.nextTx()}
+	t.record(Entry{Kind: KindBegin, TxID: c.tx.id})
+	return c.tx, nil
+}
+
+func (c *recordConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	stmt, err := c.Conn.PrepareContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	return &recordStmt{conn: c, query: query, Stmt: driverwrap.Stmt{Stmt: stmt}}, nil
+}
+
+func (c *recordConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	ec, ok := c.Conn.Conn.(driver.ExecerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	res, err := ec.ExecContext(ctx, query, args)
+	if errors.Is(err, driver.ErrSkip) {
+		return nil, err
+	}
+	c.record(ctx, KindExec, query, args, err)
+	return res, err
+}
+
+func (c *recordConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	qc, ok := c.Conn.Conn.(driver.QueryerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	rows, err := qc.QueryContext(ctx, query, args)
+	if errors.Is(err, driver.ErrSkip) {
+		return nil, err
+	}
+	c.record(ctx, KindQuery, query, args, err)
+	return rows, err
+}
+
+type recordTx struct {
+	conn       *recordConn
+	tx         driver.Tx
+	transcript *Transcript
+	id         int
+	savepoints []string
+}
+
+// release drops the savepoint name and the ones created after it
+func (t *recordTx) release(name string) {
+	for i := len(t.savepoints) - 1; i >= 0; i-- {
+		if t.savepoints[i] == name {
+			t.savepoints = t.savepoints[:i]
+			return
+		}
+	}
+}
+
+func (t *recordTx) Commit() error {
+	err := t.tx.Commit()
+	t.transcript.record(Entry{Kind: KindCommit, TxID: t.id, Err: err})
+	t.conn.tx = nil
+	return err
+}
+
+func (t *recordTx) Rollback() error {
+	err := t.tx.Rollback()
+	t.transcript.record(Entry{Kind: KindRollback, TxID: t.id, Err: err})
+	t.conn.tx = nil
+	return err
+}
+
+type recordStmt struct {
+	conn  *recordConn
+	query string
+	driverwrap.Stmt
+}
+
+func (s *recordStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	res, err := s.Stmt.ExecContext(ctx, args)
+	s.conn.record(ctx, KindExec, s.query, args, err)
+	return res, err
+}
+
+func (s *recordStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	rows, err := s.Stmt.QueryContext(ctx, args)
+	s.conn.record(ctx, KindQuery, s.query, args, err)
+	return rows, err
+}
+
+func argValues(args []driver.NamedValue) []any {
+	if len(args) == 0 {
+		return nil
+	}
+	values := make([]any, len(args))
+	for i, arg := range args {
+		if arg.Name != "" {
+			values[i] = namedArg{name: arg.Name, value: arg.Value}
+		} else {
+			values[i] = arg.Value
+		}
+	}
+	return values
+}
@@ -0,0 +1,139 @@
+package sessionrecord
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/aeramu/sql-transaction/session"
+	"github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/suite"
+)
+
+type RecorderTestSuite struct {
+	suite.Suite
+	rec     *Recorder
+	db      *sql.DB
+	session session.Session
+	wrapper session.DBWrapper[session.Executor]
+}
+
+func TestRecorder(t *testing.T) {
+	suite.Run(t, new(RecorderTestSuite))
+}
+
+func (s *RecorderTestSuite) SetupTest() {
+	s.rec = New()
+	s.db = s.rec.OpenDB(&sqlite3.SQLiteDriver{}, "file:"+filepath.Join(s.T().TempDir(), "record.db")+"?_busy_timeout=5000")
+	s.session = session.NewSession(s.db)
+	s.wrapper = session.NewDB(s.db)
+
+	_, err := s.db.Exec("CREATE TABLE orders (id INTEGER PRIMARY KEY, note TEXT)")
+	s.Require().NoError(err)
+	s.rec.Transcript().Reset()
+}
+
+func (s *RecorderTestSuite) TearDownTest() {
+	s.db.Close()
+}
+
+func (s *RecorderTestSuite) insert(ctx context.Context, id int) error {
+	_, err := s.wrapper.GetDB(ctx).ExecContext(ctx, "INSERT INTO orders (id, note)\n\tVALUES (?, ?)", id, "n")
+	return err
+}
+
+func (s *RecorderTestSuite) TestTransaction() {
+	err := s.session.WithTransaction(context.Background(), func(ctx context.Context) error {
+		if err := s.insert(ctx, 1); err != nil {
+			return err
+		}
+		var n int
+		return s.wrapper.GetDB(ctx).QueryRowContext(ctx, "SELECT COUNT(*) FROM orders").Scan(&n)
+	})
+	s.Require().NoError(err)
+
+	s.Equal(`begin tx=1
+exec tx=1 depth=0 INSERT INTO orders (id, note) VALUES (?, ?) [1, "n"]
+query tx=1 depth=0 SELECT COUNT(*) FROM orders
+commit tx=1
+`, s.rec.Transcript().String())
+}
+
+func (s *RecorderTestSuite) TestOutsideTransaction() {
+	err := s.session.WithTransaction(context.Background(), func(ctx context.Context) error {
+		return s.insert(context.Background(), 1)
+	})
+	s.Require().NoError(err)
+
+	entries := s.rec.Transcript().Entries()
+	s.Require().Len(entries, 3)
+	s.Equal(KindExec, entries[1].Kind)
+	s.Zero(entries[1].TxID, "the statement did not run in the transaction")
+	s.Contains(s.rec.Transcript().String(), "exec tx=- depth=0 INSERT")
+}
+
+func (s *RecorderTestSuite) TestRollbackAndError() {
+	err := s.session.WithTransaction(context.Background(), func(ctx context.Context) error {
+		if err := s.insert(ctx, 1); err != nil {
+			return err
+		}
+		return s.insert(ctx, 1)
+	})
+	s.Require().Error(err)
+
+	entries := s.rec.Transcript().Entries()
+	s.Require().Len(entries, 4)
+	s.Error(entries[2].Err)
+	s.Equal(Entry{Kind: KindRollback, TxID: 1}, entries[3])
+}
+
+func (s *RecorderTestSuite) TestSavepointDepth() {
+	err := s.session.WithTransaction(context.Background(), func(ctx context.Context) error {
+		_ = s.session.WithTransaction(ctx, func(ctx context.Context) error {
+			if err := s.insert(ctx, 1); err != nil {
+				return err
+			}
+			return errors.New("rollback savepoint")
+		}, session.WithPropagation(session.PropagationNested))
+		return s.insert(ctx, 2)
+	})
+	s.Require().NoError(err)
+
+	s.Equal(`begin tx=1
+exec tx=1 depth=0 SAVEPOINT session_sp_1
+exec tx=1 depth=1 INSERT INTO orders (id, note) VALUES (?, ?) [1, "n"]
+exec tx=1 depth=1 ROLLBACK TO SAVEPOINT session_sp_1
+exec tx=1 depth=0 RELEASE SAVEPOINT session_sp_1
+exec tx=1 depth=0 INSERT INTO orders (id, note) VALUES (?, ?) [2, "n"]
+commit tx=1
+`, s.rec.Transcript().String())
+}
+
+func (s *RecorderTestSuite) TestContextTranscript() {
+	t := NewTranscript()
+	ctx := WithTranscript(context.Background(), t)
+
+	s.Require().NoError(s.session.WithTransaction(ctx, func(ctx context.Context) error {
+		return s.insert(ctx, 1)
+	}))
+	s.Require().NoError(s.session.WithTransaction(ctx, func(ctx context.Context) error {
+		return s.insert(ctx, 2)
+	}))
+
+	s.Same(t, FromContext(ctx))
+	s.Empty(s.rec.Transcript().Entries())
+	s.Len(t.Entries(), 6)
+	s.Equal(2, t.Entries()[3].TxID)
+}
+
+func (s *RecorderTestSuite) TestPreparedStatement() {
+	stmt, err := s.db.Prepare("INSERT INTO orders (id) VALUES (?)")
+	s.Require().NoError(err)
+	defer stmt.Close()
+
+	_, err = stmt.Exec(1)
+	s.Require().NoError(err)
+	s.Equal("exec tx=- depth=0 INSERT INTO orders (id) VALUES (?) [1]\n", s.rec.Transcript().String())
+}
@@ -0,0 +1,49 @@
+package sessionrecord
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// UpdateEnv is the environment variable which, set to a true value such as 1,
+// makes AssertGolden write the golden files instead of comparing against them
+const UpdateEnv = "SESSIONRECORD_UPDATE"
+
+func updating() bool {
+	update, _ := strconv.ParseBool(os.Getenv(UpdateEnv))
+	return update
+}
+
+// AssertGolden fails the test unless the transcript matches the golden file at path.
+// Running the tests with SESSIONRECORD_UPDATE=1 writes the transcript to the file instead.
+func (t *Transcript) AssertGolden(tb testing.TB, path string) {
+	tb.Helper()
+
+	got := t.String()
+	if updating() {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			tb.Fatalf("sessionrecord: failed to create golden directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(got), 0o644); err != nil {
+			tb.Fatalf("sessionrecord: failed to write golden file: %v", err)
+		}
+		return
+	}
+
+	want, err := os.ReadFile(path)
+	if err != nil {
+		tb.Fatalf("sessionrecord: failed to read golden file, run the tests with %s=1 to create it: %v", UpdateEnv, err)
+	}
+	if got != string(want) {
+		tb.Errorf("sessionrecord: transcript does not match %s, run the tests with %s=1 to accept it\n--- got\n%s--- want\n%s", path, UpdateEnv, got, want)
+	}
+}
+
+// GoldenPath returns the golden file of the test under testdata, named after the test
+func GoldenPath(tb testing.TB) string {
+	name := strings.NewReplacer("/", "__", " ", "_").Replace(tb.Name())
+	return filepath.Join("testdata", name+".golden")
+}
@@ -0,0 +1,69 @@
+package sessionrecord
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEntry_String(t *testing.T) {
+	tests := []struct {
+		name  string
+		entry Entry
+		want  string
+	}{
+		{"begin", Entry{Kind: KindBegin, TxID: 1}, "begin tx=1"},
+		{"outside transaction", Entry{Kind: KindQuery, Query: "SELECT 1"}, "query tx=- depth=0 SELECT 1"},
+		{"args", Entry{Kind: KindExec, TxID: 2, Depth: 1, Query: "UPDATE t SET a = ?, b = ?, c = ?, d = ?", Args: []any{int64(1), nil, []byte("x"), time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)}},
+			`exec tx=2 depth=1 UPDATE t SET a = ?, b = ?, c = ?, d = ? [1, NULL, "x", 2024-01-02T03:04:05Z]`},
+		{"named args", Entry{Kind: KindExec, TxID: 1, Query: "DELETE FROM t WHERE id = :id", Args: []any{namedArg{name: "id", value: "a"}}},
+			`exec tx=1 depth=0 DELETE FROM t WHERE id = :id [id="a"]`},
+		{"error", Entry{Kind: KindCommit, TxID: 1, Err: errors.New("bad conn")}, "commit tx=1 -> error: bad conn"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.entry.String())
+		})
+	}
+}
+
+func TestTranscript_AssertGolden(t *testing.T) {
+	tr := NewTranscript()
+	tr.record(Entry{Kind: KindBegin, TxID: tr.nextTx()})
+	tr.record(Entry{Kind: KindExec, TxID: 1, Query: normalize("INSERT INTO orders\n  (id) VALUES (?)"), Args: []any{int64(1)}})
+	tr.record(Entry{Kind: KindCommit, TxID: 1})
+
+	tr.AssertGolden(t, GoldenPath(t))
+}
+
+func TestTranscript_AssertGoldenMismatch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mismatch.golden")
+	assert.NoError(t, os.WriteFile(path, []byte("begin tx=1\n"), 0o644))
+
+	tb := &testing.T{}
+	NewTranscript().AssertGolden(tb, path)
+	assert.True(t, tb.Failed())
+}
+
+func TestTranscript_AssertGoldenUpdate(t *testing.T) {
+	t.Setenv(UpdateEnv, "1")
+	path := filepath.Join(t.TempDir(), "golden", "update.golden")
+	tr := NewTranscript()
+	tr.record(Entry{Kind: KindBegin, TxID: tr.nextTx()})
+
+	tr.AssertGolden(t, path)
+
+	got, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, tr.String(), string(got))
+}
+
+func TestGoldenPath(t *testing.T) {
+	t.Run("sub test", func(t *testing.T) {
+		assert.Equal(t, filepath.Join("testdata", "TestGoldenPath__sub_test.golden"), GoldenPath(t))
+	})
+}
@@ -0,0 +1,153 @@
+// Package sessionrecord records the statements issued through a database/sql driver,
+// together with the transaction they ran in, and compares them against golden files.
+// It makes N+1 queries and statements accidentally run outside of the transaction visible in review.
+package sessionrecord
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Kind is the kind of a recorded entry
+type Kind string
+
+const (
+	KindBegin    Kind = "begin"
+	KindExec     Kind = "exec"
+	KindQuery    Kind = "query"
+	KindCommit   Kind = "commit"
+	KindRollback Kind = "rollback"
+)
+
+// Entry is a recorded statement or transaction event
+type Entry struct {
+	Kind Kind
+	// TxID numbers the transactions of the transcript from 1. Zero is a statement run outside of any transaction.
+	TxID int
+	// Depth is the number of savepoints open when the statement ran
+	Depth int
+	// Query is the statement text with its whitespace collapsed
+	Query string
+	Args  []any
+	// Err is the error returned by the driver, nil when the operation succeeded
+	Err error
+}
+
+func (e Entry) String() string {
+	var b strings.Builder
+	b.WriteString(string(e.Kind))
+	if e.TxID == 0 {
+		b.WriteString(" tx=-")
+	} else {
+		fmt.Fprintf(&b, " tx=%d", e.TxID)
+	}
+	if e.Kind == KindExec || e.Kind == KindQuery {
+		fmt.Fprintf(&b, " depth=%d %s", e.Depth, e.Query)
+		if len(e.Args) > 0 {
+			b.WriteString(" ")
+			b.WriteString(formatArgs(e.Args))
+		}
+	}
+	if e.Err != nil {
+		fmt.Fprintf(&b, " -> error: %v", e.Err)
+	}
+	return b.String()
+}
+
+// Transcript is the ordered list of entries recorded for a test
+type Transcript struct {
+	mu      sync.Mutex
+	entries []Entry
+	txs     int
+}
+
+func NewTranscript() *Transcript {
+	return &Transcript{}
+}
+
+// Entries returns the recorded entries in order
+func (t *Transcript) Entries() []Entry {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]Entry(nil), t.entries...)
+}
+
+// String returns the entries one per line, in the format of the golden files
+func (t *Transcript) String() string {
+	var b strings.Builder
+	for _, e := range t.Entries() {
+		b.WriteString(e.String())
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// Reset forgets the recorded entries and restarts the transaction numbering
+func (t *Transcript) Reset() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.entries = nil
+	t.txs = 0
+}
+
+func (t *Transcript) record(e Entry) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.entries = append(t.entries, e)
+}
+
+func (t *Transcript) nextTx() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.txs++
+	return t.txs
+}
+
+type transcriptKey struct{}
+
+// WithTranscript returns a context whose statements and transactions are recorded in t
+func WithTranscript(ctx context.Context, t *Transcript) context.Context {
+	return context.WithValue(ctx, transcriptKey{}, t)
+}
+
+// FromContext returns the transcript carried by ctx, or nil
+func FromContext(ctx context.Context) *Transcript {
+	t, _ := ctx.Value(transcriptKey{}).(*Transcript)
+	return t
+}
+
+func normalize(query string) string {
+	return strings.Join(strings.Fields(query), " ")
+}
+
+func formatArgs(args []any) string {
+	parts := make([]string, len(args))
+	for i, arg := range args {
+		parts[i] = formatArg(arg)
+	}
+	return "[" + strings.Join(parts, ", ") + "]"
+}
+
+func formatArg(arg any) string {
+	switch v := arg.(type) {
+	case nil:
+		return "NULL"
+	case string:
+		return fmt.Sprintf("%q", v)
+	case []byte:
+		return fmt.Sprintf("%q", v)
+	case time.Time:
+		return v.UTC().Format(time.RFC3339Nano)
+	case namedArg:
+		return v.name + "=" + formatArg(v.value)
+	}
+	return fmt.Sprint(arg)
+}
+
+type namedArg struct {
+	name  string
+	value any
+}
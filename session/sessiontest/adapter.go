@@ -0,0 +1,313 @@
+package sessiontest
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/aeramu/sql-transaction/session"
+	"github.com/aeramu/sql-transaction/session/dialect"
+)
+
+const conformanceTable = "sessiontest_conformance"
+
+// Adapter describes a session.Database implementation checked by RunAdapterSuite
+type Adapter[T any] struct {
+	// DB is the database the adapter is connected to.
+	// The suite creates and drops its own table in it.
+	DB *sql.DB
+	// Database is the adapter under test
+	Database session.Database[T]
+	// Exec runs a statement through a handle returned by the adapter
+	Exec func(ctx context.Context, db T, query string, args ...any) error
+	// QueryInt runs a query returning a single integer through a handle returned by the adapter
+	QueryInt func(ctx context.Context, db T, query string, args ...any) (int64, error)
+	// Dialect is used to rebind the statements of the suite. Defaults to SQLite.
+	Dialect dialect.Dialect
+}
+
+// RunAdapterSuite checks that a session.Database implementation resolves GetDB
+// to the transaction carried by the context and behaves correctly with session.WithTransaction:
+// no transaction, wrong-typed values, ConvertTx, commit, rollback, nesting, savepoints, panics,
+// concurrent transactions and context cancellation.
+// newAdapter is called for every subtest.
+func RunAdapterSuite[T any](t *testing.T, newAdapter func(t *testing.T) Adapter[T]) {
+	tests := []struct {
+		name string
+		run  func(t *testing.T, c *conformance[T])
+	}{
+		{"NoTransaction", testNoTransaction[T]},
+		{"WrongTypeTransaction", testWrongTypeTransaction[T]},
+		{"ConvertTx", testConvertTx[T]},
+		{"Committed", testCommitted[T]},
+		{"RolledBack", testRolledBack[T]},
+		{"NestedJoin", testNestedJoin[T]},
+		{"NestedSavepoint", testNestedSavepoint[T]},
+		{"Panic", testPanic[T]},
+		{"Concurrent", testConcurrent[T]},
+		{"ContextCancelled", testContextCancelled[T]},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newConformance(t, newAdapter(t))
+			tt.run(t, c)
+		})
+	}
+}
+
+type conformance[T any] struct {
+	Adapter[T]
+	wrapper session.DBWrapper[T]
+	session session.Session
+}
+
+func newConformance[T any](t *testing.T, a Adapter[T]) *conformance[T] {
+	t.Helper()
+
+	ctx := context.Background()
+	if _, err := a.DB.ExecContext(ctx, "DROP TABLE IF EXISTS "+conformanceTable); err != nil {
+		t.Fatalf("failed to drop table: %v", err)
+	}
+	if _, err := a.DB.ExecContext(ctx, "CREATE TABLE "+conformanceTable+" (id VARCHAR(64) PRIMARY KEY)"); err != nil {
+		t.Fatalf("failed to create table: %v", err)
+	}
+	t.Cleanup(func() {
+		_, _ = a.DB.ExecContext(context.Background(), "DROP TABLE IF EXISTS "+conformanceTable)
+	})
+
+	return &conformance[T]{
+		Adapter: a,
+		wrapper: session.NewDBWrapper(a.Database),
+		session: session.NewSession(a.DB),
+	}
+}
+
+func (c *conformance[T]) insert(ctx context.Context, id string) error {
+	return c.insertInto(ctx, c.wrapper.GetDB(ctx), id)
+}
+
+// insertInto inserts a row through the given handle
+func (c *conformance[T]) insertInto(ctx context.Context, db T, id string) error {
+	query := c.Dialect.Rebind("INSERT INTO " + conformanceTable + " (id) VALUES (?)")
+	return c.Exec(ctx, db, query, id)
+}
+
+// count counts the rows through the handle resolved from ctx
+func (c *conformance[T]) count(t *testing.T, ctx context.Context) int64 {
+	t.Helper()
+	n, err := c.QueryInt(ctx, c.wrapper.GetDB(ctx), "SELECT COUNT(*) FROM "+conformanceTable)
+	if err != nil {
+		t.Fatalf("failed to count rows: %v", err)
+	}
+	return n
+}
+
+// committed counts the rows visible outside of any transaction
+func (c *conformance[T]) committed(t *testing.T) int64 {
+	t.Helper()
+	var n int64
+	if err := c.DB.QueryRow("SELECT COUNT(*) FROM " + conformanceTable).Scan(&n); err != nil {
+		t.Fatalf("failed to count rows: %v", err)
+	}
+	return n
+}
+
+func expectCount(t *testing.T, name string, got, want int64) {
+	t.Helper()
+	if got != want {
+		t.Errorf("%s: got %d rows, want %d", name, got, want)
+	}
+}
+
+// testNoTransaction checks that statements run outside of any transaction, each committed on its own
+func testNoTransaction[T any](t *testing.T, c *conformance[T]) {
+	ctx := context.Background()
+	if err := c.insert(ctx, "a"); err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+	expectCount(t, "committed", c.committed(t), 1)
+	if err := c.insertInto(ctx, c.Database.GetDB(ctx), "b"); err != nil {
+		t.Fatalf("insert through Database.GetDB: %v", err)
+	}
+	expectCount(t, "committed", c.committed(t), 2)
+}
+
+// testWrongTypeTransaction checks that a context value of another type is not taken for a transaction
+func testWrongTypeTransaction[T any](t *testing.T, c *conformance[T]) {
+	ctx := session.WithTx(context.Background(), "tx")
+	if err := c.insert(ctx, "a"); err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+	expectCount(t, "committed", c.committed(t), 1)
+}
+
+// testConvertTx checks that the handle returned by ConvertTx runs its statements in the given transaction
+func testConvertTx[T any](t *testing.T, c *conformance[T]) {
+	ctx := context.Background()
+	for _, commit := range []bool{false, true} {
+		tx, err := c.DB.BeginTx(ctx, nil)
+		if err != nil {
+			t.Fatalf("BeginTx: %v", err)
+		}
+		db := c.Database.ConvertTx(ctx, tx)
+		if err := c.insertInto(ctx, db, fmt.Sprintf("commit-%t", commit)); err != nil {
+			_ = tx.Rollback()
+			t.Fatalf("insert through ConvertTx: %v", err)
+		}
+		n, err := c.QueryInt(ctx, db, "SELECT COUNT(*) FROM "+conformanceTable)
+		if err != nil {
+			_ = tx.Rollback()
+			t.Fatalf("count through ConvertTx: %v", err)
+		}
+		expectCount(t, "inside transaction", n, 1)
+
+		if commit {
+			err = tx.Commit()
+		} else {
+			err = tx.Rollback()
+		}
+		if err != nil {
+			t.Fatalf("ending transaction: %v", err)
+		}
+		if commit {
+			expectCount(t, "after commit", c.committed(t), 1)
+		} else {
+			expectCount(t, "after rollback", c.committed(t), 0)
+		}
+	}
+}
+
+func testCommitted[T any](t *testing.T, c *conformance[T]) {
+	err := c.session.WithTransaction(context.Background(), func(ctx context.Context) error {
+		if err := c.insert(ctx, "a"); err != nil {
+			return err
+		}
+		expectCount(t, "inside transaction", c.count(t, ctx), 1)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("WithTransaction: %v", err)
+	}
+	expectCount(t, "committed", c.committed(t), 1)
+}
+
+func testRolledBack[T any](t *testing.T, c *conformance[T]) {
+	errRollback := errors.New("rollback")
+	err := c.session.WithTransaction(context.Background(), func(ctx context.Context) error {
+		if err := c.insert(ctx, "a"); err != nil {
+			return err
+		}
+		return errRollback
+	})
+	if !errors.Is(err, errRollback) {
+		t.Fatalf("WithTransaction: got %v, want %v", err, errRollback)
+	}
+	expectCount(t, "committed", c.committed(t), 0)
+}
+
+func testNestedJoin[T any](t *testing.T, c *conformance[T]) {
+	errRollback := errors.New("rollback")
+	err := c.session.WithTransaction(context.Background(), func(ctx context.Context) error {
+		if err := c.insert(ctx, "a"); err != nil {
+			return err
+		}
+		err := c.session.WithTransaction(ctx, func(ctx context.Context) error {
+			expectCount(t, "nested sees outer writes", c.count(t, ctx), 1)
+			return c.insert(ctx, "b")
+		})
+		if err != nil {
+			return err
+		}
+		return errRollback
+	})
+	if !errors.Is(err, errRollback) {
+		t.Fatalf("WithTransaction: got %v, want %v", err, errRollback)
+	}
+	expectCount(t, "committed", c.committed(t), 0)
+}
+
+func testNestedSavepoint[T any](t *testing.T, c *conformance[T]) {
+	err := c.session.WithTransaction(context.Background(), func(ctx context.Context) error {
+		if err := c.insert(ctx, "a"); err != nil {
+			return err
+		}
+		err := c.session.WithTransaction(ctx, func(ctx context.Context) error {
+			if err := c.insert(ctx, "b"); err != nil {
+				return err
+			}
+			return errors.New("rollback savepoint")
+		}, session.WithPropagation(session.PropagationNested))
+		if err == nil {
+			t.Errorf("nested WithTransaction: want error")
+		}
+		expectCount(t, "after savepoint rollback", c.count(t, ctx), 1)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("WithTransaction: %v", err)
+	}
+	expectCount(t, "committed", c.committed(t), 1)
+}
+
+func testPanic[T any](t *testing.T, c *conformance[T]) {
+	func() {
+		defer func() {
+			if p := recover(); p == nil {
+				t.Errorf("WithTransaction: want panic to propagate")
+			}
+		}()
+		_ = c.session.WithTransaction(context.Background(), func(ctx context.Context) error {
+			if err := c.insert(ctx, "a"); err != nil {
+				return err
+			}
+			panic("conformance panic")
+		})
+	}()
+	expectCount(t, "committed", c.committed(t), 0)
+}
+
+func testConcurrent[T any](t *testing.T, c *conformance[T]) {
+	const workers = 8
+
+	var wg sync.WaitGroup
+	errs := make(chan error, workers)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs <- c.session.WithTransaction(context.Background(), func(ctx context.Context) error {
+				return c.insert(ctx, fmt.Sprintf("worker-%d", i))
+			})
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Errorf("WithTransaction: %v", err)
+		}
+	}
+	expectCount(t, "committed", c.committed(t), workers)
+}
+
+func testContextCancelled[T any](t *testing.T, c *conformance[T]) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	err := c.session.WithTransaction(ctx, func(ctx context.Context) error {
+		if err := c.insert(ctx, "a"); err != nil {
+			return err
+		}
+		cancel()
+		return nil
+	})
+	if err == nil {
+		t.Fatalf("WithTransaction: want error after context cancellation")
+	}
+	expectCount(t, "committed", c.committed(t), 0)
+}
@@ -0,0 +1,36 @@
+package sessiontest
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/aeramu/sql-transaction/session"
+)
+
+func TestRunAdapterSuite(t *testing.T) {
+	RunAdapterSuite(t, func(t *testing.T) Adapter[session.Executor] {
+		db, err := sql.Open("sqlite3", "file:"+filepath.Join(t.TempDir(), "test.db")+"?_busy_timeout=5000&_txlock=immediate")
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { db.Close() })
+
+		return Adapter[session.Executor]{
+			DB:       db,
+			Database: session.NewDatabase(db),
+			Exec: func(ctx context.Context, db session.Executor, query string, args ...any) error {
+				_, err := db.ExecContext(ctx, query, args...)
+				return err
+			},
+			QueryInt: func(ctx context.Context, db session.Executor, query string, args ...any) (int64, error) {
+				var n int64
+				err := db.QueryRowContext(ctx, query, args...).Scan(&n)
+				return n, err
+			},
+		}
+	})
+}
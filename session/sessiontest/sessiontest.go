@@ -0,0 +1,86 @@
+// Package sessiontest provides fixtures for integration tests of code built on session.
+package sessiontest
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"github.com/aeramu/sql-transaction/session"
+	"github.com/aeramu/sql-transaction/session/sessionrecord"
+)
+
+// Option configures Begin
+type Option func(*config)
+
+type config struct {
+	txOptions   *sql.TxOptions
+	propagation session.Propagation
+	record      bool
+}
+
+// WithSavepoints makes every WithTransaction call under test run in a savepoint of the test transaction,
+// so a call that fails only undoes its own changes, like it would against a real database
+func WithSavepoints() Option {
+	return func(c *config) {
+		c.propagation = session.PropagationNested
+	}
+}
+
+// WithTxOptions sets the options used to begin the test transaction
+func WithTxOptions(opts *sql.TxOptions) Option {
+	return func(c *config) {
+		c.txOptions = opts
+	}
+}
+
+// WithRecording records the statements of the test in its own transcript,
+// available from the returned context with sessionrecord.FromContext.
+// Once the test transaction is rolled back, the transcript is compared against
+// the golden file given by sessionrecord.GoldenPath, or written to it when SESSIONRECORD_UPDATE is set.
+// The database must be opened through a sessionrecord.Recorder.
+func WithRecording() Option {
+	return func(c *config) {
+		c.record = true
+	}
+}
+
+// Begin starts a transaction on db and returns a context carrying it.
+// The transaction is rolled back when the test and its subtests complete,
+// so nothing written during the test is left in the database, and so are the resources enlisted in it.
+// Code under test calling WithTransaction with the returned context joins the test transaction
+// instead of committing, and the session, gorm and sqlx wrappers resolve GetDB to it.
+func Begin(tb testing.TB, db *sql.DB, opts ...Option) context.Context {
+	tb.Helper()
+
+	cfg := &config{propagation: session.PropagationRequired}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+
+	ctx := context.Background()
+	if cfg.record {
+		transcript := sessionrecord.NewTranscript()
+		ctx = sessionrecord.WithTranscript(ctx, transcript)
+		path := sessionrecord.GoldenPath(tb)
+		tb.Cleanup(func() {
+			transcript.AssertGolden(tb, path)
+		})
+	}
+
+	tx, err := db.BeginTx(ctx, cfg.txOptions)
+	if err != nil {
+		tb.Fatalf("sessiontest: failed to begin transaction: %v", err)
+	}
+	ctx = session.WithExternalTx(ctx, tx, cfg.propagation)
+	st := session.Current(ctx)
+	tb.Cleanup(func() {
+		if err := tx.Rollback(); err != nil {
+			tb.Errorf("sessiontest: failed to roll back transaction: %v", err)
+		}
+		if err := st.RollbackResources(context.Background()); err != nil {
+			tb.Errorf("sessiontest: failed to roll back enlisted resources: %v", err)
+		}
+	})
+	return ctx
+}
@@ -0,0 +1,151 @@
+package sessiontest
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+
+	"github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/suite"
+
+	"github.com/aeramu/sql-transaction/session"
+	"github.com/aeramu/sql-transaction/session/sessionrecord"
+)
+
+type BeginTestSuite struct {
+	suite.Suite
+	sqlDB   *sql.DB
+	db      session.DBWrapper[session.Executor]
+	session session.Session
+}
+
+func (s *BeginTestSuite) SetupSuite() {
+	db, err := sql.Open("sqlite3", ":memory:")
+	s.Require().NoError(err)
+	db.SetMaxOpenConns(1)
+
+	_, err = db.Exec(`CREATE TABLE models (id TEXT PRIMARY KEY)`)
+	s.Require().NoError(err)
+
+	s.sqlDB = db
+	s.db = session.NewDB(db)
+	s.session = session.NewSession(db)
+}
+
+func (s *BeginTestSuite) TearDownSuite() {
+	s.sqlDB.Close()
+}
+
+func (s *BeginTestSuite) insert(ctx context.Context, id string) error {
+	return s.session.WithTransaction(ctx, func(ctx context.Context) error {
+		_, err := s.db.GetDB(ctx).ExecContext(ctx, "INSERT INTO models (id) VALUES (?)", id)
+		return err
+	})
+}
+
+func (s *BeginTestSuite) count(db session.Executor) int {
+	var count int
+	s.Require().NoError(db.QueryRow("SELECT COUNT(*) FROM models").Scan(&count))
+	return count
+}
+
+func (s *BeginTestSuite) TestBegin_rolledBackAfterTest() {
+	s.Run("writes", func() {
+		ctx := Begin(s.T(), s.sqlDB)
+		s.NoError(s.insert(ctx, "a"))
+		s.Equal(1, s.count(s.db.GetDB(ctx)))
+	})
+
+	s.Equal(0, s.count(s.sqlDB))
+}
+
+func (s *BeginTestSuite) TestBegin_sameIDInEveryTest() {
+	for i := 0; i < 2; i++ {
+		s.Run("writes", func() {
+			ctx := Begin(s.T(), s.sqlDB)
+			s.NoError(s.insert(ctx, "a"))
+		})
+	}
+}
+
+func (s *BeginTestSuite) TestBegin_joinKeepsFailedWrites() {
+	s.Run("writes", func() {
+		ctx := Begin(s.T(), s.sqlDB)
+		err := s.session.WithTransaction(ctx, func(ctx context.Context) error {
+			s.NoError(s.insert(ctx, "a"))
+			return errors.New("business error")
+		})
+		s.Error(err)
+		s.Equal(1, s.count(s.db.GetDB(ctx)))
+	})
+}
+
+func (s *BeginTestSuite) TestBegin_withSavepoints() {
+	s.Run("writes", func() {
+		ctx := Begin(s.T(), s.sqlDB, WithSavepoints())
+		s.NoError(s.insert(ctx, "a"))
+		err := s.session.WithTransaction(ctx, func(ctx context.Context) error {
+			s.NoError(s.insert(ctx, "b"))
+			return errors.New("business error")
+		})
+		s.Error(err)
+		s.Equal(1, s.count(s.db.GetDB(ctx)))
+	})
+
+	s.Equal(0, s.count(s.sqlDB))
+}
+
+// rollbackResource records whether it was rolled back
+type rollbackResource struct {
+	rolledBack bool
+}
+
+func (r *rollbackResource) Prepare(ctx context.Context) error  { return nil }
+func (r *rollbackResource) Commit(ctx context.Context) error   { return nil }
+func (r *rollbackResource) Rollback(ctx context.Context) error { r.rolledBack = true; return nil }
+
+func (s *BeginTestSuite) TestBegin_enlistedResourcesRolledBack() {
+	r := &rollbackResource{}
+	s.Run("enlists", func() {
+		ctx := Begin(s.T(), s.sqlDB)
+		s.NoError(s.session.WithTransaction(ctx, func(ctx context.Context) error {
+			return session.Enlist(ctx, r)
+		}))
+		s.False(r.rolledBack)
+	})
+
+	s.True(r.rolledBack)
+}
+
+func TestBegin_withRecording(t *testing.T) {
+	db := sessionrecord.New().OpenDB(&sqlite3.SQLiteDriver{}, ":memory:")
+	db.SetMaxOpenConns(1)
+	defer db.Close()
+	_, err := db.Exec(`CREATE TABLE models (id TEXT PRIMARY KEY)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := session.NewSession(db)
+	wrapper := session.NewDB(db)
+
+	for _, id := range []string{"a", "b"} {
+		t.Run(id, func(t *testing.T) {
+			ctx := Begin(t, db, WithSavepoints(), WithRecording())
+			err := s.WithTransaction(ctx, func(ctx context.Context) error {
+				_, err := wrapper.GetDB(ctx).ExecContext(ctx, "INSERT INTO models (id) VALUES (?)", id)
+				return err
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if sessionrecord.FromContext(ctx) == nil {
+				t.Error("expected the context to carry the transcript")
+			}
+		})
+	}
+}
+
+func TestBeginTestSuite(t *testing.T) {
+	suite.Run(t, new(BeginTestSuite))
+}
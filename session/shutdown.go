@@ -0,0 +1,118 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// ErrSessionClosed is returned by WithTransaction for a transaction started after Shutdown,
+// and wraps the error of a transaction Shutdown cancelled
+var ErrSessionClosed = errors.New("session closed")
+
+// ShutdownError is returned by Shutdown when transactions were still in progress at its deadline
+type ShutdownError struct {
+	// Aborted describes the transactions cancelled by Shutdown, the oldest first
+	Aborted []ActiveTx
+	// Err is the error of the context given to Shutdown
+	Err error
+}
+
+func (e *ShutdownError) Error() string {
+	ids := make([]string, len(e.Aborted))
+	for i, tx := range e.Aborted {
+		ids[i] = tx.ID
+	}
+	return fmt.Sprintf("shutdown aborted %d transactions %v: %v", len(e.Aborted), ids, e.Err)
+}
+
+func (e *ShutdownError) Unwrap() error {
+	return e.Err
+}
+
+// inflightTx is a new transaction of a session, cancelled by Shutdown when it outlives the drain
+type inflightTx struct {
+	cancel context.CancelCauseFunc
+	// st is nil while the transaction begins
+	st *TxState
+}
+
+// Shutdown stops the session from starting transactions outside of a transaction in progress,
+// which then fail with ErrSessionClosed, and waits for the transactions in progress to end.
+// When ctx is done first, the remaining transactions have their context cancelled, so they roll back,
+// and Shutdown returns a *ShutdownError describing them without waiting for their functions to return.
+func (s *session) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	s.closed = true
+	if s.drained == nil {
+		s.drained = make(chan struct{})
+		if len(s.inflight) == 0 {
+			close(s.drained)
+		}
+	}
+	drained := s.drained
+	s.mu.Unlock()
+
+	select {
+	case <-drained:
+		return nil
+	case <-ctx.Done():
+	}
+
+	now := time.Now()
+	var aborted []ActiveTx
+	s.mu.Lock()
+	for t := range s.inflight {
+		t.cancel(ErrSessionClosed)
+		if t.st != nil {
+			aborted = append(aborted, t.st.activeTx(now))
+		}
+	}
+	s.mu.Unlock()
+
+	sort.Slice(aborted, func(i, j int) bool {
+		return aborted[i].StartedAt.Before(aborted[j].StartedAt)
+	})
+	return &ShutdownError{Aborted: aborted, Err: ctx.Err()}
+}
+
+// begin registers a new transaction, refused once the session is closed unless it runs inside another one
+func (s *session) begin(t *inflightTx, outermost bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed && outermost {
+		return ErrSessionClosed
+	}
+	if s.inflight == nil {
+		s.inflight = make(map[*inflightTx]struct{})
+	}
+	s.inflight[t] = struct{}{}
+	return nil
+}
+
+func (s *session) started(t *inflightTx, st *TxState) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t.st = st
+}
+
+func (s *session) end(t *inflightTx) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.inflight, t)
+	if s.drained != nil && len(s.inflight) == 0 {
+		select {
+		case <-s.drained:
+		default:
+			close(s.drained)
+		}
+	}
+}
+
+func (s *session) isClosed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closed
+}
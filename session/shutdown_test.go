@@ -0,0 +1,93 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestShutdown_idle(t *testing.T) {
+	db := openTimeoutTestDB(t)
+	sess := NewSession(db)
+
+	require.NoError(t, sess.Shutdown(context.Background()))
+
+	err := sess.WithTransaction(context.Background(), func(ctx context.Context) error {
+		t.Error("function run after shutdown")
+		return nil
+	})
+	assert.ErrorIs(t, err, ErrSessionClosed)
+	require.NoError(t, sess.Shutdown(context.Background()))
+}
+
+func TestShutdown_drain(t *testing.T) {
+	db := openTimeoutTestDB(t)
+	sess := NewSession(db)
+	wrapper := NewDB(db)
+
+	started, release := make(chan struct{}), make(chan struct{})
+	done := make(chan error)
+	go func() {
+		done <- sess.WithTransaction(context.Background(), func(ctx context.Context) error {
+			close(started)
+			<-release
+			// calls inside the transaction in progress keep working while draining
+			return sess.WithTransaction(ctx, func(ctx context.Context) error {
+				_, err := wrapper.GetDB(ctx).ExecContext(ctx, "INSERT INTO models (id) VALUES (?)", "a")
+				return err
+			}, WithPropagation(PropagationNested))
+		})
+	}()
+	<-started
+
+	shutdown := make(chan error)
+	go func() {
+		shutdown <- sess.Shutdown(context.Background())
+	}()
+	require.Eventually(t, func() bool { return sess.(*session).isClosed() }, time.Second, time.Millisecond)
+
+	err := sess.WithTransaction(context.Background(), func(ctx context.Context) error { return nil })
+	assert.ErrorIs(t, err, ErrSessionClosed)
+
+	close(release)
+	assert.NoError(t, <-done)
+	assert.NoError(t, <-shutdown)
+	assert.Equal(t, 1, countModels(t, db))
+}
+
+func TestShutdown_abortStragglers(t *testing.T) {
+	db := openTimeoutTestDB(t)
+	sess := NewSession(db)
+	wrapper := NewDB(db)
+
+	ids := make(chan string)
+	done := make(chan error)
+	go func() {
+		done <- sess.WithTransaction(context.Background(), func(ctx context.Context) error {
+			_, err := wrapper.GetDB(ctx).ExecContext(ctx, "INSERT INTO models (id) VALUES (?)", "a")
+			require.NoError(t, err)
+			ids <- TxID(ctx)
+			<-ctx.Done()
+			return ctx.Err()
+		})
+	}()
+	id := <-ids
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	err := sess.Shutdown(ctx)
+
+	var shutdownErr *ShutdownError
+	require.True(t, errors.As(err, &shutdownErr))
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	require.Len(t, shutdownErr.Aborted, 1)
+	assert.Equal(t, id, shutdownErr.Aborted[0].ID)
+	assert.Contains(t, err.Error(), id)
+
+	assert.ErrorIs(t, <-done, ErrSessionClosed)
+	assert.Equal(t, 0, countModels(t, db))
+}
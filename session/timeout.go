@@ -0,0 +1,104 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+)
+
+// ErrTxTimeout is returned by WithTransaction when a transaction ran longer than its maximum duration
+// and was rolled back
+var ErrTxTimeout = errors.New("transaction exceeded its maximum duration")
+
+// LongTransaction describes a transaction open past the threshold of a Watchdog
+type LongTransaction struct {
+	ID        string
+	StartedAt time.Time
+	Duration  time.Duration
+	// Stack is the stack of the goroutine that began the transaction
+	Stack []byte
+	// LastStatement is the last statement recorded for the transaction, see TrackStatements,
+	// empty when there was none
+	LastStatement string
+}
+
+// Watchdog reports the transactions of the sessions it watches that stay open past a threshold,
+// once per transaction. Add it to sessions with WithWatchdog and run it with Run or Check.
+type Watchdog struct {
+	threshold time.Duration
+	report    func(LongTransaction)
+
+	mu sync.Mutex
+	// open holds the transactions in progress, and whether they were reported
+	open map[*TxState]bool
+}
+
+// NewWatchdog returns a watchdog reporting transactions open longer than threshold to report.
+// A nil report logs them with the standard logger.
+func NewWatchdog(threshold time.Duration, report func(LongTransaction)) *Watchdog {
+	if report == nil {
+		report = logLongTransaction
+	}
+	return &Watchdog{
+		threshold: threshold,
+		report:    report,
+		open:      make(map[*TxState]bool),
+	}
+}
+
+func logLongTransaction(lt LongTransaction) {
+	log.Printf("transaction %s open for %s, last statement: %q, begun at:\n%s", lt.ID, lt.Duration, lt.LastStatement, lt.Stack)
+}
+
+// Run checks the transactions every interval until ctx is done
+func (w *Watchdog) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			w.Check()
+		}
+	}
+}
+
+// Check reports the transactions open past the threshold that were not reported yet
+func (w *Watchdog) Check() {
+	now := time.Now()
+	var long []LongTransaction
+	w.mu.Lock()
+	for st, reported := range w.open {
+		if reported || now.Sub(st.startedAt) < w.threshold {
+			continue
+		}
+		w.open[st] = true
+		long = append(long, LongTransaction{
+			ID:            st.id,
+			StartedAt:     st.startedAt,
+			Duration:      now.Sub(st.startedAt),
+			Stack:         st.stack,
+			LastStatement: st.LastStatement(),
+		})
+	}
+	w.mu.Unlock()
+
+	for _, lt := range long {
+		w.report(lt)
+	}
+}
+
+func (w *Watchdog) add(st *TxState) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.open[st] = false
+}
+
+func (w *Watchdog) remove(st *TxState) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	delete(w.open, st)
+}
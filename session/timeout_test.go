@@ -0,0 +1,125 @@
+package session
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func openTimeoutTestDB(t *testing.T) *sql.DB {
+	// a connection interrupted by the timeout may be discarded, which loses an in-memory database
+	db, err := sql.Open("sqlite3", "file:"+filepath.Join(t.TempDir(), "test.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	db.SetMaxOpenConns(1)
+	_, err = db.Exec(`CREATE TABLE models (id TEXT PRIMARY KEY)`)
+	require.NoError(t, err)
+	return db
+}
+
+func countModels(t *testing.T, db *sql.DB) int {
+	var count int
+	require.NoError(t, db.QueryRow("SELECT COUNT(*) FROM models").Scan(&count))
+	return count
+}
+
+func TestWithTransaction_maxDuration(t *testing.T) {
+	db := openTimeoutTestDB(t)
+	wrapper := NewDB(db)
+
+	err := NewSession(db).WithTransaction(context.Background(), func(ctx context.Context) error {
+		_, err := wrapper.GetDB(ctx).ExecContext(ctx, "INSERT INTO models (id) VALUES (?)", "a")
+		require.NoError(t, err)
+		<-ctx.Done()
+		return ctx.Err()
+	}, WithMaxDuration(20*time.Millisecond))
+
+	assert.ErrorIs(t, err, ErrTxTimeout)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Equal(t, 0, countModels(t, db))
+}
+
+func TestWithTransaction_defaultMaxDuration(t *testing.T) {
+	db := openTimeoutTestDB(t)
+	wrapper := NewDB(db)
+	sess := NewSession(db, WithDefaultMaxDuration(20*time.Millisecond))
+
+	// f ignoring the cancellation cannot commit once the limit expired
+	err := sess.WithTransaction(context.Background(), func(ctx context.Context) error {
+		_, err := wrapper.GetDB(ctx).ExecContext(ctx, "INSERT INTO models (id) VALUES (?)", "a")
+		require.NoError(t, err)
+		time.Sleep(50 * time.Millisecond)
+		return nil
+	})
+	assert.ErrorIs(t, err, ErrTxTimeout)
+	assert.Equal(t, 0, countModels(t, db))
+
+	err = sess.WithTransaction(context.Background(), func(ctx context.Context) error {
+		time.Sleep(50 * time.Millisecond)
+		_, err := wrapper.GetDB(ctx).ExecContext(ctx, "INSERT INTO models (id) VALUES (?)", "b")
+		return err
+	}, WithMaxDuration(-1))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, countModels(t, db))
+}
+
+func TestWatchdog(t *testing.T) {
+	db := openTimeoutTestDB(t)
+	wrapper := NewDB(db)
+	reports := make(chan LongTransaction, 1)
+	watchdog := NewWatchdog(10*time.Millisecond, func(lt LongTransaction) {
+		reports <- lt
+	})
+	sess := NewSession(db, WithWatchdog(watchdog))
+
+	err := sess.WithTransaction(context.Background(), func(ctx context.Context) error {
+		_, err := wrapper.GetDB(ctx).ExecContext(ctx, "INSERT INTO models (id) VALUES (?)", "a")
+		require.NoError(t, err)
+		assert.Equal(t, "INSERT INTO models (id) VALUES (?)", Current(ctx).LastStatement())
+
+		watchdog.Check()
+		assert.Empty(t, reports)
+
+		time.Sleep(20 * time.Millisecond)
+		watchdog.Check()
+		lt := <-reports
+		assert.Equal(t, TxID(ctx), lt.ID)
+		assert.GreaterOrEqual(t, lt.Duration, 10*time.Millisecond)
+		assert.Equal(t, "INSERT INTO models (id) VALUES (?)", lt.LastStatement)
+		assert.Contains(t, string(lt.Stack), "TestWatchdog")
+
+		// a transaction is reported once
+		watchdog.Check()
+		assert.Empty(t, reports)
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Empty(t, watchdog.open)
+}
+
+func TestWatchdog_run(t *testing.T) {
+	db := openTimeoutTestDB(t)
+	reports := make(chan LongTransaction, 1)
+	watchdog := NewWatchdog(time.Millisecond, func(lt LongTransaction) {
+		reports <- lt
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go watchdog.Run(ctx, time.Millisecond)
+
+	err := NewSession(db, WithWatchdog(watchdog)).WithTransaction(context.Background(), func(ctx context.Context) error {
+		select {
+		case lt := <-reports:
+			assert.Equal(t, TxID(ctx), lt.ID)
+		case <-time.After(time.Second):
+			t.Error("long transaction not reported")
+		}
+		return nil
+	})
+	require.NoError(t, err)
+}
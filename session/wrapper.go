@@ -10,25 +10,66 @@ type Database[T any] interface {
 	ConvertTx(ctx context.Context, tx *sql.Tx) T
 }
 
+// ContextBinder is implemented by databases whose handles carry the context they were converted with.
+// The handle converted from a transaction is cached for the whole transaction,
+// and BindContext adapts it to the context of every later GetDB call.
+type ContextBinder[T any] interface {
+	BindContext(ctx context.Context, db T) T
+}
+
+// CacheSkipper is implemented by databases converting a transaction more cheaply than the wrapper
+// looks up the handle it cached. When SkipCache returns true, the wrapper converts on every GetDB call.
+type CacheSkipper interface {
+	SkipCache() bool
+}
+
 type DBWrapper[T any] interface {
 	GetDB(ctx context.Context) T
 }
 
 func NewDBWrapper[T any](db Database[T]) DBWrapper[T] {
+	binder, _ := db.(ContextBinder[T])
+	skipper, _ := db.(CacheSkipper)
 	return &wrapper[T]{
-		db: db,
+		db:        db,
+		binder:    binder,
+		skipCache: skipper != nil && skipper.SkipCache(),
 	}
 }
 
 type wrapper[T any] struct {
-	db Database[T]
+	db        Database[T]
+	binder    ContextBinder[T]
+	skipCache bool
 }
 
+// GetDB returns the handle of the transaction carried by ctx, or of the database when there is none.
+// The handle of a transaction started by a session is converted once and cached until the transaction ends,
+// unless the database skips the cache.
 func (w *wrapper[T]) GetDB(ctx context.Context) T {
-	val := GetTx(ctx)
-	tx, ok := val.(*sql.Tx)
-	if !ok || tx == nil {
+	st := getState(ctx)
+	if st == nil {
+		tx, ok := GetTx(ctx).(*sql.Tx)
+		if !ok || tx == nil {
+			return w.db.GetDB(ctx)
+		}
+		return w.db.ConvertTx(ctx, tx)
+	}
+
+	root := st.root()
+	if root.tx == nil {
 		return w.db.GetDB(ctx)
 	}
-	return w.db.ConvertTx(ctx, tx)
+	if w.skipCache {
+		return w.db.ConvertTx(ctx, root.tx)
+	}
+	if h, ok := root.handle(w); ok {
+		if w.binder != nil {
+			return w.binder.BindContext(ctx, h.(T))
+		}
+		return h.(T)
+	}
+	h := w.db.ConvertTx(ctx, root.tx)
+	root.setHandle(w, h)
+	return h
 }
@@ -55,6 +55,87 @@ func (s *WrapperTestSuite) TestGetDB_WithTransaction() {
 	s.Equal(tx, result)
 }
 
+func (s *WrapperTestSuite) TestGetDB_cachedPerTransaction() {
+	db := &countingDatabase{DB: NewDatabase(s.db, WithTxGuard())}
+	wrapper := NewDBWrapper[Executor](db)
+	sess := NewSession(s.db)
+
+	for i := 0; i < 2; i++ {
+		err := sess.WithTransaction(context.Background(), func(ctx context.Context) error {
+			executor := wrapper.GetDB(ctx)
+			s.Same(executor, wrapper.GetDB(ctx))
+			return sess.WithTransaction(ctx, func(ctx context.Context) error {
+				s.Same(executor, wrapper.GetDB(ctx))
+				return nil
+			}, WithPropagation(PropagationNested))
+		})
+		s.Require().NoError(err)
+	}
+	s.Equal(2, db.converted, "converted once per transaction")
+}
+
+func (s *WrapperTestSuite) TestGetDB_plainNotCached() {
+	db := &countingDatabase{DB: NewDatabase(s.db)}
+	wrapper := NewDBWrapper[Executor](db)
+	sess := NewSession(s.db)
+
+	err := sess.WithTransaction(context.Background(), func(ctx context.Context) error {
+		s.Equal(GetTx(ctx), wrapper.GetDB(ctx))
+		s.Equal(GetTx(ctx), wrapper.GetDB(ctx))
+		return nil
+	})
+	s.Require().NoError(err)
+	s.Equal(2, db.converted, "the transaction is returned as is on every call")
+}
+
+type countingDatabase struct {
+	*DB
+	converted int
+}
+
+func (db *countingDatabase) ConvertTx(ctx context.Context, tx *sql.Tx) Executor {
+	db.converted++
+	return db.DB.ConvertTx(ctx, tx)
+}
+
 func TestWrapperTestSuite(t *testing.T) {
 	suite.Run(t, new(WrapperTestSuite))
 }
+
+func BenchmarkGetDB(b *testing.B) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer db.Close()
+	tx, err := db.Begin()
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer tx.Rollback()
+	wrapper := NewDB(db)
+
+	b.Run("cached", func(b *testing.B) {
+		wrapper := NewDB(db, WithTxGuard())
+		ctx := WithExternalTx(context.Background(), tx, PropagationRequired)
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			_ = wrapper.GetDB(ctx)
+		}
+	})
+	b.Run("uncached", func(b *testing.B) {
+		adapter := NewDatabase(db, WithTxGuard())
+		ctx := WithExternalTx(context.Background(), tx, PropagationRequired)
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			_ = adapter.ConvertTx(ctx, tx)
+		}
+	})
+	b.Run("skipped", func(b *testing.B) {
+		ctx := WithExternalTx(context.Background(), tx, PropagationRequired)
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			_ = wrapper.GetDB(ctx)
+		}
+	})
+}
@@ -0,0 +1,164 @@
+package transaction
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/aeramu/sql-transaction/session"
+)
+
+// WithInterceptors runs every statement of the executors, in a transaction or not, through interceptors,
+// the first one outermost
+func WithInterceptors(interceptors ...session.Interceptor) Option {
+	return func(db *DB) {
+		db.interceptors = append(db.interceptors, interceptors...)
+	}
+}
+
+// interceptedExecutor runs the statements of the embedded executor through interceptors
+type interceptedExecutor struct {
+	Executor
+	interceptors []session.Interceptor
+}
+
+func (e *interceptedExecutor) Exec(query string, args ...any) (sql.Result, error) {
+	return e.ExecContext(context.Background(), query, args...)
+}
+
+func (e *interceptedExecutor) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	stmt, err := session.RunStatement(ctx, e.interceptors, session.OpExec, query, args, func(ctx context.Context, stmt *session.Statement) error {
+		var err error
+		stmt.Result, err = e.Executor.ExecContext(ctx, stmt.Query, stmt.Args...)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return stmt.Result, nil
+}
+
+func (e *interceptedExecutor) Query(query string, args ...any) (*sql.Rows, error) {
+	return e.QueryContext(context.Background(), query, args...)
+}
+
+func (e *interceptedExecutor) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	var rows *sql.Rows
+	_, err := session.RunStatement(ctx, e.interceptors, session.OpQuery, query, args, func(ctx context.Context, stmt *session.Statement) error {
+		var err error
+		rows, err = e.Executor.QueryContext(ctx, stmt.Query, stmt.Args...)
+		return err
+	})
+	if err != nil {
+		if rows != nil {
+			rows.Close()
+		}
+		return nil, err
+	}
+	return rows, nil
+}
+
+func (e *interceptedExecutor) Queryx(query string, args ...any) (*sqlx.Rows, error) {
+	return e.QueryxContext(context.Background(), query, args...)
+}
+
+func (e *interceptedExecutor) QueryxContext(ctx context.Context, query string, args ...any) (*sqlx.Rows, error) {
+	var rows *sqlx.Rows
+	_, err := session.RunStatement(ctx, e.interceptors, session.OpQuery, query, args, func(ctx context.Context, stmt *session.Statement) error {
+		var err error
+		rows, err = e.Executor.QueryxContext(ctx, stmt.Query, stmt.Args...)
+		return err
+	})
+	if err != nil {
+		if rows != nil {
+			rows.Close()
+		}
+		return nil, err
+	}
+	return rows, nil
+}
+
+func (e *interceptedExecutor) QueryRowx(query string, args ...any) *sqlx.Row {
+	return e.QueryRowxContext(context.Background(), query, args...)
+}
+
+func (e *interceptedExecutor) QueryRowxContext(ctx context.Context, query string, args ...any) *sqlx.Row {
+	var row *sqlx.Row
+	_, err := session.RunStatement(ctx, e.interceptors, session.OpQueryRow, query, args, func(ctx context.Context, stmt *session.Statement) error {
+		row = e.Executor.QueryRowxContext(ctx, stmt.Query, stmt.Args...)
+		return row.Err()
+	})
+	if row == nil || (err != nil && row.Err() == nil) {
+		if err == nil {
+			// an interceptor returned without running the statement, which then finds no row like session.ErrorRow
+			err = sql.ErrNoRows
+		}
+		return errorDB.QueryRowxContext(ctx, "", err)
+	}
+	return row
+}
+
+func (e *interceptedExecutor) Prepare(query string) (*sql.Stmt, error) {
+	return e.PrepareContext(context.Background(), query)
+}
+
+func (e *interceptedExecutor) PrepareContext(ctx context.Context, query string) (*sql.Stmt, error) {
+	var prepared *sql.Stmt
+	_, err := session.RunStatement(ctx, e.interceptors, session.OpPrepare, query, nil, func(ctx context.Context, stmt *session.Statement) error {
+		var err error
+		prepared, err = e.Executor.PrepareContext(ctx, stmt.Query)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return prepared, nil
+}
+
+// errorDB fails every query with the error passed as its single argument,
+// the only way to build a *sqlx.Row carrying an error
+var errorDB = sqlx.NewDb(sql.OpenDB(errorConnector{}), "")
+
+type errorConnector struct{}
+
+func (errorConnector) Connect(context.Context) (driver.Conn, error) {
+	return errorConn{}, nil
+}
+
+func (errorConnector) Driver() driver.Driver {
+	return errorDriver{}
+}
+
+type errorDriver struct{}
+
+func (errorDriver) Open(string) (driver.Conn, error) {
+	return errorConn{}, nil
+}
+
+var errErrorConn = errors.New("error rows only support queries")
+
+type errorConn struct{}
+
+func (errorConn) Prepare(string) (driver.Stmt, error) {
+	return nil, errErrorConn
+}
+
+func (errorConn) Close() error {
+	return nil
+}
+
+func (errorConn) Begin() (driver.Tx, error) {
+	return nil, errErrorConn
+}
+
+// CheckNamedValue passes the error through unconverted
+func (errorConn) CheckNamedValue(*driver.NamedValue) error {
+	return nil
+}
+
+func (errorConn) QueryContext(_ context.Context, _ string, args []driver.NamedValue) (driver.Rows, error) {
+	return nil, args[0].Value.(error)
+}
@@ -0,0 +1,178 @@
+package transaction
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/aeramu/sql-transaction/session"
+)
+
+func openInterceptorTestDB(t *testing.T) (*sql.DB, *sqlx.DB) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	return setupInterceptorTestDB(t, db)
+}
+
+// setupInterceptorTestDB creates the table of the tests in db, which is closed once the test completes
+func setupInterceptorTestDB(t *testing.T, db *sql.DB) (*sql.DB, *sqlx.DB) {
+	t.Cleanup(func() { db.Close() })
+	db.SetMaxOpenConns(1)
+
+	sqlxDB := sqlx.NewDb(db, "sqlite3")
+	_, err := sqlxDB.Exec(`CREATE TABLE model (id TEXT PRIMARY KEY)`)
+	require.NoError(t, err)
+	return db, sqlxDB
+}
+
+func TestInterceptors_statement(t *testing.T) {
+	db, sqlxDB := openInterceptorTestDB(t)
+	var seen []session.Statement
+	wrapper := New(sqlxDB, WithTxGuard(), WithInterceptors(func(ctx context.Context, stmt *session.Statement, next session.StatementHandler) error {
+		err := next(ctx, stmt)
+		seen = append(seen, *stmt)
+		return err
+	}))
+
+	var txID string
+	err := session.NewSession(db).WithTransaction(context.Background(), func(ctx context.Context) error {
+		txID = session.TxID(ctx)
+		_, err := wrapper.GetDB(ctx).ExecContext(ctx, "INSERT INTO model (id) VALUES (?)", "a")
+		return err
+	})
+	require.NoError(t, err)
+
+	var models []model
+	require.NoError(t, sqlx.Select(wrapper.GetDB(context.Background()), &models, "SELECT id FROM model"))
+	assert.Equal(t, []model{{ID: "a"}}, models)
+	var m model
+	require.NoError(t, sqlx.Get(wrapper.GetDB(context.Background()), &m, "SELECT id FROM model WHERE id = ?", "a"))
+
+	require.Len(t, seen, 3)
+	assert.Equal(t, session.OpExec, seen[0].Op)
+	assert.Equal(t, "INSERT INTO model (id) VALUES (?)", seen[0].Query)
+	assert.Equal(t, []any{"a"}, seen[0].Args)
+	assert.Equal(t, txID, seen[0].TxID)
+	require.NotNil(t, seen[0].Result)
+
+	assert.Equal(t, session.OpQuery, seen[1].Op)
+	assert.Empty(t, seen[1].TxID)
+	assert.Equal(t, session.OpQueryRow, seen[2].Op)
+}
+
+func TestInterceptors_reject(t *testing.T) {
+	_, sqlxDB := openInterceptorTestDB(t)
+	errRejected := errors.New("rejected")
+	executor := New(sqlxDB, WithInterceptors(func(ctx context.Context, stmt *session.Statement, next session.StatementHandler) error {
+		return errRejected
+	})).GetDB(context.Background())
+
+	_, err := executor.Exec("INSERT INTO model (id) VALUES (?)", "a")
+	assert.ErrorIs(t, err, errRejected)
+	_, err = executor.Queryx("SELECT id FROM model")
+	assert.ErrorIs(t, err, errRejected)
+	var m model
+	assert.ErrorIs(t, executor.QueryRowx("SELECT id FROM model").StructScan(&m), errRejected)
+
+	var count int
+	require.NoError(t, sqlxDB.Get(&count, "SELECT COUNT(*) FROM model"))
+	assert.Zero(t, count)
+}
+
+func TestInterceptors_shortCircuit(t *testing.T) {
+	_, sqlxDB := openInterceptorTestDB(t)
+	executor := New(sqlxDB, WithInterceptors(func(ctx context.Context, stmt *session.Statement, next session.StatementHandler) error {
+		return nil
+	})).GetDB(context.Background())
+
+	var m model
+	assert.ErrorIs(t, executor.QueryRowx("SELECT id FROM model").StructScan(&m), sql.ErrNoRows)
+}
+
+func TestInterceptors_queryComment(t *testing.T) {
+	db, sqlxDB := openInterceptorTestDB(t)
+	var queries []string
+	wrapper := New(sqlxDB, WithInterceptors(session.QueryComment(), func(ctx context.Context, stmt *session.Statement, next session.StatementHandler) error {
+		queries = append(queries, stmt.Query)
+		return next(ctx, stmt)
+	}))
+
+	ctx := session.WithQueryTag(context.Background(), "route", "/models")
+	var txID string
+	err := session.NewSession(db).WithTransaction(ctx, func(ctx context.Context) error {
+		txID = session.TxID(ctx)
+		_, err := wrapper.GetDB(ctx).ExecContext(ctx, "INSERT INTO model (id) VALUES (?)", "a")
+		return err
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"INSERT INTO model (id) VALUES (?) /*route='%2Fmodels',tx_id='" + txID + "'*/"}, queries)
+	var count int
+	require.NoError(t, sqlxDB.Get(&count, "SELECT COUNT(*) FROM model"))
+	assert.Equal(t, 1, count)
+}
+
+func TestInterceptors_enforceReadOnly(t *testing.T) {
+	db, sqlxDB := openInterceptorTestDB(t)
+	wrapper := New(sqlxDB, WithInterceptors(session.EnforceReadOnly()))
+
+	err := session.NewSession(db).WithTransaction(context.Background(), func(ctx context.Context) error {
+		var models []model
+		require.NoError(t, sqlx.SelectContext(ctx, wrapper.GetDB(ctx), &models, "SELECT id FROM model"))
+		_, err := wrapper.GetDB(ctx).ExecContext(ctx, "INSERT INTO model (id) VALUES (?)", "a")
+		return err
+	}, session.WithReadOnly())
+	assert.ErrorIs(t, err, session.ErrReadOnlyViolation)
+
+	var count int
+	require.NoError(t, sqlxDB.Get(&count, "SELECT COUNT(*) FROM model"))
+	assert.Zero(t, count)
+}
+
+func TestInterceptors_trackStatements(t *testing.T) {
+	tests := []struct {
+		name string
+		open func() *sql.DB
+	}{
+		{"driver", func() *sql.DB {
+			db, err := sql.Open("sqlite3", ":memory:")
+			require.NoError(t, err)
+			return db
+		}},
+		// the driver routes no statement of the transaction reaching its own connection, so none is counted twice
+		{"wrapped driver", func() *sql.DB {
+			return sql.OpenDB(session.Connector(&sqlite3.SQLiteDriver{}, ":memory:"))
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, sqlxDB := setupInterceptorTestDB(t, tt.open())
+			wrapper := New(sqlxDB)
+			registry := session.NewRegistry()
+
+			err := session.NewSession(db, session.WithRegistry(registry)).WithTransaction(context.Background(), func(ctx context.Context) error {
+				tx := wrapper.GetDB(ctx)
+				if _, err := tx.ExecContext(ctx, "INSERT INTO model (id) VALUES (?)", "a"); err != nil {
+					return err
+				}
+				var m model
+				if err := sqlx.GetContext(ctx, tx, &m, "SELECT id FROM model WHERE id = ?", "a"); err != nil {
+					return err
+				}
+
+				snapshot := registry.Snapshot()
+				require.Len(t, snapshot, 1)
+				assert.Equal(t, int64(2), snapshot[0].Statements)
+				assert.Equal(t, "SELECT id FROM model WHERE id = ?", snapshot[0].LastStatement)
+				return nil
+			})
+			require.NoError(t, err)
+		})
+	}
+}
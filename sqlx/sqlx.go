@@ -16,22 +16,115 @@ type Executor interface {
 	sqlx.PreparerContext
 }
 
-func New(db *sqlx.DB) session.DBWrapper[Executor] {
-	return session.NewDBWrapper(&DB{
+func New(db *sqlx.DB, opts ...Option) session.DBWrapper[Executor] {
+	d := &DB{
 		db: db,
-	})
+	}
+	for _, opt := range opts {
+		opt(d)
+	}
+	return session.NewDBWrapper(d)
+}
+
+// Option configures the sqlx adapter
+type Option func(*DB)
+
+// WithTxGuard makes the executor of a transaction return session.ErrTxFinished once the transaction ended,
+// instead of running statements on a committed or rolled back transaction.
+// QueryRowx cannot carry the error and still reports sql.ErrTxDone from Scan.
+func WithTxGuard() Option {
+	return func(db *DB) {
+		db.guard = true
+	}
 }
 
 type DB struct {
-	db *sqlx.DB
+	db    *sqlx.DB
+	guard bool
+
+	interceptors []session.Interceptor
 }
 
 func (s *DB) ConvertTx(ctx context.Context, tx *sql.Tx) Executor {
-	return &sqlx.Tx{
-		Tx: tx,
+	var executor Executor = &sqlx.Tx{
+		Tx:     tx,
+		Mapper: s.db.Mapper,
+	}
+	interceptors := s.interceptors
+	if track := session.TrackStatements(session.Current(ctx)); track != nil {
+		interceptors = append([]session.Interceptor{track}, interceptors...)
+	}
+	if len(interceptors) > 0 {
+		executor = &interceptedExecutor{Executor: executor, interceptors: interceptors}
 	}
+	if s.guard {
+		return &guardedTx{Executor: executor, guard: session.NewTxGuard(session.Current(ctx))}
+	}
+	return executor
 }
 
 func (s *DB) GetDB(ctx context.Context) Executor {
+	interceptors := s.interceptors
+	// the statements of a fake transaction run on the database
+	if track := session.TrackStatements(session.Current(ctx)); track != nil {
+		interceptors = append([]session.Interceptor{track}, interceptors...)
+	}
+	if len(interceptors) > 0 {
+		return &interceptedExecutor{Executor: s.db, interceptors: interceptors}
+	}
 	return s.db
 }
+
+// guardedTx runs statements on the embedded transaction executor until its transaction ended
+type guardedTx struct {
+	Executor
+	guard session.TxGuard
+}
+
+func (g *guardedTx) Exec(query string, args ...any) (sql.Result, error) {
+	return g.ExecContext(context.Background(), query, args...)
+}
+
+func (g *guardedTx) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	if err := g.guard.Check(); err != nil {
+		return nil, err
+	}
+	res, err := g.Executor.ExecContext(ctx, query, args...)
+	return res, g.guard.Wrap(err)
+}
+
+func (g *guardedTx) Query(query string, args ...any) (*sql.Rows, error) {
+	return g.QueryContext(context.Background(), query, args...)
+}
+
+func (g *guardedTx) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	if err := g.guard.Check(); err != nil {
+		return nil, err
+	}
+	rows, err := g.Executor.QueryContext(ctx, query, args...)
+	return rows, g.guard.Wrap(err)
+}
+
+func (g *guardedTx) Queryx(query string, args ...any) (*sqlx.Rows, error) {
+	return g.QueryxContext(context.Background(), query, args...)
+}
+
+func (g *guardedTx) QueryxContext(ctx context.Context, query string, args ...any) (*sqlx.Rows, error) {
+	if err := g.guard.Check(); err != nil {
+		return nil, err
+	}
+	rows, err := g.Executor.QueryxContext(ctx, query, args...)
+	return rows, g.guard.Wrap(err)
+}
+
+func (g *guardedTx) Prepare(query string) (*sql.Stmt, error) {
+	return g.PrepareContext(context.Background(), query)
+}
+
+func (g *guardedTx) PrepareContext(ctx context.Context, query string) (*sql.Stmt, error) {
+	if err := g.guard.Check(); err != nil {
+		return nil, err
+	}
+	stmt, err := g.Executor.PrepareContext(ctx, query)
+	return stmt, g.guard.Wrap(err)
+}
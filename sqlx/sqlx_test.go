@@ -3,12 +3,16 @@ package transaction
 import (
 	"context"
 	"database/sql"
+	"database/sql/driver"
 	"errors"
+	"path/filepath"
 	"testing"
 
 	"github.com/aeramu/sql-transaction/session"
+	"github.com/aeramu/sql-transaction/session/sessionfault"
+	"github.com/aeramu/sql-transaction/session/sessiontest"
 	"github.com/jmoiron/sqlx"
-	_ "github.com/mattn/go-sqlite3"
+	"github.com/mattn/go-sqlite3"
 	"github.com/stretchr/testify/suite"
 )
 
@@ -82,6 +86,21 @@ func (s *TransactionTestSuite) TestWithTransaction_errPassed() {
 	s.ErrorIs(err, expectedErr)
 }
 
+func (s *TransactionTestSuite) TestWithTransaction_structScan() {
+	_, err := s.sqlxDB.Exec(`INSERT INTO model (id) VALUES (?)`, "scanned")
+	s.Require().NoError(err)
+
+	err = s.session.WithTransaction(context.Background(), func(ctx context.Context) error {
+		var m model
+		if err := sqlx.GetContext(ctx, s.wrapper.GetDB(ctx), &m, `SELECT * FROM model WHERE id = ?`, "scanned"); err != nil {
+			return err
+		}
+		s.Equal(model{ID: "scanned"}, m)
+		return nil
+	})
+	s.NoError(err)
+}
+
 func (s *TransactionTestSuite) TestWithTransaction_transacitonCommited() {
 	data := model{ID: "test-transaciton-commited"}
 	err := s.session.WithTransaction(context.Background(), func(ctx context.Context) error {
@@ -185,6 +204,141 @@ func (s *TransactionTestSuite) TestWithTransaction_doubleTransactionRolledBack()
 	s.ErrorIs(err, sql.ErrNoRows)
 }
 
+func (s *TransactionTestSuite) TestSessiontest_rolledBackAfterTest() {
+	data := model{ID: "test-sessiontest"}
+	s.Run("writes", func() {
+		ctx := sessiontest.Begin(s.T(), s.db)
+		err := s.session.WithTransaction(ctx, func(ctx context.Context) error {
+			_, err := s.wrapper.GetDB(ctx).Exec(`INSERT INTO model (id) VALUES (?)`, data.ID)
+			return err
+		})
+		s.NoError(err)
+
+		var inserted model
+		err = sqlx.Get(s.wrapper.GetDB(ctx), &inserted, `SELECT * FROM model WHERE id = ?`, data.ID)
+		s.NoError(err)
+		s.Equal(data, inserted)
+	})
+
+	var inserted model
+	err := s.sqlxDB.Get(&inserted, `SELECT * FROM model WHERE id = ?`, data.ID)
+	s.ErrorIs(err, sql.ErrNoRows)
+}
+
+func (s *TransactionTestSuite) TestGetDB_cachedPerTransaction() {
+	var first Executor
+	err := s.session.WithTransaction(context.Background(), func(ctx context.Context) error {
+		first = s.wrapper.GetDB(ctx)
+		s.Same(first, s.wrapper.GetDB(ctx))
+		s.Zero(testing.AllocsPerRun(10, func() { s.wrapper.GetDB(ctx) }))
+		return nil
+	})
+	s.Require().NoError(err)
+
+	err = s.session.WithTransaction(context.Background(), func(ctx context.Context) error {
+		s.NotSame(first, s.wrapper.GetDB(ctx))
+		return nil
+	})
+	s.Require().NoError(err)
+}
+
+func (s *TransactionTestSuite) TestGetDB_txGuard() {
+	wrapper := New(s.sqlxDB, WithTxGuard())
+	var executor Executor
+	err := s.session.WithTransaction(context.Background(), func(ctx context.Context) error {
+		executor = wrapper.GetDB(ctx)
+		_, err := executor.ExecContext(ctx, `INSERT INTO model (id) VALUES (?)`, "guard")
+		return err
+	})
+	s.Require().NoError(err)
+
+	_, err = executor.Exec(`INSERT INTO model (id) VALUES (?)`, "guard-late")
+	s.ErrorIs(err, session.ErrTxFinished)
+	_, err = executor.Queryx(`SELECT * FROM model`)
+	s.ErrorIs(err, session.ErrTxFinished)
+}
+
 func TestTransactionTestSuite(t *testing.T) {
 	suite.Run(t, new(TransactionTestSuite))
 }
+
+func TestAdapterConformance(t *testing.T) {
+	sessiontest.RunAdapterSuite(t, func(t *testing.T) sessiontest.Adapter[Executor] {
+		db, err := sql.Open("sqlite3", "file:"+filepath.Join(t.TempDir(), "test.db")+"?_busy_timeout=5000&_txlock=immediate")
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { db.Close() })
+
+		return sessiontest.Adapter[Executor]{
+			DB:       db,
+			Database: &DB{db: sqlx.NewDb(db, "sqlite3")},
+			Exec: func(ctx context.Context, db Executor, query string, args ...any) error {
+				_, err := db.ExecContext(ctx, query, args...)
+				return err
+			},
+			QueryInt: func(ctx context.Context, db Executor, query string, args ...any) (int64, error) {
+				var n int64
+				err := sqlx.GetContext(ctx, db, &n, query, args...)
+				return n, err
+			},
+		}
+	})
+}
+
+func TestWithTransaction_commitFault(t *testing.T) {
+	in := sessionfault.New(sessionfault.Fail(sessionfault.OpCommit, driver.ErrBadConn))
+	db := in.OpenDB(&sqlite3.SQLiteDriver{}, "file:"+filepath.Join(t.TempDir(), "test.db")+"?_busy_timeout=5000")
+	defer db.Close()
+
+	if _, err := db.Exec("CREATE TABLE model (id VARCHAR(64) PRIMARY KEY)"); err != nil {
+		t.Fatal(err)
+	}
+	wrapper := New(sqlx.NewDb(db, "sqlite3"))
+
+	err := session.NewSession(db).WithTransaction(context.Background(), func(ctx context.Context) error {
+		_, err := wrapper.GetDB(ctx).ExecContext(ctx, "INSERT INTO model (id) VALUES (?)", "1")
+		return err
+	})
+	if !errors.Is(err, driver.ErrBadConn) {
+		t.Fatalf("WithTransaction: got %v, want %v", err, driver.ErrBadConn)
+	}
+
+	var n int
+	if err := db.QueryRow("SELECT COUNT(*) FROM model").Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	if n != 0 {
+		t.Errorf("got %d rows, want 0", n)
+	}
+}
+
+func BenchmarkGetDB(b *testing.B) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer db.Close()
+	tx, err := db.Begin()
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer tx.Rollback()
+	wrapper := New(sqlx.NewDb(db, "sqlite3"))
+
+	b.Run("cached", func(b *testing.B) {
+		ctx := session.WithExternalTx(context.Background(), tx, session.PropagationRequired)
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			_ = wrapper.GetDB(ctx)
+		}
+	})
+	b.Run("uncached", func(b *testing.B) {
+		adapter := &DB{db: sqlx.NewDb(db, "sqlite3")}
+		ctx := session.WithExternalTx(context.Background(), tx, session.PropagationRequired)
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			_ = adapter.ConvertTx(ctx, tx)
+		}
+	})
+}